@@ -1,8 +1,11 @@
 package eventsourcing
 
 import (
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"reflect"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -13,9 +16,17 @@ type Version uint64
 
 // AggregateRoot to be included into aggregates
 type AggregateRoot struct {
-	aggregateID      uuid.UUID
-	aggregateVersion Version
-	aggregateEvents  []Event
+	aggregateID            uuid.UUID
+	aggregateVersion       Version
+	aggregateGlobalVersion uuid.UUID
+	// aggregateSnapshotVersion is the version of the last snapshot the aggregate was loaded from or saved to
+	aggregateSnapshotVersion Version
+	aggregateEvents          []Event
+	// idempotencyKeys holds the keys of the events tracked with TrackChangeIdempotent since the last save
+	idempotencyKeys map[string]struct{}
+	// aggregatePath is the package path of the concrete aggregate, set when a change is tracked or the aggregate is
+	// built
+	aggregatePath string
 }
 
 var emptyAggregateID uuid.UUID = uuid.Nil
@@ -23,49 +34,192 @@ var emptyAggregateID uuid.UUID = uuid.Nil
 // ErrAggregateAlreadyExists returned if the aggregateID is set more than one time
 var ErrAggregateAlreadyExists = errors.New("its not possible to set ID on already existing aggregate")
 
+// ErrVersionGap returned from BuildFromHistoryStrict if the event versions are not contiguous
+var ErrVersionGap = errors.New("gap in event versions")
+
+// ErrFutureTimestamp returned from TrackChangeAt if the timestamp is in the future
+var ErrFutureTimestamp = errors.New("event timestamp in the future")
+
+// futureTimestampTolerance is how far in the future a timestamp given to TrackChangeAt may be, negative disables
+// the check. It holds a time.Duration and is accessed atomically as it's read on every TrackChangeAt.
+var futureTimestampTolerance int64
+
+// SetFutureTimestampTolerance sets how far in the future a timestamp given to TrackChangeAt may be to allow for
+// clock skew between systems, the default is 0. A negative tolerance disables the check.
+func SetFutureTimestampTolerance(d time.Duration) {
+	atomic.StoreInt64(&futureTimestampTolerance, int64(d))
+}
+
+// Validator is an optional interface for aggregates to enforce invariants. Validate is called after an event
+// is applied in TrackChangeChecked and TrackChangeWithMetadataChecked, and by Repository.Save before the events
+// are saved.
+type Validator interface {
+	Validate() error
+}
+
+// New constructs an aggregate with the factory, sets the id if it's not nil and tracks the first event. It
+// replaces the constructor boilerplate of TrackChange and SetID in aggregates.
+func New[T Aggregate](factory func() T, id *uuid.UUID, firstEvent interface{}) (T, error) {
+	var empty T
+	a := factory()
+	if id != nil {
+		if err := a.Root().SetID(*id); err != nil {
+			return empty, err
+		}
+	}
+	if err := a.Root().TrackChangeChecked(a, firstEvent); err != nil {
+		return empty, err
+	}
+	return a, nil
+}
+
 // TrackChange is used internally by behaviour methods to apply a state change to
 // the current instance and also track it in order that it can be persisted later.
+// Registered validators and the Validator of the aggregate are run on Save, use TrackChangeChecked to reject the
+// event when it's tracked.
 func (ar *AggregateRoot) TrackChange(a Aggregate, data interface{}) {
 	ar.TrackChangeWithMetadata(a, data, nil)
 }
 
+// TrackChangeChecked is TrackChange that runs the validators registered for the event and the Validator of the
+// aggregate. An error is returned if the event is rejected, it's then not tracked.
+func (ar *AggregateRoot) TrackChangeChecked(a Aggregate, data interface{}) error {
+	return ar.TrackChangeWithMetadataChecked(a, data, nil)
+}
+
+// TrackChangeIdempotent is TrackChange guarded by an idempotency key, if an event with the key is already tracked
+// on the aggregate since it was last saved the call does nothing. It protects against command handlers that by
+// mistake track the same logical event twice.
+func (ar *AggregateRoot) TrackChangeIdempotent(a Aggregate, data interface{}, key string) error {
+	if _, ok := ar.idempotencyKeys[key]; ok {
+		return nil
+	}
+	err := ar.TrackChangeChecked(a, data)
+	if err != nil {
+		return err
+	}
+	if ar.idempotencyKeys == nil {
+		ar.idempotencyKeys = make(map[string]struct{})
+	}
+	ar.idempotencyKeys[key] = struct{}{}
+	return nil
+}
+
 // TrackChangeWithMetadata is used internally by behaviour methods to apply a state change to
 // the current instance and also track it in order that it can be persisted later.
 // metadata is handled by this func to store none related application state
+// Registered validators and the Validator of the aggregate are run on Save, use TrackChangeWithMetadataChecked to
+// reject the event when it's tracked.
 func (ar *AggregateRoot) TrackChangeWithMetadata(a Aggregate, data interface{}, metadata map[string]interface{}) {
+	ar.trackChange(a, data, metadata, time.Now().UTC(), false)
+}
+
+// TrackChangeWithMetadataChecked is TrackChangeWithMetadata that validates the event.
+// An error is returned if a validator registered for the event rejects the data, the event is then
+// not tracked and not applied on the aggregate.
+// If the aggregate implements Validator it's validated after the event is applied, on error the event is
+// removed from the tracked events and the error returned. Undoing the state change made in Transition is
+// the responsibility of the caller, e.g. by discarding the aggregate and fetching it again.
+func (ar *AggregateRoot) TrackChangeWithMetadataChecked(a Aggregate, data interface{}, metadata map[string]interface{}) error {
+	return ar.trackChange(a, data, metadata, time.Now().UTC(), true)
+}
+
+// TrackChangeAt is TrackChangeChecked with the timestamp of the event set to ts instead of now, used when importing
+// historical events that should keep their original timestamp. ErrFutureTimestamp is returned if ts is in the
+// future, see SetFutureTimestampTolerance.
+func (ar *AggregateRoot) TrackChangeAt(a Aggregate, data interface{}, ts time.Time) error {
+	return ar.TrackChangeAtWithMetadata(a, data, nil, ts)
+}
+
+// TrackChangeAtWithMetadata is TrackChangeWithMetadataChecked with the timestamp of the event set to ts instead of now
+func (ar *AggregateRoot) TrackChangeAtWithMetadata(a Aggregate, data interface{}, metadata map[string]interface{}, ts time.Time) error {
+	tolerance := time.Duration(atomic.LoadInt64(&futureTimestampTolerance))
+	if tolerance >= 0 && ts.After(time.Now().Add(tolerance)) {
+		return fmt.Errorf("%w: %s", ErrFutureTimestamp, ts.UTC().Format(time.RFC3339Nano))
+	}
+	return ar.trackChange(a, data, metadata, ts.UTC(), true)
+}
+
+// trackChange applies and tracks the event with the timestamp, the event is validated if check is set
+func (ar *AggregateRoot) trackChange(a Aggregate, data interface{}, metadata map[string]interface{}, ts time.Time, check bool) error {
+	if check {
+		if err := validateData(aggregateName(a), data); err != nil {
+			return err
+		}
+	}
+	id := ar.aggregateID
 	// This can be overwritten in the constructor of the aggregate
 	if ar.aggregateID == emptyAggregateID {
 		ar.aggregateID = idFunc()
 	}
 
-	name := reflect.TypeOf(a).Elem().Name()
+	name := aggregateName(a)
+	ar.aggregatePath = aggregatePath(a)
 	event := Event{
 		EventID:       NewUuid(),
 		AggregateID:   ar.aggregateID,
 		Version:       ar.nextVersion(),
 		AggregateType: name,
-		Timestamp:     time.Now().UTC(),
+		Timestamp:     ts,
 		Data:          data,
 		Metadata:      metadata,
 	}
 	ar.aggregateEvents = append(ar.aggregateEvents, event)
 	a.Transition(event)
+	if v, ok := a.(Validator); ok && check {
+		if err := v.Validate(); err != nil {
+			ar.aggregateEvents = ar.aggregateEvents[:len(ar.aggregateEvents)-1]
+			ar.aggregateID = id
+			return err
+		}
+	}
+	return nil
 }
 
-// BuildFromHistory builds the aggregate state from events
+// BuildFromHistory builds the aggregate state from events. The versions of the events are not checked, the
+// aggregate takes the version of the last event even if events are missing in between, which builds a wrong state
+// without notice. Use BuildFromHistoryStrict where the events are expected to continue from the aggregate version.
 func (ar *AggregateRoot) BuildFromHistory(a Aggregate, events []Event) {
+	ar.aggregatePath = aggregatePath(a)
 	for _, event := range events {
 		a.Transition(event)
 		//Set the aggregate ID
 		ar.aggregateID = event.AggregateID
 		// Make sure the aggregate is in the correct version (the last event)
 		ar.aggregateVersion = event.Version
+		ar.aggregateGlobalVersion = event.EventID
 	}
 }
 
-func (ar *AggregateRoot) setInternals(id uuid.UUID, version Version) {
+// BuildFromHistoryStrict builds the aggregate state from events that has to continue from the current version of
+// the aggregate, each event one version higher than the previous. ErrVersionGap is returned on a gap and no event
+// is applied.
+func (ar *AggregateRoot) BuildFromHistoryStrict(a Aggregate, events []Event) error {
+	version := ar.aggregateVersion
+	for _, event := range events {
+		if event.Version != version+1 {
+			return fmt.Errorf("%w: expected version %d got %d", ErrVersionGap, version+1, event.Version)
+		}
+		version = event.Version
+	}
+	ar.BuildFromHistory(a, events)
+	return nil
+}
+
+// ApplyExternal applies an event from another aggregate's stream on the aggregate state without tracking it as a
+// change of the aggregate. The version, global version and the tracked events are left untouched. It's meant for
+// process managers (sagas) that react on events from other aggregates and need them in their state for decisions,
+// the external event is not saved with the aggregate and has to be applied again when the aggregate is rebuilt.
+func (ar *AggregateRoot) ApplyExternal(a Aggregate, event Event) {
+	a.Transition(event)
+}
+
+func (ar *AggregateRoot) setInternals(path string, id uuid.UUID, version Version, globalVersion uuid.UUID) {
+	ar.aggregatePath = path
 	ar.aggregateID = id
 	ar.aggregateVersion = version
+	ar.aggregateGlobalVersion = globalVersion
+	ar.aggregateSnapshotVersion = version
 	ar.aggregateEvents = []Event{}
 }
 
@@ -79,14 +233,33 @@ func (ar *AggregateRoot) update() {
 	if len(ar.aggregateEvents) > 0 {
 		lastEvent := ar.aggregateEvents[len(ar.aggregateEvents)-1]
 		ar.aggregateVersion = lastEvent.Version
+		ar.aggregateGlobalVersion = lastEvent.EventID
 		ar.aggregateEvents = []Event{}
 	}
+	ar.idempotencyKeys = nil
 }
 
-// path return the full name of the aggregate making it unique to other aggregates with
-// the same name but placed in other packages.
+// path return the package path of the aggregate making it unique to other aggregates with
+// the same name but placed in other packages. It's set when a change is tracked on the aggregate or the aggregate
+// is built from history or a snapshot.
 func (ar *AggregateRoot) path() string {
-	return reflect.TypeOf(ar).Elem().PkgPath()
+	return ar.aggregatePath
+}
+
+// FullName returns the aggregate type name qualified with the package path of the aggregate, e.g.
+// "github.com/org/app/order.Order". Aggregates with the same name in different packages have different full
+// names while the aggregate type stored on the events is the name only.
+func (ar *AggregateRoot) FullName(a Aggregate) string {
+	return aggregatePath(a) + "." + aggregateName(a)
+}
+
+// aggregatePath returns the package path of the concrete aggregate type
+func aggregatePath(a interface{}) string {
+	t := reflect.TypeOf(a)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath()
 }
 
 // SetID opens up the possibility to set manual aggregate ID from the outside
@@ -116,6 +289,12 @@ func (ar *AggregateRoot) Version() Version {
 	return ar.aggregateVersion
 }
 
+// GlobalVersion returns the event id of the last saved event applied on the aggregate. Event ids are time ordered
+// and are the global position of the event.
+func (ar *AggregateRoot) GlobalVersion() uuid.UUID {
+	return ar.aggregateGlobalVersion
+}
+
 // Events return the aggregate events from the aggregate
 // make a copy of the slice preventing outsiders modifying events.
 func (ar *AggregateRoot) Events() []Event {
@@ -124,7 +303,36 @@ func (ar *AggregateRoot) Events() []Event {
 	return e
 }
 
+// PendingReasons returns the reasons of the unsaved events in the order they were tracked, used to inspect what
+// a command handler did without access to the event data
+func (ar *AggregateRoot) PendingReasons() []string {
+	reasons := make([]string, len(ar.aggregateEvents))
+	for i, event := range ar.aggregateEvents {
+		reasons[i] = event.Reason()
+	}
+	return reasons
+}
+
 // UnsavedEvents return true if there's unsaved events on the aggregate
 func (ar *AggregateRoot) UnsavedEvents() bool {
 	return len(ar.aggregateEvents) > 0
 }
+
+// StateHash returns the SHA-256 of the aggregate state serialized as in a snapshot, via the Marshal method of a
+// SnapshotAggregate or the exported fields. The aggregate ID and version are not part of the hash, aggregates with
+// the same state has the same hash. The serializer has to be deterministic, e.g. the one from NewJSONSerializer,
+// for the hash to be stable. It can be used to skip a snapshot write when the state has not changed.
+func (ar *AggregateRoot) StateHash(a Aggregate, ser Serializer) ([]byte, error) {
+	var b []byte
+	var err error
+	if sa, ok := a.(SnapshotAggregate); ok {
+		b, err = sa.Marshal(ser.Marshal)
+	} else {
+		b, err = marshalState(ser.Marshal, a)
+	}
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	return h[:], nil
+}
@@ -1,12 +1,16 @@
 package eventsourcing_test
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"testing"
 	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/memory"
+	"github.com/hallgren/eventsourcing/eventstore/suite"
 )
 
 var emptyBytes []byte = make([]byte, 16)
@@ -34,10 +38,7 @@ func CreatePerson(name string) (*Person, error) {
 	if name == "" {
 		return nil, errors.New("name can't be blank")
 	}
-	person := Person{}
-
-	person.TrackChange(&person, &Born{Name: name})
-	return &person, nil
+	return eventsourcing.New(func() *Person { return &Person{} }, nil, &Born{Name: name})
 }
 
 // CreatePersonWithID constructor for the Person that sets the aggregate ID from the outside
@@ -45,17 +46,7 @@ func CreatePersonWithID(id uuid.UUID, name string) (*Person, error) {
 	if name == "" {
 		return nil, errors.New("name can't be blank")
 	}
-
-	person := Person{}
-
-	err := person.SetID(id)
-	if err == eventsourcing.ErrAggregateAlreadyExists {
-		return nil, err
-	} else if err != nil {
-		return nil, err
-	}
-	person.TrackChange(&person, &Born{Name: name})
-	return &person, nil
+	return eventsourcing.New(func() *Person { return &Person{} }, &id, &Born{Name: name})
 }
 
 // GrowOlder command
@@ -145,6 +136,19 @@ func TestSetIDOnExistingPerson(t *testing.T) {
 	}
 }
 
+func TestNewFactoryWithID(t *testing.T) {
+	id := eventsourcing.NewUuid()
+	factory := func() *Person {
+		p := &Person{}
+		p.SetID(eventsourcing.NewUuid())
+		return p
+	}
+	_, err := eventsourcing.New(factory, &id, &Born{Name: "kalle"})
+	if !errors.Is(err, eventsourcing.ErrAggregateAlreadyExists) {
+		t.Fatalf("expected ErrAggregateAlreadyExists got %v", err)
+	}
+}
+
 func TestPersonAgedOneYear(t *testing.T) {
 	person, _ := CreatePerson("kalle")
 	person.GrowOlder()
@@ -183,6 +187,33 @@ func TestPersonAgedOneYear(t *testing.T) {
 	}
 }
 
+func TestPendingReasons(t *testing.T) {
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	person.GrowOlder()
+	reasons := person.PendingReasons()
+	expected := []string{"Born", "AgedOneYear", "AgedOneYear"}
+	if len(reasons) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, reasons)
+	}
+	for i := range expected {
+		if reasons[i] != expected[i] {
+			t.Fatalf("expected %v got %v", expected, reasons)
+		}
+	}
+
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if len(person.PendingReasons()) != 0 {
+		t.Fatalf("expected no pending reasons after save got %v", person.PendingReasons())
+	}
+}
+
 func TestPersonGrewTenYears(t *testing.T) {
 	person, _ := CreatePerson("kalle")
 	for i := 1; i <= 10; i++ {
@@ -235,6 +266,77 @@ func TestIDFuncGeneratingRandomIDs(t *testing.T) {
 	}
 }
 
+func TestUuidVersions(t *testing.T) {
+	ordered := func(f func() uuid.UUID) bool {
+		prev := f()
+		for i := 0; i < 100; i++ {
+			id := f()
+			if bytes.Compare(prev[:], id[:]) >= 0 {
+				return false
+			}
+			prev = id
+		}
+		return true
+	}
+	newUuid := func(version int) func() uuid.UUID {
+		f, err := eventsourcing.NewUuidWith(version, uuid.MillisecondPrecision)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	if !ordered(newUuid(7)) {
+		t.Fatal("expected v7 ids to be time ordered")
+	}
+	if !ordered(newUuid(6)) {
+		t.Fatal("expected v6 ids to be time ordered")
+	}
+	if ordered(newUuid(4)) {
+		t.Fatal("expected v4 ids to not be time ordered")
+	}
+	_, err := eventsourcing.NewUuidWith(5, uuid.MillisecondPrecision)
+	if !errors.Is(err, eventsourcing.ErrUnsupportedUuidVersion) {
+		t.Fatalf("expected ErrUnsupportedUuidVersion got %v", err)
+	}
+	if eventsourcing.NewUuidV4().Version() != uuid.V4 {
+		t.Fatal("expected a version 4 uuid")
+	}
+}
+
+func TestTrackChangeIdempotent(t *testing.T) {
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = person.TrackChangeIdempotent(person, &AgedOneYear{}, "birthday-2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = person.TrackChangeIdempotent(person, &AgedOneYear{}, "birthday-2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(person.Events()) != 2 {
+		t.Fatalf("expected 2 events got %d", len(person.Events()))
+	}
+	if person.Age != 1 {
+		t.Fatalf("expected age 1 got %d", person.Age)
+	}
+
+	// the keys are cleared when the aggregate is saved
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	err = person.TrackChangeIdempotent(person, &AgedOneYear{}, "birthday-2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(person.Events()) != 1 {
+		t.Fatalf("expected the key to be cleared after save, got %d events", len(person.Events()))
+	}
+}
+
 func TestMutateEvents(t *testing.T) {
 	var m = "mutated from the outside"
 	person, _ := CreatePerson("kalle")
@@ -245,3 +347,291 @@ func TestMutateEvents(t *testing.T) {
 		t.Fatal("events should not be mutated from the outside")
 	}
 }
+
+// Account is an aggregate that enforces a non-negative balance
+type Account struct {
+	eventsourcing.AggregateRoot
+	Balance int
+}
+
+// Withdrawn event
+type Withdrawn struct {
+	Amount int
+}
+
+var errNegativeBalance = errors.New("balance can't be negative")
+
+func (a *Account) Transition(event eventsourcing.Event) {
+	switch e := event.Data.(type) {
+	case *Withdrawn:
+		a.Balance -= e.Amount
+	}
+}
+
+func (a *Account) Validate() error {
+	if a.Balance < 0 {
+		return errNegativeBalance
+	}
+	return nil
+}
+
+func TestValidateRollsBackEvent(t *testing.T) {
+	account := Account{Balance: 10}
+	err := account.TrackChangeChecked(&account, &Withdrawn{Amount: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := account.ID()
+	err = account.TrackChangeChecked(&account, &Withdrawn{Amount: 10})
+	if !errors.Is(err, errNegativeBalance) {
+		t.Fatalf("expected errNegativeBalance got %v", err)
+	}
+	if len(account.Events()) != 1 {
+		t.Fatalf("expected the invalid event to be removed, got %d events", len(account.Events()))
+	}
+	if account.ID() != id {
+		t.Fatal("the aggregate id should not change")
+	}
+}
+
+func TestValidateOnSave(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	account := Account{Balance: 10}
+	account.TrackChange(&account, &Withdrawn{Amount: 5})
+	account.TrackChange(&account, &Withdrawn{Amount: 10})
+	err := repo.Save(&account)
+	if !errors.Is(err, errNegativeBalance) {
+		t.Fatalf("expected errNegativeBalance got %v", err)
+	}
+	if !account.UnsavedEvents() {
+		t.Fatal("expected the events to be unsaved")
+	}
+}
+
+func TestValidateFirstEvent(t *testing.T) {
+	account := Account{}
+	err := account.TrackChangeChecked(&account, &Withdrawn{Amount: 1})
+	if !errors.Is(err, errNegativeBalance) {
+		t.Fatalf("expected errNegativeBalance got %v", err)
+	}
+	if account.ID() != uuid.Nil {
+		t.Fatal("the generated id should be removed with the rejected event")
+	}
+}
+
+func TestApplyExternal(t *testing.T) {
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := CreatePerson("anka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.GrowOlder()
+
+	person.ApplyExternal(person, other.Events()[1])
+	if person.Age != 1 {
+		t.Fatalf("expected the external event to be applied, age %d", person.Age)
+	}
+	if person.Version() != 1 {
+		t.Fatalf("expected version 1 got %d", person.Version())
+	}
+	if len(person.Events()) != 1 {
+		t.Fatalf("the external event should not be tracked, got %d events", len(person.Events()))
+	}
+}
+
+func TestBuildFromHistoryStrict(t *testing.T) {
+	source, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.GrowOlder()
+	source.GrowOlder()
+	events := source.Events()
+
+	person := &Person{}
+	err = person.BuildFromHistoryStrict(person, events[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// continues from the current version
+	err = person.BuildFromHistoryStrict(person, events[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if person.Version() != 3 || person.Age != 2 {
+		t.Fatalf("expected version 3 and age 2 got version %d and age %d", person.Version(), person.Age)
+	}
+
+	gapped := &Person{}
+	err = gapped.BuildFromHistoryStrict(gapped, []eventsourcing.Event{events[0], events[2]})
+	if !errors.Is(err, eventsourcing.ErrVersionGap) {
+		t.Fatalf("expected ErrVersionGap got %v", err)
+	}
+	if gapped.Version() != 0 || gapped.Name != "" {
+		t.Fatalf("expected no events applied on gap got version %d", gapped.Version())
+	}
+
+	// events that do not continue from the current version are rejected
+	err = person.BuildFromHistoryStrict(person, events[:1])
+	if !errors.Is(err, eventsourcing.ErrVersionGap) {
+		t.Fatalf("expected ErrVersionGap got %v", err)
+	}
+
+	// the lenient build accepts the gap
+	lenient := &Person{}
+	lenient.BuildFromHistory(lenient, []eventsourcing.Event{events[0], events[2]})
+	if lenient.Version() != 3 {
+		t.Fatalf("expected version 3 got %d", lenient.Version())
+	}
+}
+
+func TestTrackChangeAt(t *testing.T) {
+	es := memory.Create()
+	repo := eventsourcing.NewRepository(es, nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	err = person.TrackChangeAt(person, &AgedOneYear{}, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	iterator, err := es.Get(context.Background(), person.ID(), "Person", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	event, err := iterator.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !event.Timestamp.Equal(ts) {
+		t.Fatalf("expected timestamp %v got %v", ts, event.Timestamp)
+	}
+
+	future := time.Now().Add(time.Hour)
+	err = person.TrackChangeAt(person, &AgedOneYear{}, future)
+	if !errors.Is(err, eventsourcing.ErrFutureTimestamp) {
+		t.Fatalf("expected ErrFutureTimestamp got %v", err)
+	}
+	if person.UnsavedEvents() {
+		t.Fatal("expected the rejected event not to be tracked")
+	}
+
+	eventsourcing.SetFutureTimestampTolerance(2 * time.Hour)
+	defer eventsourcing.SetFutureTimestampTolerance(0)
+	err = person.TrackChangeAt(person, &AgedOneYear{}, future)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFutureTimestampToleranceConcurrent(t *testing.T) {
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eventsourcing.SetFutureTimestampTolerance(0)
+	// the tolerance is set while other goroutines track changes, run with -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			eventsourcing.SetFutureTimestampTolerance(time.Duration(i) * time.Second)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		err = person.TrackChangeAt(person, &AgedOneYear{}, time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestStateHash(t *testing.T) {
+	ser := eventsourcing.NewJSONSerializer()
+	p1, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, err := p1.StateHash(p1, *ser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := p2.StateHash(p2, *ser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h1, h2) {
+		t.Fatal("expected aggregates with the same state to have the same hash")
+	}
+
+	p2.GrowOlder()
+	h3, err := p2.StateHash(p2, *ser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(h1, h3) {
+		t.Fatal("expected the hash to change with the state")
+	}
+}
+
+// FrequentFlierAccount has the same name as the aggregate in the suite package
+type FrequentFlierAccount struct {
+	eventsourcing.AggregateRoot
+}
+
+func (f *FrequentFlierAccount) Transition(e eventsourcing.Event) {}
+
+func TestFullName(t *testing.T) {
+	local := &FrequentFlierAccount{}
+	other := &suite.FrequentFlierAccount{}
+	if local.FullName(local) == other.FullName(other) {
+		t.Fatalf("expected different full names got %s", local.FullName(local))
+	}
+	if other.FullName(other) != "github.com/hallgren/eventsourcing/eventstore/suite.FrequentFlierAccount" {
+		t.Fatalf("unexpected full name %s", other.FullName(other))
+	}
+}
+
+func TestSubscribeSameNameOtherPackage(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	var received []eventsourcing.Event
+	s := repo.Subscribers().Aggregate(func(e eventsourcing.Event) {
+		received = append(received, e)
+	}, &FrequentFlierAccount{})
+	defer s.Close()
+
+	other := &suite.FrequentFlierAccount{}
+	other.TrackChange(other, &suite.FrequentFlierAccountCreated{})
+	err := repo.Save(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 0 {
+		t.Fatalf("expected no events from the other package got %d", len(received))
+	}
+
+	local := &FrequentFlierAccount{}
+	local.TrackChange(local, &suite.FrequentFlierAccountCreated{})
+	err = repo.Save(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected 1 event got %d", len(received))
+	}
+}
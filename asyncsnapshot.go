@@ -0,0 +1,158 @@
+package eventsourcing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gofrs/uuid"
+)
+
+// AsyncSnapshotStore decorates a snapshot store and writes snapshots in the background. Save only enqueues the
+// already serialized snapshot, if several snapshots of the same aggregate are waiting only the one with the highest
+// version is written. Snapshots are an optimization, failed writes are logged and not returned.
+type AsyncSnapshotStore struct {
+	store  SnapshotStore
+	logger Logger
+
+	lock    sync.Mutex
+	pending map[string]Snapshot
+	closed  bool
+	// taken and written count the batches of pending snapshots taken and written by the writer
+	taken, written uint64
+	// batchWritten is closed and replaced each time a batch is written
+	batchWritten chan struct{}
+	wake         chan struct{}
+	done         chan struct{}
+}
+
+// NewAsyncSnapshotStore starts the background writer of the snapshot store. Use it as the store of the
+// snapshot handler and call Close on shutdown to write the pending snapshots.
+func NewAsyncSnapshotStore(store SnapshotStore, logger Logger) *AsyncSnapshotStore {
+	if logger == nil {
+		logger = NoopLogger{}
+	}
+	s := &AsyncSnapshotStore{
+		store:        store,
+		logger:       logger,
+		pending:      make(map[string]Snapshot),
+		batchWritten: make(chan struct{}),
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Save enqueues the snapshot to be written, it replaces a pending snapshot of the aggregate with a lower version
+func (s *AsyncSnapshotStore) Save(snapshot Snapshot) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return s.store.Save(snapshot)
+	}
+	key := snapshotKey(snapshot.ID, snapshot.Type)
+	if p, ok := s.pending[key]; ok && p.Version > snapshot.Version {
+		return nil
+	}
+	s.pending[key] = snapshot
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// the writer is already woken up
+	}
+	return nil
+}
+
+// Get returns the pending snapshot of the aggregate if there is one, otherwise the snapshot from the store
+func (s *AsyncSnapshotStore) Get(ctx context.Context, id uuid.UUID, typ string) (Snapshot, error) {
+	s.lock.Lock()
+	p, ok := s.pending[snapshotKey(id, typ)]
+	s.lock.Unlock()
+	if ok {
+		return p, nil
+	}
+	return s.store.Get(ctx, id, typ)
+}
+
+// Delete drops the pending snapshot of the aggregate and removes it from the store, ErrSnapshotDeleteNotSupported
+// is returned if the wrapped store does not implement SnapshotDeleter
+func (s *AsyncSnapshotStore) Delete(ctx context.Context, id uuid.UUID, typ string) error {
+	store, ok := s.store.(SnapshotDeleter)
+	if !ok {
+		return ErrSnapshotDeleteNotSupported
+	}
+	s.lock.Lock()
+	delete(s.pending, snapshotKey(id, typ))
+	s.lock.Unlock()
+	return store.Delete(ctx, id, typ)
+}
+
+// Flush blocks until the snapshots pending when it's called are written or the context is done. Snapshots saved
+// after the call are not waited for.
+func (s *AsyncSnapshotStore) Flush(ctx context.Context) error {
+	s.lock.Lock()
+	// the batch being written, and the next batch if snapshots are pending as it takes all of them
+	target := s.taken
+	if len(s.pending) > 0 {
+		target++
+	}
+	for s.written < target {
+		batchWritten := s.batchWritten
+		s.lock.Unlock()
+		select {
+		case <-batchWritten:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.lock.Lock()
+	}
+	s.lock.Unlock()
+	return nil
+}
+
+// Close writes the pending snapshots and stops the background writer. Snapshots saved after Close are written
+// directly to the store.
+func (s *AsyncSnapshotStore) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.wake)
+	s.lock.Unlock()
+	<-s.done
+}
+
+func (s *AsyncSnapshotStore) run() {
+	defer close(s.done)
+	for range s.wake {
+		for {
+			s.lock.Lock()
+			batch := s.pending
+			if len(batch) == 0 {
+				s.lock.Unlock()
+				break
+			}
+			s.pending = make(map[string]Snapshot)
+			s.taken++
+			s.lock.Unlock()
+
+			for _, snapshot := range batch {
+				if err := s.store.Save(snapshot); err != nil {
+					s.logger.Error("could not save snapshot", "aggregate_id", snapshot.ID, "type", snapshot.Type, "version", snapshot.Version, "error", err)
+				}
+			}
+
+			s.lock.Lock()
+			s.written++
+			close(s.batchWritten)
+			s.batchWritten = make(chan struct{})
+			s.lock.Unlock()
+		}
+	}
+}
+
+func snapshotKey(id uuid.UUID, typ string) string {
+	return typ + "_" + id.String()
+}
@@ -0,0 +1,173 @@
+package eventsourcing_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/snapshotstore/memory"
+)
+
+// blockingSnapshotStore blocks Save until release is closed and records the saved versions
+type blockingSnapshotStore struct {
+	*memory.Handler
+	lock     sync.Mutex
+	release  chan struct{}
+	started  chan struct{}
+	versions []eventsourcing.Version
+	err      error
+}
+
+func (b *blockingSnapshotStore) Save(s eventsourcing.Snapshot) error {
+	select {
+	case b.started <- struct{}{}:
+	default:
+	}
+	<-b.release
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.versions = append(b.versions, s.Version)
+	if b.err != nil {
+		return b.err
+	}
+	return b.Handler.Save(s)
+}
+
+func newBlockingSnapshotStore() *blockingSnapshotStore {
+	return &blockingSnapshotStore{Handler: memory.New(), release: make(chan struct{}), started: make(chan struct{}, 1)}
+}
+
+func TestAsyncSnapshotStoreCoalesce(t *testing.T) {
+	store := newBlockingSnapshotStore()
+	async := eventsourcing.NewAsyncSnapshotStore(store, nil)
+	defer async.Close()
+	id := eventsourcing.NewUuid()
+
+	async.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: 1})
+	// wait for the writer to block on the first snapshot
+	<-store.started
+	async.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: 2})
+	async.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: 4})
+	async.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: 3})
+
+	// the pending snapshot is returned before it's written
+	snap, err := async.Get(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 4 {
+		t.Fatalf("expected pending version 4 got %d", snap.Version)
+	}
+
+	close(store.release)
+	err = async.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.versions) != 2 || store.versions[0] != 1 || store.versions[1] != 4 {
+		t.Fatalf("expected versions [1 4] to be written got %v", store.versions)
+	}
+	snap, err = store.Handler.Get(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 4 {
+		t.Fatalf("expected stored version 4 got %d", snap.Version)
+	}
+}
+
+func TestAsyncSnapshotStoreFlush(t *testing.T) {
+	store := newBlockingSnapshotStore()
+	async := eventsourcing.NewAsyncSnapshotStore(store, nil)
+	defer async.Close()
+
+	// nothing pending
+	err := async.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	async.Save(eventsourcing.Snapshot{ID: eventsourcing.NewUuid(), Type: "Person", Version: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = async.Flush(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded while the write is blocked got %v", err)
+	}
+	close(store.release)
+	err = async.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.versions) != 1 {
+		t.Fatalf("expected 1 written snapshot got %d", len(store.versions))
+	}
+}
+
+func TestAsyncSnapshotStoreFlushContinuousSaves(t *testing.T) {
+	store := newBlockingSnapshotStore()
+	async := eventsourcing.NewAsyncSnapshotStore(store, nil)
+	defer async.Close()
+
+	async.Save(eventsourcing.Snapshot{ID: eventsourcing.NewUuid(), Type: "Person", Version: 1})
+	<-store.started
+	async.Save(eventsourcing.Snapshot{ID: eventsourcing.NewUuid(), Type: "Person", Version: 1})
+
+	// save a new snapshot before each write is let through so there is always a snapshot pending
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			async.Save(eventsourcing.Snapshot{ID: eventsourcing.NewUuid(), Type: "Person", Version: 1})
+			select {
+			case store.release <- struct{}{}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := async.Flush(ctx)
+	close(stop)
+	<-stopped
+	close(store.release)
+	if err != nil {
+		t.Fatalf("expected flush to return when the snapshots pending at the call are written got %v", err)
+	}
+}
+
+// errorLogger records the error messages
+type errorLogger struct {
+	eventsourcing.NoopLogger
+	lock   sync.Mutex
+	errors []string
+}
+
+func (e *errorLogger) Error(msg string, kv ...interface{}) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.errors = append(e.errors, msg)
+}
+
+func TestAsyncSnapshotStoreLogsFailures(t *testing.T) {
+	store := newBlockingSnapshotStore()
+	store.err = errors.New("disk full")
+	close(store.release)
+	logger := &errorLogger{}
+	async := eventsourcing.NewAsyncSnapshotStore(store, logger)
+
+	err := async.Save(eventsourcing.Snapshot{ID: eventsourcing.NewUuid(), Type: "Person", Version: 1})
+	if err != nil {
+		t.Fatalf("expected the failure to not be returned got %v", err)
+	}
+	async.Close()
+	if len(logger.errors) != 1 {
+		t.Fatalf("expected 1 logged error got %d", len(logger.errors))
+	}
+}
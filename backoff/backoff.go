@@ -0,0 +1,83 @@
+// Package backoff holds the delay strategies used between retries, e.g. by the retry event store and the poller.
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff returns the delay before the next attempt, attempt is the number of failed attempts starting from 1
+type Backoff interface {
+	Next(attempt int) time.Duration
+}
+
+// ConstantBackoff waits the same delay between all attempts
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+// Next returns the constant delay
+func (c ConstantBackoff) Next(attempt int) time.Duration {
+	return c.Delay
+}
+
+// ExponentialBackoff multiplies the delay with Multiplier after each attempt starting from Initial. The delay is
+// capped at Max if it's set, a Multiplier below 1 is treated as 2.
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// Next returns Initial times Multiplier to the power of attempt - 1
+func (e ExponentialBackoff) Next(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := e.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(e.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if e.Max > 0 && delay > float64(e.Max) {
+		return e.Max
+	}
+	if delay > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
+
+// JitteredBackoff spreads the delays of another backoff randomly, it prevents clients that failed at the same time
+// to retry at the same time
+type JitteredBackoff struct {
+	backoff Backoff
+	factor  float64
+	lock    sync.Mutex
+	rand    *rand.Rand
+}
+
+// NewJitteredBackoff returns a backoff that adds a random jitter of up to factor times the delay of the backoff
+// in either direction, e.g. 0.2 gives delays from 80% to 120%. The seed makes the delays repeatable in tests.
+func NewJitteredBackoff(backoff Backoff, factor float64, seed int64) *JitteredBackoff {
+	return &JitteredBackoff{
+		backoff: backoff,
+		factor:  factor,
+		rand:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Next returns the delay of the wrapped backoff with the jitter applied, never below zero
+func (j *JitteredBackoff) Next(attempt int) time.Duration {
+	delay := float64(j.backoff.Next(attempt))
+	j.lock.Lock()
+	r := j.rand.Float64()
+	j.lock.Unlock()
+	delay += (r*2 - 1) * j.factor * delay
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,54 @@
+package backoff_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hallgren/eventsourcing/backoff"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := backoff.ConstantBackoff{Delay: time.Second}
+	for attempt := 1; attempt <= 3; attempt++ {
+		if d := b.Next(attempt); d != time.Second {
+			t.Fatalf("expected 1s on attempt %d got %v", attempt, d)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := backoff.ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, e := range expected {
+		if d := b.Next(i + 1); d != e {
+			t.Fatalf("expected %v on attempt %d got %v", e, i+1, d)
+		}
+	}
+	// no max and a huge attempt does not overflow
+	b = backoff.ExponentialBackoff{Initial: time.Second}
+	if d := b.Next(1000); d <= 0 {
+		t.Fatalf("expected a positive delay got %v", d)
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	base := backoff.ConstantBackoff{Delay: time.Second}
+	b1 := backoff.NewJitteredBackoff(base, 0.2, 42)
+	b2 := backoff.NewJitteredBackoff(base, 0.2, 42)
+	varied := false
+	for attempt := 1; attempt <= 20; attempt++ {
+		d := b1.Next(attempt)
+		if d2 := b2.Next(attempt); d != d2 {
+			t.Fatalf("expected the same delay with the same seed got %v and %v", d, d2)
+		}
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("expected a delay within 20%% of 1s got %v", d)
+		}
+		if d != time.Second {
+			varied = true
+		}
+	}
+	if !varied {
+		t.Fatal("expected the delays to be jittered")
+	}
+}
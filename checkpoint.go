@@ -0,0 +1,40 @@
+package eventsourcing
+
+import (
+	"sync"
+
+	"github.com/gofrs/uuid"
+)
+
+// CheckpointStore persists the position of consumers of the global event feed, e.g. pollers and projections. The
+// checkpoint is the event id of the last handled event, uuid.Nil if the consumer has not handled any event.
+type CheckpointStore interface {
+	Load(name string) (uuid.UUID, error)
+	Save(name string, checkpoint uuid.UUID) error
+}
+
+// MemoryCheckpointStore keeps the checkpoints in memory, it's meant for tests and single process setups
+type MemoryCheckpointStore struct {
+	lock        sync.Mutex
+	checkpoints map[string]uuid.UUID
+}
+
+// NewMemoryCheckpointStore returns an empty memory checkpoint store
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]uuid.UUID)}
+}
+
+// Load returns the checkpoint of the consumer, uuid.Nil if it has none
+func (m *MemoryCheckpointStore) Load(name string) (uuid.UUID, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.checkpoints[name], nil
+}
+
+// Save sets the checkpoint of the consumer
+func (m *MemoryCheckpointStore) Save(name string, checkpoint uuid.UUID) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.checkpoints[name] = checkpoint
+	return nil
+}
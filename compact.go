@@ -0,0 +1,65 @@
+package eventsourcing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrCompactionDisabled is returned from Compact when compaction is not enabled on the repository
+var ErrCompactionDisabled = errors.New("compaction is not enabled")
+
+// ErrCompactionNotSupported is returned from Compact when the event store does not implement EventTruncater
+var ErrCompactionNotSupported = errors.New("event store does not support compaction")
+
+// ErrCompactBeyondSnapshot is returned from Compact when the events to delete are not covered by the snapshot
+var ErrCompactBeyondSnapshot = errors.New("compaction beyond the snapshot version")
+
+// EventTruncater is implemented by event stores that can delete the events of an aggregate up to and including a
+// version
+type EventTruncater interface {
+	Truncate(ctx context.Context, id uuid.UUID, aggregateType string, toVersion Version) error
+}
+
+// SetCompaction enables Compact, it's off by default as Compact deletes events that can't be restored
+func (r *Repository) SetCompaction(enabled bool) {
+	r.compaction = enabled
+}
+
+// Compact builds the aggregate, saves a snapshot of it and deletes its events with a version up to and including
+// keepAfter. The events after keepAfter are kept for audit and Get builds the aggregate from the snapshot and the
+// kept events. The last event is always kept, keepAfter has to be lower than the aggregate version or
+// ErrCompactBeyondSnapshot is returned. It requires a snapshot store, an event store that implements
+// EventTruncater and that compaction is enabled with SetCompaction.
+func (r *Repository) Compact(ctx context.Context, id uuid.UUID, aggregate Aggregate, keepAfter Version) error {
+	if !r.compaction {
+		return ErrCompactionDisabled
+	}
+	if r.snapshot == nil {
+		return ErrNoSnapshotStore
+	}
+	truncater, ok := r.eventStore.(EventTruncater)
+	if !ok {
+		return ErrCompactionNotSupported
+	}
+	err := r.GetWithContext(ctx, id, aggregate)
+	if err != nil {
+		return err
+	}
+	root := aggregate.Root()
+	if keepAfter >= root.Version() {
+		return ErrCompactBeyondSnapshot
+	}
+	// the snapshot has to be saved before the events it replaces are deleted
+	err = r.SaveSnapshot(aggregate)
+	if err != nil {
+		return err
+	}
+	err = truncater.Truncate(ctx, id, aggregateName(aggregate), keepAfter)
+	if err != nil {
+		return err
+	}
+	r.logger.Info("compacted aggregate", "aggregate_type", aggregateName(aggregate), "aggregate_id", id, "snapshot_version", root.Version(), "kept_after", keepAfter)
+	return nil
+}
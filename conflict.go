@@ -0,0 +1,87 @@
+package eventsourcing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofrs/uuid"
+)
+
+// conflictAttempts is the max number of times a save is retried with events from the conflict resolver
+const conflictAttempts = 3
+
+// ConflictResolver is called by Repository.Save when the event store returns ErrConcurrency. It gets the events
+// that failed to save and the events stored by the other save since, and returns the events to save instead, with
+// versions that continue after the stored events. Returning an error, e.g. ErrConcurrency, fails the save.
+type ConflictResolver func(incoming, stored []Event) ([]Event, error)
+
+// FailOnConflict is the default conflict resolver, it fails the save with ErrConcurrency
+var FailOnConflict ConflictResolver = func(incoming, stored []Event) ([]Event, error) {
+	return nil, ErrConcurrency
+}
+
+// RebaseEvents returns a copy of the events with versions that continue after the version, it's a helper for
+// conflict resolvers
+func RebaseEvents(events []Event, after Version) []Event {
+	rebased := make([]Event, len(events))
+	for i, event := range events {
+		event.Version = after + Version(i+1)
+		rebased[i] = event
+	}
+	return rebased
+}
+
+// SetConflictResolver sets the resolver used when a save fails with ErrConcurrency. The saved aggregate keeps its
+// state, it does not include the stored events it was rebased on, fetch the aggregate again if they matter. nil
+// sets FailOnConflict.
+func (r *Repository) SetConflictResolver(resolver ConflictResolver) {
+	r.conflictResolver = resolver
+}
+
+// resolveConflict saves the events from the conflict resolver and returns them
+func (r *Repository) resolveConflict(ctx context.Context, events []Event) ([]Event, error) {
+	if r.conflictResolver == nil {
+		return nil, ErrConcurrency
+	}
+	for attempt := 0; attempt < conflictAttempts; attempt++ {
+		stored, err := r.storedAfter(ctx, events[0].AggregateID, events[0].AggregateType, events[0].Version-1)
+		if err != nil {
+			return nil, err
+		}
+		resolved, err := r.conflictResolver(events, stored)
+		if err != nil {
+			return nil, err
+		}
+		// the store gets a copy to keep the resolved events untouched
+		err = r.save(ctx, append([]Event(nil), resolved...))
+		if err == nil {
+			r.logger.Info("resolved save conflict", "aggregate_type", events[0].AggregateType, "aggregate_id", events[0].AggregateID, "attempt", attempt+1)
+			return resolved, nil
+		} else if !errors.Is(err, ErrConcurrency) {
+			return nil, err
+		}
+		events = resolved
+	}
+	return nil, ErrConcurrency
+}
+
+// storedAfter returns the stored events of the aggregate after the version
+func (r *Repository) storedAfter(ctx context.Context, id uuid.UUID, aggregateType string, version Version) ([]Event, error) {
+	iterator, err := r.eventStore.Get(ctx, id, aggregateType, version)
+	if errors.Is(err, ErrNoEvents) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+	var events []Event
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, ErrNoMoreEvents) {
+			return events, nil
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+}
@@ -0,0 +1,101 @@
+package eventsourcing
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrKeyNotFound returns from a KeyProvider when there is no key for the aggregate. Events encrypted with a
+// lost key can't be read.
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// ErrDecryptionFailed returns if encrypted event data could not be decrypted with the key of the aggregate
+var ErrDecryptionFailed = errors.New("could not decrypt event data")
+
+// KeyProvider returns the AES key (16, 24 or 32 bytes) used to encrypt the events of an aggregate. Keys can be
+// per aggregate type or per aggregate id, the latter makes it possible to erase the events of a single aggregate
+// by deleting its key (crypto-shredding).
+type KeyProvider interface {
+	Key(aggregateType string, aggregateID uuid.UUID) ([]byte, error)
+}
+
+// EncryptingSerializer decorates a Serializer with AES-GCM encryption of the marshaled event data and optionally
+// the metadata. Event stores that support it encrypts after Marshal and decrypts before Unmarshal.
+type EncryptingSerializer struct {
+	*Serializer
+	keys            KeyProvider
+	encryptMetadata bool
+}
+
+// NewEncryptingSerializer returns a serializer that encrypts the event data with keys from the key provider
+func NewEncryptingSerializer(serializer *Serializer, keys KeyProvider, encryptMetadata bool) *EncryptingSerializer {
+	return &EncryptingSerializer{
+		Serializer:      serializer,
+		keys:            keys,
+		encryptMetadata: encryptMetadata,
+	}
+}
+
+// EncryptMetadata tells if the event metadata should be encrypted
+func (s *EncryptingSerializer) EncryptMetadata() bool {
+	return s.encryptMetadata
+}
+
+// Encrypt encrypts the marshaled data with the key of the aggregate. The nonce is prepended to the cipher text
+// and the result is base64 encoded to be storable in text columns.
+func (s *EncryptingSerializer) Encrypt(aggregateType string, aggregateID uuid.UUID, data []byte) ([]byte, error) {
+	gcm, err := s.gcm(aggregateType, aggregateID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, data, aggregateID.Bytes())
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(encoded, sealed)
+	return encoded, nil
+}
+
+// Decrypt decrypts data encrypted by Encrypt with the key of the aggregate
+func (s *EncryptingSerializer) Decrypt(aggregateType string, aggregateID uuid.UUID, data []byte) ([]byte, error) {
+	gcm, err := s.gcm(aggregateType, aggregateID)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(sealed, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	sealed = sealed[:n]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+	nonce, text := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, text, aggregateID.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	return plain, nil
+}
+
+func (s *EncryptingSerializer) gcm(aggregateType string, aggregateID uuid.UUID) (cipher.AEAD, error) {
+	key, err := s.keys.Key(aggregateType, aggregateID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,82 @@
+package eventsourcing_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/suite"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	id := eventsourcing.NewUuid()
+	k := suite.Keys{id: bytes.Repeat([]byte{1}, 32)}
+	s := eventsourcing.NewEncryptingSerializer(eventsourcing.NewSerializer(json.Marshal, json.Unmarshal), k, true)
+
+	plain := []byte(`{"Name":"kalle"}`)
+	encrypted, err := s.Encrypt("Person", id, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("kalle")) {
+		t.Fatal("encrypted data should not contain the plain text")
+	}
+	decrypted, err := s.Decrypt("Person", id, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, decrypted) {
+		t.Fatalf("expected %s got %s", plain, decrypted)
+	}
+
+	// data encrypted for one aggregate can't be decrypted as another aggregate
+	other := eventsourcing.NewUuid()
+	k[other] = k[id]
+	_, err = s.Decrypt("Person", other, encrypted)
+	if !errors.Is(err, eventsourcing.ErrDecryptionFailed) {
+		t.Fatalf("expected ErrDecryptionFailed got %v", err)
+	}
+}
+
+func TestDecryptLostKey(t *testing.T) {
+	id := eventsourcing.NewUuid()
+	k := suite.Keys{id: bytes.Repeat([]byte{1}, 32)}
+	s := eventsourcing.NewEncryptingSerializer(eventsourcing.NewSerializer(json.Marshal, json.Unmarshal), k, false)
+
+	encrypted, err := s.Encrypt("Person", id, []byte(`{"Name":"kalle"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// losing the key makes the data unreadable
+	delete(k, id)
+	_, err = s.Decrypt("Person", id, encrypted)
+	if !errors.Is(err, eventsourcing.ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound got %v", err)
+	}
+}
+
+func TestDecryptShreddedKey(t *testing.T) {
+	id := eventsourcing.NewUuid()
+	k := eventsourcing.NewMemoryKeyStore()
+	s := eventsourcing.NewEncryptingSerializer(eventsourcing.NewSerializer(json.Marshal, json.Unmarshal), k, false)
+
+	encrypted, err := s.Encrypt("Person", id, []byte(`{"Name":"kalle"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = k.DeleteKey(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Decrypt("Person", id, encrypted)
+	if !errors.Is(err, eventsourcing.ErrKeyShredded) {
+		t.Fatalf("expected ErrKeyShredded got %v", err)
+	}
+	// a new key is not created for a shredded aggregate
+	_, err = s.Encrypt("Person", id, []byte(`{"Name":"kalle"}`))
+	if !errors.Is(err, eventsourcing.ErrKeyShredded) {
+		t.Fatalf("expected ErrKeyShredded got %v", err)
+	}
+}
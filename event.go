@@ -3,6 +3,7 @@ package eventsourcing
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"reflect"
 	"time"
 
@@ -15,6 +16,11 @@ var ErrNoEvents = errors.New("no events")
 // ErrNoMoreEvents when iterator has no more events to deliver
 var ErrNoMoreEvents = errors.New("no more events")
 
+// ErrAllEventsUnregistered when an iterator reached the end of an aggregate stream where all events were skipped as
+// not registered in the serializer. The stream exists, the aggregate types or events have to be registered to read
+// it. It wraps ErrNoMoreEvents to end loops over the iterator as before.
+var ErrAllEventsUnregistered = fmt.Errorf("%w, all events are unregistered", ErrNoMoreEvents)
+
 // Event holding metadata and the application specific event in the Data property
 type Event struct {
 	EventID       uuid.UUID
@@ -0,0 +1,108 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+)
+
+// ErrAudit wraps the error from the audit sink when the audit record of a save could not be written
+var ErrAudit = errors.New("audit")
+
+// AuditRecord describes a save made through the AuditEventStore
+type AuditRecord struct {
+	Actor         string
+	Timestamp     time.Time
+	AggregateID   uuid.UUID
+	AggregateType string
+	EventCount    int
+}
+
+// AuditSink stores the audit records, e.g. in an append only table
+type AuditSink interface {
+	Record(ctx context.Context, record AuditRecord) error
+}
+
+type actorKey struct{}
+
+// WithActor returns a context that holds the actor, e.g. the authenticated user, recorded on the saves made with it
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set on the context with WithActor, empty if there is none
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// AuditEventStore wraps an event store and writes an audit record of each save to the sink before the events are
+// saved. The actor is taken from the context passed to Repository.SaveWithContext. A save is failed if its audit
+// record can't be written, unless the store is set to fail open.
+type AuditEventStore struct {
+	store    eventsourcing.EventStore
+	sink     AuditSink
+	failOpen bool
+	logger   eventsourcing.Logger
+}
+
+// NewAuditEventStore returns an AuditEventStore that records the saves to store in sink
+func NewAuditEventStore(store eventsourcing.EventStore, sink AuditSink) *AuditEventStore {
+	return &AuditEventStore{
+		store:  store,
+		sink:   sink,
+		logger: eventsourcing.NoopLogger{},
+	}
+}
+
+// SetFailOpen makes a failed audit write be logged and the save continue, by default the save fails with ErrAudit
+func (a *AuditEventStore) SetFailOpen(failOpen bool) {
+	a.failOpen = failOpen
+}
+
+// SetLogger sets the logger used to log failed audit writes when the store fails open
+func (a *AuditEventStore) SetLogger(logger eventsourcing.Logger) {
+	a.logger = logger
+}
+
+// Save records and saves the events without an actor
+func (a *AuditEventStore) Save(events []eventsourcing.Event) error {
+	return a.SaveContext(context.Background(), events)
+}
+
+// SaveContext records the save with the actor of the context and saves the events. The record is written before
+// the events, a save that fails in the event store is recorded as well.
+func (a *AuditEventStore) SaveContext(ctx context.Context, events []eventsourcing.Event) error {
+	if len(events) == 0 {
+		return saveContext(ctx, a.store, events)
+	}
+	err := a.sink.Record(ctx, AuditRecord{
+		Actor:         ActorFromContext(ctx),
+		Timestamp:     time.Now().UTC(),
+		AggregateID:   events[0].AggregateID,
+		AggregateType: events[0].AggregateType,
+		EventCount:    len(events),
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrAudit, err)
+		if !a.failOpen {
+			return err
+		}
+		a.logger.Error("audit record", "aggregate_type", events[0].AggregateType, "aggregate_id", events[0].AggregateID, "error", err)
+	}
+	return saveContext(ctx, a.store, events)
+}
+
+// Get fetches the events from the wrapped store
+func (a *AuditEventStore) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	return a.store.Get(ctx, id, aggregateType, afterVersion)
+}
+
+// LatestVersion fetches the latest version from the wrapped store
+func (a *AuditEventStore) LatestVersion(ctx context.Context, id uuid.UUID, aggregateType string) (eventsourcing.Version, error) {
+	return eventsourcing.LatestVersion(ctx, a.store, id, aggregateType)
+}
@@ -0,0 +1,71 @@
+package eventstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore"
+	"github.com/hallgren/eventsourcing/eventstore/memory"
+)
+
+// recordingSink keeps the audit records, it fails with err if it's set
+type recordingSink struct {
+	records []eventstore.AuditRecord
+	err     error
+}
+
+func (r *recordingSink) Record(ctx context.Context, record eventstore.AuditRecord) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.records = append(r.records, record)
+	return nil
+}
+
+func TestAuditEventStore(t *testing.T) {
+	sink := &recordingSink{}
+	store := eventstore.NewAuditEventStore(memory.Create(), sink)
+	id := eventsourcing.NewUuid()
+	saved := append(events(id), eventsourcing.Event{EventID: eventsourcing.NewUuid(), AggregateID: id, Version: 2, AggregateType: "Person", Data: &Born{}})
+
+	ctx := eventstore.WithActor(context.Background(), "alice")
+	err := store.SaveContext(ctx, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("expected 1 audit record got %d", len(sink.records))
+	}
+	record := sink.records[0]
+	if record.Actor != "alice" || record.AggregateID != id || record.AggregateType != "Person" || record.EventCount != 2 {
+		t.Fatalf("unexpected audit record %+v", record)
+	}
+	if record.Timestamp.IsZero() {
+		t.Fatal("expected the audit record to have a timestamp")
+	}
+}
+
+func TestAuditEventStoreFailClosed(t *testing.T) {
+	sink := &recordingSink{err: errTransient}
+	store := eventstore.NewAuditEventStore(memory.Create(), sink)
+	id := eventsourcing.NewUuid()
+	err := store.Save(events(id))
+	if !errors.Is(err, eventstore.ErrAudit) {
+		t.Fatalf("expected ErrAudit got %v", err)
+	}
+	version, err := store.LatestVersion(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("expected the events not to be saved got version %d", version)
+	}
+
+	store.SetFailOpen(true)
+	err = store.Save(events(id))
+	if err != nil {
+		t.Fatalf("expected the save to continue when failing open got %v", err)
+	}
+}
@@ -1,26 +1,35 @@
 package eventstore
 
 import (
-	"errors"
-
 	"github.com/gofrs/uuid"
 	"github.com/hallgren/eventsourcing"
 )
 
-// ErrEventMultipleAggregates when events holds different id
-var ErrEventMultipleAggregates = errors.New("events holds events for more than one aggregate")
+// The validation errors are defined in the eventsourcing package for callers of Repository.Save to check them
+// without importing the eventstore package.
+var (
+	// ErrEventMultipleAggregates when events holds different id
+	ErrEventMultipleAggregates = eventsourcing.ErrEventMultipleAggregates
+
+	// ErrEventMultipleAggregateTypes when events holds different aggregate types
+	ErrEventMultipleAggregateTypes = eventsourcing.ErrEventMultipleAggregateTypes
 
-// ErrEventMultipleAggregateTypes when events holds different aggregate types
-var ErrEventMultipleAggregateTypes = errors.New("events holds events for more than one aggregate type")
+	// ErrConcurrency when the currently saved version of the aggregate differs from the new ones
+	ErrConcurrency = eventsourcing.ErrConcurrency
 
-// ErrConcurrency when the currently saved version of the aggregate differs from the new ones
-var ErrConcurrency = errors.New("concurrency error")
+	// ErrInvalidVersion when the first event has version 0
+	ErrInvalidVersion = eventsourcing.ErrInvalidVersion
 
-// ErrReasonMissing when the reason is not present in the events
-var ErrReasonMissing = errors.New("event holds no reason")
+	// ErrReasonMissing when the reason is not present in the events
+	ErrReasonMissing = eventsourcing.ErrReasonMissing
+)
 
 // ValidateEvents make sure the incoming events are valid
 func ValidateEvents(aggregateID uuid.UUID, currentVersion eventsourcing.Version, events []eventsourcing.Event) error {
+	// a version 0 is a bug in the caller, not a conflict with another save
+	if events[0].Version == 0 {
+		return ErrInvalidVersion
+	}
 	aggregateType := events[0].AggregateType
 
 	for _, event := range events {
@@ -47,6 +56,9 @@ func ValidateEvents(aggregateID uuid.UUID, currentVersion eventsourcing.Version,
 
 // ValidateEventsNoVersionCheck make sure the incoming events are valid
 func ValidateEventsNoVersionCheck(aggregateID uuid.UUID, events []eventsourcing.Event) error {
+	if events[0].Version == 0 {
+		return ErrInvalidVersion
+	}
 	aggregateType := events[0].AggregateType
 	currentVersion := events[0].Version - 1
 
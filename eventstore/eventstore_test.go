@@ -0,0 +1,32 @@
+package eventstore_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore"
+	"github.com/hallgren/eventsourcing/eventstore/memory"
+)
+
+func TestValidateEventsInvalidVersion(t *testing.T) {
+	id := eventsourcing.NewUuid()
+	batch := events(id)
+	batch[0].Version = 0
+
+	err := eventstore.ValidateEvents(id, 0, batch)
+	if !errors.Is(err, eventstore.ErrInvalidVersion) {
+		t.Fatalf("expected ErrInvalidVersion got %v", err)
+	}
+	err = eventstore.ValidateEventsNoVersionCheck(id, batch)
+	if !errors.Is(err, eventstore.ErrInvalidVersion) {
+		t.Fatalf("expected ErrInvalidVersion from the no version check got %v", err)
+	}
+	err = memory.Create().Save(batch)
+	if !errors.Is(err, eventsourcing.ErrInvalidVersion) {
+		t.Fatalf("expected ErrInvalidVersion from save got %v", err)
+	}
+	if errors.Is(err, eventsourcing.ErrConcurrency) {
+		t.Fatal("expected the invalid version to not be a concurrency error")
+	}
+}
@@ -0,0 +1,393 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore"
+)
+
+// indexSuffix is appended to the log path to get the path of the index file
+const indexSuffix = ".idx"
+
+// maxRecordSize is the max size of a record in the log, larger lengths are treated as a torn write
+const maxRecordSize = 64 << 20
+
+// File is an event store that appends the events to a log file. The index file maps the aggregates to the
+// offsets of their events in the log and is rebuilt from the log if it's missing or lags behind. The log and index
+// are only safe to use from one process at a time.
+type File struct {
+	lock       sync.RWMutex
+	log        *os.File
+	index      *os.File
+	serializer eventsourcing.Serializer
+	// aggregates holds the index entries per aggregate in version order
+	aggregates map[string][]entry
+	// global holds the index entries in append order, the global order of the events
+	global []entry
+	// size is the end of the last complete record in the log
+	size int64
+}
+
+// entry is the position of an event in the log
+type entry struct {
+	eventID       uuid.UUID
+	aggregateID   uuid.UUID
+	aggregateType string
+	version       eventsourcing.Version
+	offset        int64
+	length        uint32
+}
+
+// record is an event as it's stored in the log
+type record struct {
+	EventID     uuid.UUID             `json:"event_id"`
+	AggregateID uuid.UUID             `json:"aggregate_id"`
+	Version     eventsourcing.Version `json:"version"`
+	Reason      string                `json:"reason"`
+	Type        string                `json:"type"`
+	Timestamp   time.Time             `json:"timestamp"`
+	Data        []byte                `json:"data"`
+	Metadata    []byte                `json:"metadata,omitempty"`
+	Format      string                `json:"format,omitempty"`
+}
+
+// Open opens the log at path, it's created if it does not exist. The index is read from path.idx and the log
+// records after the last indexed one are indexed, a record that was partly written when the process stopped is
+// truncated from the log.
+func Open(path string, serializer eventsourcing.Serializer) (*File, error) {
+	log, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(path+indexSuffix, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	f := &File{
+		log:        log,
+		index:      index,
+		serializer: serializer,
+		aggregates: make(map[string][]entry),
+	}
+	if err = f.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// recover loads the index file and indexes the log records it's missing
+func (f *File) recover() error {
+	logSize, err := f.log.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	b, err := io.ReadAll(io.NewSectionReader(f.index, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+	var indexSize int64
+	for len(b) > 0 {
+		e, n, ok := decodeEntry(b)
+		// stop at a partly written entry or at an entry that does not follow the previous record in the log
+		if !ok || e.offset != f.size || e.offset+4+int64(e.length) > logSize {
+			break
+		}
+		f.add(e)
+		b = b[n:]
+		indexSize += int64(n)
+	}
+	if err = f.index.Truncate(indexSize); err != nil {
+		return err
+	}
+	if _, err = f.index.Seek(indexSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	// index the log records after the last indexed record
+	var missing []entry
+	reader := bufio.NewReader(io.NewSectionReader(f.log, f.size, logSize-f.size))
+	for {
+		e, err := readRecord(reader, f.size)
+		if err != nil {
+			// a partly written record at the end of the log
+			break
+		}
+		missing = append(missing, e)
+		f.add(e)
+	}
+	if err = f.log.Truncate(f.size); err != nil {
+		return err
+	}
+	if _, err = f.log.Seek(f.size, io.SeekStart); err != nil {
+		return err
+	}
+	return f.writeIndex(missing)
+}
+
+// readRecord reads the record at offset and returns its index entry
+func readRecord(r io.Reader, offset int64) (entry, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return entry{}, err
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	if length > maxRecordSize {
+		return entry{}, fmt.Errorf("record at offset %d is too large", offset)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return entry{}, err
+	}
+	var rec record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return entry{}, err
+	}
+	return entry{eventID: rec.EventID, aggregateID: rec.AggregateID, aggregateType: rec.Type, version: rec.Version, offset: offset, length: length}, nil
+}
+
+// add adds the entry to the in memory index
+func (f *File) add(e entry) {
+	key := aggregateKey(e.aggregateType, e.aggregateID)
+	f.aggregates[key] = append(f.aggregates[key], e)
+	f.global = append(f.global, e)
+	f.size = e.offset + 4 + int64(e.length)
+}
+
+// writeIndex appends the entries to the index file
+func (f *File) writeIndex(entries []entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	for _, e := range entries {
+		b.Write(encodeEntry(e))
+	}
+	_, err := f.index.Write(b.Bytes())
+	return err
+}
+
+// encodeEntry encodes the entry as event id, aggregate id, offset, length, version, type length and type
+func encodeEntry(e entry) []byte {
+	b := make([]byte, 54+len(e.aggregateType))
+	copy(b[0:16], e.eventID[:])
+	copy(b[16:32], e.aggregateID[:])
+	binary.BigEndian.PutUint64(b[32:40], uint64(e.offset))
+	binary.BigEndian.PutUint32(b[40:44], e.length)
+	binary.BigEndian.PutUint64(b[44:52], uint64(e.version))
+	binary.BigEndian.PutUint16(b[52:54], uint16(len(e.aggregateType)))
+	copy(b[54:], e.aggregateType)
+	return b
+}
+
+// decodeEntry decodes an entry from the start of b and returns the number of bytes it used
+func decodeEntry(b []byte) (entry, int, bool) {
+	if len(b) < 54 {
+		return entry{}, 0, false
+	}
+	n := 54 + int(binary.BigEndian.Uint16(b[52:54]))
+	if len(b) < n {
+		return entry{}, 0, false
+	}
+	e := entry{
+		offset:        int64(binary.BigEndian.Uint64(b[32:40])),
+		length:        binary.BigEndian.Uint32(b[40:44]),
+		version:       eventsourcing.Version(binary.BigEndian.Uint64(b[44:52])),
+		aggregateType: string(b[54:n]),
+	}
+	copy(e.eventID[:], b[0:16])
+	copy(e.aggregateID[:], b[16:32])
+	return e, n, true
+}
+
+// Save appends the events to the log, the log is synced to disk before the index is updated
+func (f *File) Save(events []eventsourcing.Event) error {
+	// Return if there is no events to save
+	if len(events) == 0 {
+		return nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	aggregateID := events[0].AggregateID
+	aggregateType := events[0].AggregateType
+	currentVersion := eventsourcing.Version(0)
+	if stored := f.aggregates[aggregateKey(aggregateType, aggregateID)]; len(stored) > 0 {
+		currentVersion = stored[len(stored)-1].version
+	}
+	err := eventstore.ValidateEvents(aggregateID, currentVersion, events)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	entries := make([]entry, 0, len(events))
+	offset := f.size
+	for _, event := range events {
+		data, tag, err := f.serializer.MarshalFormat(event.AggregateType, event.Data)
+		if err != nil {
+			return err
+		}
+		var metadata []byte
+		if event.Metadata != nil {
+			metadata, err = f.serializer.Marshal(event.Metadata)
+			if err != nil {
+				return err
+			}
+		}
+		rec, err := json.Marshal(record{
+			EventID:     event.EventID,
+			AggregateID: event.AggregateID,
+			Version:     event.Version,
+			Reason:      event.Reason(),
+			Type:        event.AggregateType,
+			Timestamp:   event.Timestamp,
+			Data:        data,
+			Metadata:    metadata,
+			Format:      tag,
+		})
+		if err != nil {
+			return err
+		}
+		var prefix [4]byte
+		binary.BigEndian.PutUint32(prefix[:], uint32(len(rec)))
+		b.Write(prefix[:])
+		b.Write(rec)
+		entries = append(entries, entry{eventID: event.EventID, aggregateID: aggregateID, aggregateType: aggregateType, version: event.Version, offset: offset, length: uint32(len(rec))})
+		offset += 4 + int64(len(rec))
+	}
+	if _, err = f.log.WriteAt(b.Bytes(), f.size); err != nil {
+		// drop what was written of the events
+		f.log.Truncate(f.size)
+		return err
+	}
+	if err = f.log.Sync(); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		f.add(e)
+	}
+	// the index is rebuilt from the log on Open if the write fails
+	return f.writeIndex(entries)
+}
+
+// Get returns an iterator over the events of the aggregate after the version
+func (f *File) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	var entries []entry
+	for _, e := range f.aggregates[aggregateKey(aggregateType, id)] {
+		if e.version > afterVersion {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, eventsourcing.ErrNoEvents
+	}
+	return &iterator{file: f, ctx: ctx, entries: entries}, nil
+}
+
+// LatestVersion returns the version of the last event of the aggregate, 0 if the aggregate has no events
+func (f *File) LatestVersion(ctx context.Context, id uuid.UUID, aggregateType string) (eventsourcing.Version, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	entries := f.aggregates[aggregateKey(aggregateType, id)]
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	return entries[len(entries)-1].version, nil
+}
+
+// EventCount returns the number of events for the aggregate
+func (f *File) EventCount(ctx context.Context, id uuid.UUID, aggregateType string) (int, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return len(f.aggregates[aggregateKey(aggregateType, id)]), nil
+}
+
+// GlobalEvents returns count events in append order from the first event with an event id equal to or higher than
+// start
+func (f *File) GlobalEvents(start uuid.UUID, count uint64) ([]eventsourcing.Event, error) {
+	f.lock.RLock()
+	var entries []entry
+	for _, e := range f.global {
+		if uint64(len(entries)) == count {
+			break
+		}
+		if bytes.Compare(e.eventID[:], start[:]) >= 0 {
+			entries = append(entries, e)
+		}
+	}
+	f.lock.RUnlock()
+
+	events := make([]eventsourcing.Event, 0, len(entries))
+	for _, e := range entries {
+		event, ok, err := f.read(e)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// read reads and decodes the event at the entry, ok is false if the event is skipped as it's not registered
+func (f *File) read(e entry) (eventsourcing.Event, bool, error) {
+	b := make([]byte, e.length)
+	if _, err := f.log.ReadAt(b, e.offset+4); err != nil {
+		return eventsourcing.Event{}, false, err
+	}
+	var rec record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return eventsourcing.Event{}, false, err
+	}
+	fn, ok := f.serializer.Type(rec.Type, rec.Reason)
+	if !ok {
+		// if the typ/reason is not register jump over the event
+		return eventsourcing.Event{}, false, f.serializer.UnknownEvent(rec.Type, rec.Reason)
+	}
+	data := fn()
+	if err := f.serializer.UnmarshalFormat(rec.Format, rec.Data, &data); err != nil {
+		return eventsourcing.Event{}, false, err
+	}
+	var metadata map[string]interface{}
+	if len(rec.Metadata) > 0 {
+		if err := f.serializer.Unmarshal(rec.Metadata, &metadata); err != nil {
+			return eventsourcing.Event{}, false, err
+		}
+	}
+	return eventsourcing.Event{
+		EventID:       rec.EventID,
+		AggregateID:   rec.AggregateID,
+		Version:       rec.Version,
+		AggregateType: rec.Type,
+		Timestamp:     rec.Timestamp,
+		Data:          data,
+		Metadata:      metadata,
+	}, true, nil
+}
+
+// Close closes the log and index files
+func (f *File) Close() {
+	f.log.Close()
+	f.index.Close()
+}
+
+// aggregateKey generate a aggregate key to store events against from aggregateType and aggregateID
+func aggregateKey(aggregateType string, aggregateID uuid.UUID) string {
+	return aggregateType + "_" + aggregateID.String()
+}
@@ -0,0 +1,200 @@
+package file_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/file"
+	"github.com/hallgren/eventsourcing/eventstore/suite"
+)
+
+func TestSuite(t *testing.T) {
+	f := func(ser eventsourcing.Serializer) (eventsourcing.EventStore, func(), error) {
+		es, err := file.Open(filepath.Join(t.TempDir(), "events.log"), ser)
+		if err != nil {
+			return nil, nil, err
+		}
+		return es, func() { es.Close() }, nil
+	}
+	suite.Test(t, f)
+}
+
+func newSerializer() eventsourcing.Serializer {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	ser.Register(&suite.FrequentFlierAccount{}, ser.Events(&suite.FlightTaken{}))
+	return *ser
+}
+
+// flights returns count FlightTaken events for the aggregate starting at version from+1
+func flights(id uuid.UUID, from eventsourcing.Version, count int) []eventsourcing.Event {
+	var events []eventsourcing.Event
+	for i := 1; i <= count; i++ {
+		events = append(events, eventsourcing.Event{
+			EventID:       eventsourcing.NewUuid(),
+			AggregateID:   id,
+			Version:       from + eventsourcing.Version(i),
+			AggregateType: "FrequentFlierAccount",
+			Timestamp:     time.Now().UTC(),
+			Data:          &suite.FlightTaken{MilesAdded: i, TierPointsAdded: i},
+		})
+	}
+	return events
+}
+
+// getAll returns the events of the aggregate
+func getAll(t *testing.T, es *file.File, id uuid.UUID) []eventsourcing.Event {
+	iterator, err := es.Get(context.Background(), id, "FrequentFlierAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	var events []eventsourcing.Event
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, eventsourcing.ErrNoMoreEvents) {
+			return events
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+}
+
+// saveAndReopen saves 5 events, closes the store and calls damage before the store is opened again
+func saveAndReopen(t *testing.T, damage func(path string)) (*file.File, uuid.UUID) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	es, err := file.Open(path, newSerializer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = es.Save(flights(id, 3, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	es.Close()
+	damage(path)
+	es, err = file.Open(path, newSerializer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return es, id
+}
+
+func TestReopen(t *testing.T) {
+	es, id := saveAndReopen(t, func(path string) {})
+	defer es.Close()
+	events := getAll(t, es, id)
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events got %d", len(events))
+	}
+	if events[4].Data.(*suite.FlightTaken).MilesAdded != 2 {
+		t.Fatalf("unexpected event data %v", events[4].Data)
+	}
+	// the version check continues after the stored events
+	err := es.Save(flights(id, 5, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAllEventsUnregistered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	es, err := file.Open(path, newSerializer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	if err = es.Save(flights(id, 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	es.Close()
+	// reopen with a serializer where nothing is registered
+	es, err = file.Open(path, *eventsourcing.NewSerializer(json.Marshal, json.Unmarshal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	repo := eventsourcing.NewRepository(es, nil)
+	err = repo.Get(id, &suite.FrequentFlierAccount{})
+	if !errors.Is(err, eventsourcing.ErrAllEventsUnregistered) {
+		t.Fatalf("expected ErrAllEventsUnregistered got %v", err)
+	}
+	err = repo.Get(eventsourcing.NewUuid(), &suite.FrequentFlierAccount{})
+	if !errors.Is(err, eventsourcing.ErrAggregateNotFound) {
+		t.Fatalf("expected ErrAggregateNotFound for an aggregate without events got %v", err)
+	}
+}
+
+func TestRecoverIndexFromLog(t *testing.T) {
+	es, id := saveAndReopen(t, func(path string) {
+		if err := os.Remove(path + ".idx"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer es.Close()
+	if len(getAll(t, es, id)) != 5 {
+		t.Fatal("expected the index to be rebuilt from the log")
+	}
+}
+
+func TestRecoverTornWrite(t *testing.T) {
+	es, id := saveAndReopen(t, func(path string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a length prefix without the record it belongs to
+		f.Write([]byte{0, 0, 1, 0, '{'})
+		f.Close()
+	})
+	defer es.Close()
+	if len(getAll(t, es, id)) != 5 {
+		t.Fatal("expected the complete records to be kept")
+	}
+	err := es.Save(flights(id, 5, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getAll(t, es, id)) != 6 {
+		t.Fatal("expected the event saved after the torn write")
+	}
+}
+
+func TestGlobalEventsAppendOrder(t *testing.T) {
+	es, err := file.Open(filepath.Join(t.TempDir(), "events.log"), newSerializer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+	id1 := eventsourcing.NewUuid()
+	id2 := eventsourcing.NewUuid()
+	if err = es.Save(flights(id1, 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err = es.Save(flights(id2, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	events, err := es.GlobalEvents(uuid.Nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events got %d", len(events))
+	}
+	if events[2].AggregateID != id2 {
+		t.Fatal("expected the events in append order")
+	}
+}
@@ -0,0 +1,44 @@
+package file
+
+import (
+	"context"
+
+	"github.com/hallgren/eventsourcing"
+)
+
+type iterator struct {
+	file    *File
+	ctx     context.Context
+	entries []entry
+	// read and skipped count the returned and unregistered events
+	read, skipped int
+}
+
+// Next reads the next event from the log
+func (i *iterator) Next() (eventsourcing.Event, error) {
+	for len(i.entries) > 0 {
+		if err := i.ctx.Err(); err != nil {
+			return eventsourcing.Event{}, err
+		}
+		e := i.entries[0]
+		i.entries = i.entries[1:]
+		event, ok, err := i.file.read(e)
+		if err != nil {
+			return eventsourcing.Event{}, err
+		}
+		if ok {
+			i.read++
+			return event, nil
+		}
+		i.skipped++
+	}
+	if i.read == 0 && i.skipped > 0 {
+		return eventsourcing.Event{}, eventsourcing.ErrAllEventsUnregistered
+	}
+	return eventsourcing.Event{}, eventsourcing.ErrNoMoreEvents
+}
+
+// Close closes the iterator
+func (i *iterator) Close() {
+	i.entries = nil
+}
@@ -55,35 +55,63 @@ func (e *Memory) Save(events []eventsourcing.Event) error {
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
-	// get bucket name from first event
-	aggregateType := events[0].AggregateType
-	aggregateID := events[0].AggregateID
-	bucketName := aggregateKey(aggregateType, aggregateID)
-
-	evBucket := e.aggregateEvents[bucketName]
-	currentVersion := eventsourcing.Version(0)
-
-	if len(evBucket) > 0 {
-		// Last version in the list
-		lastEvent := evBucket[len(evBucket)-1]
-		currentVersion = lastEvent.Version
-	}
-
+	bucketName := aggregateKey(events[0].AggregateType, events[0].AggregateID)
 	//Validate events
-	err := eventstore.ValidateEvents(aggregateID, currentVersion, events)
+	err := eventstore.ValidateEvents(events[0].AggregateID, e.currentVersion(bucketName), events)
 	if err != nil {
 		return err
 	}
+	e.append(bucketName, events)
+	return nil
+}
 
-	for _, event := range events {
-		evBucket = append(evBucket, event)
-		e.eventsInOrder = append(e.eventsInOrder, event)
-	}
+// SaveMany saves the events of several aggregates, either all or none of the events are saved. Each slice holds
+// the events of one aggregate.
+func (e *Memory) SaveMany(ctx context.Context, batches [][]eventsourcing.Event) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
 
-	e.aggregateEvents[bucketName] = evBucket
+	// validate all events before any is saved, an aggregate can be in more than one slice
+	versions := make(map[string]eventsourcing.Version)
+	for _, events := range batches {
+		if len(events) == 0 {
+			continue
+		}
+		bucketName := aggregateKey(events[0].AggregateType, events[0].AggregateID)
+		currentVersion, ok := versions[bucketName]
+		if !ok {
+			currentVersion = e.currentVersion(bucketName)
+		}
+		err := eventstore.ValidateEvents(events[0].AggregateID, currentVersion, events)
+		if err != nil {
+			return err
+		}
+		versions[bucketName] = events[len(events)-1].Version
+	}
+	for _, events := range batches {
+		if len(events) > 0 {
+			e.append(aggregateKey(events[0].AggregateType, events[0].AggregateID), events)
+		}
+	}
 	return nil
 }
 
+// currentVersion returns the version of the last event in the bucket
+func (e *Memory) currentVersion(bucketName string) eventsourcing.Version {
+	evBucket := e.aggregateEvents[bucketName]
+	if len(evBucket) == 0 {
+		return 0
+	}
+	// Last version in the list
+	return evBucket[len(evBucket)-1].Version
+}
+
+// append adds the validated events to the bucket and the global order
+func (e *Memory) append(bucketName string, events []eventsourcing.Event) {
+	e.aggregateEvents[bucketName] = append(e.aggregateEvents[bucketName], events...)
+	e.eventsInOrder = append(e.eventsInOrder, events...)
+}
+
 // Get aggregate events
 func (e *Memory) Get(ctx context.Context, aggregateId uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
 	var events []eventsourcing.Event
@@ -102,6 +130,34 @@ func (e *Memory) Get(ctx context.Context, aggregateId uuid.UUID, aggregateType s
 	return &iterator{events: events}, nil
 }
 
+// LatestVersion returns the version of the last event of the aggregate, 0 if the aggregate has no events
+func (e *Memory) LatestVersion(ctx context.Context, id uuid.UUID, aggregateType string) (eventsourcing.Version, error) {
+	// make sure its thread safe
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	events := e.aggregateEvents[aggregateKey(aggregateType, id)]
+	if len(events) == 0 {
+		return 0, nil
+	}
+	return events[len(events)-1].Version, nil
+}
+
+// EventCount returns the number of events for the aggregate
+func (e *Memory) EventCount(ctx context.Context, id uuid.UUID, aggregateType string) (int, error) {
+	// make sure its thread safe
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return len(e.aggregateEvents[aggregateKey(aggregateType, id)]), nil
+}
+
+// GlobalCount returns the number of events in the store
+func (e *Memory) GlobalCount(ctx context.Context) (uint64, error) {
+	// make sure its thread safe
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return uint64(len(e.eventsInOrder)), nil
+}
+
 // GlobalEvents will return count events in order globaly from the start posistion
 func (e *Memory) GlobalEvents(start uuid.UUID, count uint64) ([]eventsourcing.Event, error) {
 	var events []eventsourcing.Event
@@ -122,6 +178,32 @@ func (e *Memory) GlobalEvents(start uuid.UUID, count uint64) ([]eventsourcing.Ev
 	return events, nil
 }
 
+// Truncate deletes the events of the aggregate with a version up to and including toVersion
+func (e *Memory) Truncate(ctx context.Context, id uuid.UUID, aggregateType string, toVersion eventsourcing.Version) error {
+	// make sure its thread safe
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	key := aggregateKey(aggregateType, id)
+	var kept []eventsourcing.Event
+	for _, event := range e.aggregateEvents[key] {
+		if event.Version > toVersion {
+			kept = append(kept, event)
+		}
+	}
+	e.aggregateEvents[key] = kept
+
+	inOrder := e.eventsInOrder[:0]
+	for _, event := range e.eventsInOrder {
+		if event.AggregateID == id && event.AggregateType == aggregateType && event.Version <= toVersion {
+			continue
+		}
+		inOrder = append(inOrder, event)
+	}
+	e.eventsInOrder = inOrder
+	return nil
+}
+
 // Close does nothing
 func (e *Memory) Close() {}
 
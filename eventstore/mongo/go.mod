@@ -0,0 +1,11 @@
+module github.com/hallgren/eventsourcing/eventstore/mongo
+
+go 1.18
+
+require (
+	github.com/gofrs/uuid v4.2.0+incompatible
+	github.com/hallgren/eventsourcing v0.0.20
+	go.mongodb.org/mongo-driver v1.11.4
+)
+
+//replace github.com/hallgren/eventsourcing => ../..
@@ -0,0 +1,36 @@
+package mongo
+
+import (
+	"context"
+
+	"github.com/hallgren/eventsourcing"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type iterator struct {
+	ctx    context.Context
+	cursor *mongo.Cursor
+	store  *Mongo
+}
+
+// Next return the next event
+func (i *iterator) Next() (eventsourcing.Event, error) {
+	for i.cursor.Next(i.ctx) {
+		event, ok, err := i.store.decode(i.cursor)
+		if err != nil {
+			return eventsourcing.Event{}, err
+		}
+		if ok {
+			return event, nil
+		}
+	}
+	if err := i.cursor.Err(); err != nil {
+		return eventsourcing.Event{}, err
+	}
+	return eventsourcing.Event{}, eventsourcing.ErrNoMoreEvents
+}
+
+// Close closes the cursor
+func (i *iterator) Close() {
+	i.cursor.Close(context.Background())
+}
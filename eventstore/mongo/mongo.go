@@ -0,0 +1,244 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// counterID is the id of the counter document that holds the last global version
+const counterID = "events"
+
+// Mongo is an event store that saves each event as a document in the events collection. A unique index on the
+// aggregate id, type and version makes concurrent saves on an aggregate conflict, and a counter in the counters
+// collection updated in the save transaction gives the events a global version in commit order. Transactions
+// require MongoDB to run as a replica set.
+type Mongo struct {
+	client     *mongo.Client
+	events     *mongo.Collection
+	counters   *mongo.Collection
+	serializer eventsourcing.Serializer
+}
+
+// document is an event as it's stored in the events collection
+type document struct {
+	EventID       []byte    `bson:"_id"`
+	AggregateID   string    `bson:"aggregate_id"`
+	AggregateType string    `bson:"aggregate_type"`
+	Version       uint64    `bson:"version"`
+	GlobalVersion int64     `bson:"global_version"`
+	Reason        string    `bson:"reason"`
+	Timestamp     time.Time `bson:"timestamp"`
+	Data          []byte    `bson:"data"`
+	Metadata      []byte    `bson:"metadata,omitempty"`
+	Format        string    `bson:"format,omitempty"`
+}
+
+// Open returns a Mongo event store using the events and counters collections in the database
+func Open(client *mongo.Client, database string, serializer eventsourcing.Serializer) *Mongo {
+	db := client.Database(database)
+	return &Mongo{
+		client:     client,
+		events:     db.Collection("events"),
+		counters:   db.Collection("counters"),
+		serializer: serializer,
+	}
+}
+
+// Migrate creates the indexes of the events collection, it's safe to call on each start
+func (m *Mongo) Migrate() error {
+	_, err := m.events.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "aggregate_id", Value: 1}, {Key: "aggregate_type", Value: 1}, {Key: "version", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "global_version", Value: 1}},
+		},
+	})
+	return err
+}
+
+// Close disconnects the client
+func (m *Mongo) Close() {
+	m.client.Disconnect(context.Background())
+}
+
+// Save inserts the events and increments the global version counter in one transaction. A save that conflicts
+// with another save of the aggregate returns ErrConcurrency.
+func (m *Mongo) Save(events []eventsourcing.Event) error {
+	// Return if there is no events to save
+	if len(events) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	aggregateID := events[0].AggregateID
+	aggregateType := events[0].AggregateType
+
+	docs := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		data, tag, err := m.serializer.MarshalFormat(event.AggregateType, event.Data)
+		if err != nil {
+			return err
+		}
+		var metadata []byte
+		if event.Metadata != nil {
+			metadata, err = m.serializer.Marshal(event.Metadata)
+			if err != nil {
+				return err
+			}
+		}
+		docs = append(docs, &document{
+			EventID:       event.EventID.Bytes(),
+			AggregateID:   event.AggregateID.String(),
+			AggregateType: event.AggregateType,
+			Version:       uint64(event.Version),
+			Reason:        event.Reason(),
+			Timestamp:     event.Timestamp,
+			Data:          data,
+			Metadata:      metadata,
+			Format:        tag,
+		})
+	}
+
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		currentVersion, err := m.LatestVersion(sc, aggregateID, aggregateType)
+		if err != nil {
+			return nil, err
+		}
+		err = eventstore.ValidateEvents(aggregateID, currentVersion, events)
+		if err != nil {
+			return nil, err
+		}
+		var counter struct {
+			Seq int64 `bson:"seq"`
+		}
+		err = m.counters.FindOneAndUpdate(sc,
+			bson.M{"_id": counterID},
+			bson.M{"$inc": bson.M{"seq": int64(len(docs))}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&counter)
+		if err != nil {
+			return nil, err
+		}
+		first := counter.Seq - int64(len(docs)) + 1
+		for i, doc := range docs {
+			doc.(*document).GlobalVersion = first + int64(i)
+		}
+		_, err = m.events.InsertMany(sc, docs)
+		return nil, err
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return eventstore.ErrConcurrency
+	}
+	return err
+}
+
+// Get returns an iterator over the events of the aggregate after the version sorted by version
+func (m *Mongo) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	filter := bson.M{"aggregate_id": id.String(), "aggregate_type": aggregateType, "version": bson.M{"$gt": uint64(afterVersion)}}
+	cursor, err := m.events.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	return &iterator{ctx: ctx, cursor: cursor, store: m}, nil
+}
+
+// LatestVersion returns the version of the last event of the aggregate, 0 if the aggregate has no events
+func (m *Mongo) LatestVersion(ctx context.Context, id uuid.UUID, aggregateType string) (eventsourcing.Version, error) {
+	var doc document
+	err := m.events.FindOne(ctx,
+		bson.M{"aggregate_id": id.String(), "aggregate_type": aggregateType},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+	).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return eventsourcing.Version(doc.Version), nil
+}
+
+// EventCount returns the number of events for the aggregate
+func (m *Mongo) EventCount(ctx context.Context, id uuid.UUID, aggregateType string) (int, error) {
+	count, err := m.events.CountDocuments(ctx, bson.M{"aggregate_id": id.String(), "aggregate_type": aggregateType})
+	return int(count), err
+}
+
+// GlobalEvents returns count events sorted by event id from the events with an event id equal to or higher than
+// start. The events are filtered and sorted on the same key, paging with the id of the last event neither skips
+// nor repeats events.
+func (m *Mongo) GlobalEvents(start uuid.UUID, count uint64) ([]eventsourcing.Event, error) {
+	ctx := context.Background()
+	cursor, err := m.events.Find(ctx,
+		bson.M{"_id": bson.M{"$gte": start.Bytes()}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(count)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var events []eventsourcing.Event
+	for cursor.Next(ctx) {
+		event, ok, err := m.decode(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			events = append(events, event)
+		}
+	}
+	return events, cursor.Err()
+}
+
+// decode returns the event at the cursor, ok is false if the event is skipped as it's not registered
+func (m *Mongo) decode(cursor *mongo.Cursor) (eventsourcing.Event, bool, error) {
+	var doc document
+	if err := cursor.Decode(&doc); err != nil {
+		return eventsourcing.Event{}, false, err
+	}
+	f, ok := m.serializer.Type(doc.AggregateType, doc.Reason)
+	if !ok {
+		// if the typ/reason is not register jump over the event
+		return eventsourcing.Event{}, false, m.serializer.UnknownEvent(doc.AggregateType, doc.Reason)
+	}
+	data := f()
+	if err := m.serializer.UnmarshalFormat(doc.Format, doc.Data, &data); err != nil {
+		return eventsourcing.Event{}, false, err
+	}
+	var metadata map[string]interface{}
+	if len(doc.Metadata) > 0 {
+		if err := m.serializer.Unmarshal(doc.Metadata, &metadata); err != nil {
+			return eventsourcing.Event{}, false, err
+		}
+	}
+	eventID, err := uuid.FromBytes(doc.EventID)
+	if err != nil {
+		return eventsourcing.Event{}, false, err
+	}
+	aggregateID, err := uuid.FromString(doc.AggregateID)
+	if err != nil {
+		return eventsourcing.Event{}, false, err
+	}
+	return eventsourcing.Event{
+		EventID:       eventID,
+		AggregateID:   aggregateID,
+		Version:       eventsourcing.Version(doc.Version),
+		AggregateType: doc.AggregateType,
+		Timestamp:     doc.Timestamp,
+		Data:          data,
+		Metadata:      metadata,
+	}, true, nil
+}
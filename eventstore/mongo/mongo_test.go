@@ -0,0 +1,45 @@
+//go:build mongo
+
+package mongo_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/mongo"
+	"github.com/hallgren/eventsourcing/eventstore/suite"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// The tests run against the MongoDB replica set at MONGO_URI: go test -tags mongo ./...
+func TestSuite(t *testing.T) {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		uri = "mongodb://localhost:27017/?replicaSet=rs0"
+	}
+	f := func(ser eventsourcing.Serializer) (eventsourcing.EventStore, func(), error) {
+		client, err := mongodriver.Connect(context.Background(), options.Client().ApplyURI(uri))
+		if err != nil {
+			return nil, nil, err
+		}
+		// use a random database to get new collections on each test run
+		database := fmt.Sprintf("test%d", seededRand.Intn(999999999999))
+		es := mongo.Open(client, database, ser)
+		if err = es.Migrate(); err != nil {
+			return nil, nil, err
+		}
+		return es, func() {
+			client.Database(database).Drop(context.Background())
+			es.Close()
+		}, nil
+	}
+	suite.Test(t, f)
+}
@@ -0,0 +1,11 @@
+module github.com/hallgren/eventsourcing/eventstore/redis
+
+go 1.18
+
+require (
+	github.com/gofrs/uuid v4.2.0+incompatible
+	github.com/hallgren/eventsourcing v0.0.20
+	github.com/redis/go-redis/v9 v9.0.5
+)
+
+//replace github.com/hallgren/eventsourcing => ../..
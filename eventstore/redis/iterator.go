@@ -0,0 +1,32 @@
+package redis
+
+import (
+	"github.com/hallgren/eventsourcing"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+type iterator struct {
+	store    *Redis
+	messages []goredis.XMessage
+}
+
+// Next return the next event
+func (i *iterator) Next() (eventsourcing.Event, error) {
+	for len(i.messages) > 0 {
+		message := i.messages[0]
+		i.messages = i.messages[1:]
+		event, ok, err := i.store.decode(message)
+		if err != nil {
+			return eventsourcing.Event{}, err
+		}
+		if ok {
+			return event, nil
+		}
+	}
+	return eventsourcing.Event{}, eventsourcing.ErrNoMoreEvents
+}
+
+// Close closes the iterator
+func (i *iterator) Close() {
+	i.messages = nil
+}
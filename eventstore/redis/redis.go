@@ -0,0 +1,252 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// saveScript appends the events to the aggregate stream if its length is the expected version and adds them to
+// the global index, the check and the append are atomic as the script runs without other commands in between.
+// KEYS[1] is the aggregate stream and KEYS[2] the global index. ARGV[1] is the expected version followed by the
+// version, record and global index member of each event.
+var saveScript = goredis.NewScript(`
+if redis.call('XLEN', KEYS[1]) ~= tonumber(ARGV[1]) then
+	return 0
+end
+for i = 2, #ARGV, 3 do
+	redis.call('XADD', KEYS[1], ARGV[i] .. '-0', 'event', ARGV[i+1])
+	redis.call('ZADD', KEYS[2], 0, ARGV[i+2])
+end
+return 1
+`)
+
+// Redis is an event store that keeps the events of each aggregate in a Redis stream with the version as the
+// stream entry id. A sorted set indexes all events by event id, the global order of the events. The keys of an
+// aggregate and the global index has to be on the same node, the store does not support Redis Cluster.
+type Redis struct {
+	client     *goredis.Client
+	serializer eventsourcing.Serializer
+	prefix     string
+}
+
+// Option configures the Redis event store
+type Option func(r *Redis)
+
+// WithPrefix sets the prefix of the keys, the default is "eventsourcing"
+func WithPrefix(prefix string) Option {
+	return func(r *Redis) {
+		r.prefix = prefix
+	}
+}
+
+// record is an event as it's stored in the stream
+type record struct {
+	EventID     uuid.UUID             `json:"event_id"`
+	AggregateID uuid.UUID             `json:"aggregate_id"`
+	Version     eventsourcing.Version `json:"version"`
+	Reason      string                `json:"reason"`
+	Type        string                `json:"type"`
+	Timestamp   time.Time             `json:"timestamp"`
+	Data        []byte                `json:"data"`
+	Metadata    []byte                `json:"metadata,omitempty"`
+	Format      string                `json:"format,omitempty"`
+}
+
+// Open returns a Redis event store using the client
+func Open(client *goredis.Client, serializer eventsourcing.Serializer, options ...Option) *Redis {
+	r := &Redis{
+		client:     client,
+		serializer: serializer,
+		prefix:     "eventsourcing",
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// Close closes the Redis client
+func (r *Redis) Close() {
+	r.client.Close()
+}
+
+// streamKey returns the key of the aggregate stream
+func (r *Redis) streamKey(aggregateType string, id uuid.UUID) string {
+	return fmt.Sprintf("%s:aggregate:%s:%s", r.prefix, aggregateType, id)
+}
+
+// globalKey returns the key of the global index
+func (r *Redis) globalKey() string {
+	return r.prefix + ":global"
+}
+
+// Save appends the events to the aggregate stream, ErrConcurrency is returned if the stream has changed since the
+// version the events continue from
+func (r *Redis) Save(events []eventsourcing.Event) error {
+	// Return if there is no events to save
+	if len(events) == 0 {
+		return nil
+	}
+	aggregateID := events[0].AggregateID
+	aggregateType := events[0].AggregateType
+	err := eventstore.ValidateEventsNoVersionCheck(aggregateID, events)
+	if err != nil {
+		return err
+	}
+	key := r.streamKey(aggregateType, aggregateID)
+	args := []interface{}{uint64(events[0].Version - 1)}
+	for _, event := range events {
+		data, tag, err := r.serializer.MarshalFormat(event.AggregateType, event.Data)
+		if err != nil {
+			return err
+		}
+		var metadata []byte
+		if event.Metadata != nil {
+			metadata, err = r.serializer.Marshal(event.Metadata)
+			if err != nil {
+				return err
+			}
+		}
+		rec, err := json.Marshal(record{
+			EventID:     event.EventID,
+			AggregateID: event.AggregateID,
+			Version:     event.Version,
+			Reason:      event.Reason(),
+			Type:        event.AggregateType,
+			Timestamp:   event.Timestamp,
+			Data:        data,
+			Metadata:    metadata,
+			Format:      tag,
+		})
+		if err != nil {
+			return err
+		}
+		args = append(args, uint64(event.Version), rec, globalMember(event.EventID, event.Version, key))
+	}
+	saved, err := saveScript.Run(context.Background(), r.client, []string{key, r.globalKey()}, args...).Int()
+	if err != nil {
+		return err
+	}
+	if saved == 0 {
+		return eventstore.ErrConcurrency
+	}
+	return nil
+}
+
+// globalMember returns the global index member of the event. All members has the same score and are sorted on the
+// event id in hex.
+func globalMember(eventID uuid.UUID, version eventsourcing.Version, key string) string {
+	return fmt.Sprintf("%x|%d|%s", eventID.Bytes(), version, key)
+}
+
+// Get returns the events of the aggregate after the version
+func (r *Redis) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	messages, err := r.client.XRange(ctx, r.streamKey(aggregateType, id), fmt.Sprintf("%d-0", afterVersion+1), "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, eventsourcing.ErrNoEvents
+	}
+	return &iterator{store: r, messages: messages}, nil
+}
+
+// LatestVersion returns the version of the last event of the aggregate, 0 if the aggregate has no events
+func (r *Redis) LatestVersion(ctx context.Context, id uuid.UUID, aggregateType string) (eventsourcing.Version, error) {
+	length, err := r.client.XLen(ctx, r.streamKey(aggregateType, id)).Result()
+	if err != nil {
+		return 0, err
+	}
+	return eventsourcing.Version(length), nil
+}
+
+// EventCount returns the number of events for the aggregate
+func (r *Redis) EventCount(ctx context.Context, id uuid.UUID, aggregateType string) (int, error) {
+	length, err := r.client.XLen(ctx, r.streamKey(aggregateType, id)).Result()
+	return int(length), err
+}
+
+// GlobalEvents returns count events in global order from start, start is included in the returned events
+func (r *Redis) GlobalEvents(start uuid.UUID, count uint64) ([]eventsourcing.Event, error) {
+	ctx := context.Background()
+	members, err := r.client.ZRangeByLex(ctx, r.globalKey(), &goredis.ZRangeBy{
+		Min:   fmt.Sprintf("[%x", start.Bytes()),
+		Max:   "+",
+		Count: int64(count),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := r.client.Pipeline()
+	cmds := make([]*goredis.XMessageSliceCmd, 0, len(members))
+	for _, member := range members {
+		parts := strings.SplitN(member, "|", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid global index member %q", member)
+		}
+		cmds = append(cmds, pipe.XRange(ctx, parts[2], parts[1]+"-0", parts[1]+"-0"))
+	}
+	if len(cmds) > 0 {
+		if _, err = pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+	events := make([]eventsourcing.Event, 0, len(cmds))
+	for _, cmd := range cmds {
+		for _, message := range cmd.Val() {
+			event, ok, err := r.decode(message)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+// decode returns the event of the stream message, ok is false if the event is skipped as it's not registered
+func (r *Redis) decode(message goredis.XMessage) (eventsourcing.Event, bool, error) {
+	value, ok := message.Values["event"].(string)
+	if !ok {
+		return eventsourcing.Event{}, false, errors.New("stream message without event")
+	}
+	var rec record
+	if err := json.Unmarshal([]byte(value), &rec); err != nil {
+		return eventsourcing.Event{}, false, err
+	}
+	f, ok := r.serializer.Type(rec.Type, rec.Reason)
+	if !ok {
+		// if the typ/reason is not register jump over the event
+		return eventsourcing.Event{}, false, r.serializer.UnknownEvent(rec.Type, rec.Reason)
+	}
+	data := f()
+	if err := r.serializer.UnmarshalFormat(rec.Format, rec.Data, &data); err != nil {
+		return eventsourcing.Event{}, false, err
+	}
+	var metadata map[string]interface{}
+	if len(rec.Metadata) > 0 {
+		if err := r.serializer.Unmarshal(rec.Metadata, &metadata); err != nil {
+			return eventsourcing.Event{}, false, err
+		}
+	}
+	return eventsourcing.Event{
+		EventID:       rec.EventID,
+		AggregateID:   rec.AggregateID,
+		Version:       rec.Version,
+		AggregateType: rec.Type,
+		Timestamp:     rec.Timestamp,
+		Data:          data,
+		Metadata:      metadata,
+	}, true, nil
+}
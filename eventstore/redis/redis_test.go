@@ -0,0 +1,35 @@
+//go:build redis
+
+package redis_test
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/redis"
+	"github.com/hallgren/eventsourcing/eventstore/suite"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// The tests run against the Redis at REDIS_ADDR (default localhost:6379): go test -tags redis ./...
+func TestSuite(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	f := func(ser eventsourcing.Serializer) (eventsourcing.EventStore, func(), error) {
+		client := goredis.NewClient(&goredis.Options{Addr: addr})
+		// use a random prefix to get new keys on each test run
+		es := redis.Open(client, ser, redis.WithPrefix(fmt.Sprintf("test%d", seededRand.Intn(999999999999))))
+		return es, func() {
+			es.Close()
+		}, nil
+	}
+	suite.Test(t, f)
+}
@@ -0,0 +1,127 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/backoff"
+)
+
+// RetryEventStore wraps an event store and retries Save and Get when the returned error is
+// classified as retryable. Validation and concurrency errors are never retried as they will
+// fail the same way on every attempt.
+type RetryEventStore struct {
+	store       eventsourcing.EventStore
+	isRetryable func(err error) bool
+	maxAttempts int
+	backoff     backoff.Backoff
+	logger      eventsourcing.Logger
+}
+
+// NewRetryEventStore returns a RetryEventStore that makes at most maxAttempts calls to the
+// underlying store. isRetryable decides if an infrastructure error is transient and b gives
+// the time to wait before the next attempt (attempt starts at 1). A nil b retries right away.
+func NewRetryEventStore(store eventsourcing.EventStore, isRetryable func(err error) bool, maxAttempts int, b backoff.Backoff) *RetryEventStore {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	if b == nil {
+		b = backoff.ConstantBackoff{}
+	}
+	return &RetryEventStore{
+		store:       store,
+		isRetryable: isRetryable,
+		maxAttempts: maxAttempts,
+		backoff:     b,
+		logger:      eventsourcing.NoopLogger{},
+	}
+}
+
+// SetLogger sets the logger used to log the retry attempts
+func (r *RetryEventStore) SetLogger(logger eventsourcing.Logger) {
+	r.logger = logger
+}
+
+// Save persists the events and retries on retryable errors
+func (r *RetryEventStore) Save(events []eventsourcing.Event) error {
+	var err error
+	for attempt := 1; attempt <= r.maxAttempts; attempt++ {
+		err = r.store.Save(events)
+		if !r.retry(err, attempt) {
+			return err
+		}
+		time.Sleep(r.backoff.Next(attempt))
+	}
+	return err
+}
+
+// Get fetches the events and retries on retryable errors. The retry loop ends if the context is canceled.
+func (r *RetryEventStore) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	var err error
+	var iterator eventsourcing.EventIterator
+	for attempt := 1; attempt <= r.maxAttempts; attempt++ {
+		iterator, err = r.store.Get(ctx, id, aggregateType, afterVersion)
+		if !r.retry(err, attempt) {
+			return iterator, err
+		}
+		timer := time.NewTimer(r.backoff.Next(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return iterator, err
+}
+
+// LatestVersion fetches the latest version and retries on retryable errors. The retry loop ends if the context
+// is canceled.
+func (r *RetryEventStore) LatestVersion(ctx context.Context, id uuid.UUID, aggregateType string) (eventsourcing.Version, error) {
+	var err error
+	var version eventsourcing.Version
+	for attempt := 1; attempt <= r.maxAttempts; attempt++ {
+		version, err = eventsourcing.LatestVersion(ctx, r.store, id, aggregateType)
+		if !r.retry(err, attempt) {
+			return version, err
+		}
+		timer := time.NewTimer(r.backoff.Next(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return version, err
+}
+
+// retry returns true if the error should trigger one more attempt
+func (r *RetryEventStore) retry(err error, attempt int) bool {
+	if err == nil || attempt >= r.maxAttempts {
+		return false
+	}
+	if deterministic(err) {
+		return false
+	}
+	if r.isRetryable == nil || !r.isRetryable(err) {
+		return false
+	}
+	r.logger.Warn("retry event store call", "attempt", attempt, "max_attempts", r.maxAttempts, "error", err)
+	return true
+}
+
+// deterministic returns true for errors that will be the same on every attempt
+func deterministic(err error) bool {
+	return errors.Is(err, ErrConcurrency) ||
+		errors.Is(err, ErrInvalidVersion) ||
+		errors.Is(err, ErrEventMultipleAggregates) ||
+		errors.Is(err, ErrEventMultipleAggregateTypes) ||
+		errors.Is(err, ErrReasonMissing) ||
+		errors.Is(err, eventsourcing.ErrNoEvents) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
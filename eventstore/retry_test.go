@@ -0,0 +1,181 @@
+package eventstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/backoff"
+	"github.com/hallgren/eventsourcing/eventstore"
+	"github.com/hallgren/eventsourcing/eventstore/memory"
+)
+
+var errTransient = errors.New("connection reset")
+
+// failingStore fails the first failures calls to Save and Get with err
+type failingStore struct {
+	eventsourcing.EventStore
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *failingStore) Save(events []eventsourcing.Event) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return f.EventStore.Save(events)
+}
+
+func (f *failingStore) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, f.err
+	}
+	return f.EventStore.Get(ctx, id, aggregateType, afterVersion)
+}
+
+type Born struct{}
+
+func retryable(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+var noBackoff = backoff.ConstantBackoff{}
+
+func events(id uuid.UUID) []eventsourcing.Event {
+	return []eventsourcing.Event{
+		{EventID: eventsourcing.NewUuid(), AggregateID: id, Version: 1, AggregateType: "Person", Data: &Born{}},
+	}
+}
+
+func TestRetrySaveSucceedsAfterFailures(t *testing.T) {
+	fake := &failingStore{EventStore: memory.Create(), failures: 2, err: errTransient}
+	store := eventstore.NewRetryEventStore(fake, retryable, 3, noBackoff)
+
+	err := store.Save(events(eventsourcing.NewUuid()))
+	if err != nil {
+		t.Fatalf("expected save to succeed after retries, got %v", err)
+	}
+	if fake.calls != 3 {
+		t.Fatalf("expected 3 calls got %d", fake.calls)
+	}
+}
+
+func TestRetrySaveMaxAttempts(t *testing.T) {
+	fake := &failingStore{EventStore: memory.Create(), failures: 5, err: errTransient}
+	store := eventstore.NewRetryEventStore(fake, retryable, 3, noBackoff)
+
+	err := store.Save(events(eventsourcing.NewUuid()))
+	if !errors.Is(err, errTransient) {
+		t.Fatalf("expected errTransient got %v", err)
+	}
+	if fake.calls != 3 {
+		t.Fatalf("expected 3 calls got %d", fake.calls)
+	}
+}
+
+func TestRetrySaveNeverRetryConcurrency(t *testing.T) {
+	fake := &failingStore{EventStore: memory.Create(), failures: 5, err: eventstore.ErrConcurrency}
+	// the predicate says everything is retryable but concurrency errors should still not be retried
+	store := eventstore.NewRetryEventStore(fake, func(err error) bool { return true }, 3, noBackoff)
+
+	err := store.Save(events(eventsourcing.NewUuid()))
+	if !errors.Is(err, eventstore.ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency got %v", err)
+	}
+	if fake.calls != 1 {
+		t.Fatalf("expected 1 call got %d", fake.calls)
+	}
+}
+
+func TestRetrySaveNeverRetryInvalidVersion(t *testing.T) {
+	fake := &failingStore{EventStore: memory.Create(), failures: 5, err: eventstore.ErrInvalidVersion}
+	store := eventstore.NewRetryEventStore(fake, func(err error) bool { return true }, 3, noBackoff)
+
+	err := store.Save(events(eventsourcing.NewUuid()))
+	if !errors.Is(err, eventstore.ErrInvalidVersion) {
+		t.Fatalf("expected ErrInvalidVersion got %v", err)
+	}
+	if fake.calls != 1 {
+		t.Fatalf("expected 1 call got %d", fake.calls)
+	}
+}
+
+func TestRetrySaveNeverRetryValidation(t *testing.T) {
+	fake := &failingStore{EventStore: memory.Create()}
+	store := eventstore.NewRetryEventStore(fake, func(err error) bool { return true }, 3, noBackoff)
+
+	e := append(events(eventsourcing.NewUuid()), events(eventsourcing.NewUuid())...)
+	err := store.Save(e)
+	if !errors.Is(err, eventstore.ErrEventMultipleAggregates) {
+		t.Fatalf("expected ErrEventMultipleAggregates got %v", err)
+	}
+	if fake.calls != 1 {
+		t.Fatalf("expected 1 call got %d", fake.calls)
+	}
+}
+
+func TestRetryGetSucceedsAfterFailures(t *testing.T) {
+	id := eventsourcing.NewUuid()
+	mem := memory.Create()
+	err := mem.Save(events(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &failingStore{EventStore: mem, failures: 1, err: errTransient}
+	store := eventstore.NewRetryEventStore(fake, retryable, 3, noBackoff)
+
+	iterator, err := store.Get(context.Background(), id, "Person", 0)
+	if err != nil {
+		t.Fatalf("expected get to succeed after retries, got %v", err)
+	}
+	defer iterator.Close()
+	if _, err = iterator.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls != 2 {
+		t.Fatalf("expected 2 calls got %d", fake.calls)
+	}
+}
+
+func TestRetryGetContextCanceled(t *testing.T) {
+	fake := &failingStore{EventStore: memory.Create(), failures: 5, err: errTransient}
+	store := eventstore.NewRetryEventStore(fake, retryable, 5, backoff.ConstantBackoff{Delay: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := store.Get(ctx, eventsourcing.NewUuid(), "Person", 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+}
+
+// recordingLogger records the warnings
+type recordingLogger struct {
+	eventsourcing.NoopLogger
+	warnings []string
+}
+
+func (r *recordingLogger) Warn(msg string, kv ...interface{}) {
+	r.warnings = append(r.warnings, msg)
+}
+
+func TestRetryLogsAttempts(t *testing.T) {
+	fake := &failingStore{EventStore: memory.Create(), failures: 2, err: errTransient}
+	store := eventstore.NewRetryEventStore(fake, retryable, 3, noBackoff)
+	logger := &recordingLogger{}
+	store.SetLogger(logger)
+
+	err := store.Save(events(eventsourcing.NewUuid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.warnings) != 2 {
+		t.Fatalf("expected 2 logged retries got %d", len(logger.warnings))
+	}
+}
@@ -0,0 +1,70 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/gofrs/uuid"
+)
+
+const createCheckpointsTable = `CREATE TABLE IF NOT EXISTS checkpoints (name VARCHAR PRIMARY KEY, event_id UUID NOT NULL);`
+
+// Checkpoints is a CheckpointStore that keeps the checkpoints in the checkpoints table. Placed in the same database
+// as the read models, a projection can update the read model and its checkpoint in one transaction via SaveTx.
+type Checkpoints struct {
+	db *sql.DB
+}
+
+// NewCheckpoints returns a checkpoint store on the database
+func NewCheckpoints(db *sql.DB) *Checkpoints {
+	return &Checkpoints{db: db}
+}
+
+// Migrate creates the checkpoints table, it's safe to call on each start
+func (c *Checkpoints) Migrate() error {
+	_, err := c.db.Exec(createCheckpointsTable)
+	return err
+}
+
+// Load returns the checkpoint of the consumer, uuid.Nil if it has none
+func (c *Checkpoints) Load(name string) (uuid.UUID, error) {
+	var checkpoint uuid.UUID
+	err := c.db.QueryRow(`SELECT event_id FROM checkpoints WHERE name = $1`, name).Scan(&checkpoint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	return checkpoint, err
+}
+
+// Save sets the checkpoint of the consumer
+func (c *Checkpoints) Save(name string, checkpoint uuid.UUID) error {
+	tx, err := c.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = c.SaveTx(tx, name, checkpoint)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveTx sets the checkpoint of the consumer in the transaction. The checkpoint is only stored if the transaction
+// commits, which makes it move together with the read model changes made in the same transaction.
+func (c *Checkpoints) SaveTx(tx *sql.Tx, name string, checkpoint uuid.UUID) error {
+	res, err := tx.Exec(`UPDATE checkpoints SET event_id = $1 WHERE name = $2`, checkpoint, name)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated > 0 {
+		return nil
+	}
+	_, err = tx.Exec(`INSERT INTO checkpoints (name, event_id) VALUES ($1, $2)`, name, checkpoint)
+	return err
+}
@@ -0,0 +1,61 @@
+package sql_test
+
+import (
+	sqldriver "database/sql"
+	"database/sql/driver"
+
+	ramsql "github.com/proullon/ramsql/driver"
+)
+
+// the ramsql rows stop reading from the connection when they are closed before the last row, the engine then
+// panics writing the remaining rows when the connection is closed. drainDriver reads the remaining rows on close.
+func init() {
+	sqldriver.Register("ramsql-drain", drainDriver{ramsql.NewDriver()})
+}
+
+type drainDriver struct {
+	driver.Driver
+}
+
+func (d drainDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return drainConn{conn}, nil
+}
+
+type drainConn struct {
+	driver.Conn
+}
+
+func (c drainConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return drainStmt{stmt}, nil
+}
+
+type drainStmt struct {
+	driver.Stmt
+}
+
+func (s drainStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.Stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+	return drainRows{rows}, nil
+}
+
+type drainRows struct {
+	driver.Rows
+}
+
+func (r drainRows) Close() error {
+	dest := make([]driver.Value, len(r.Columns()))
+	for r.Next(dest) == nil {
+	}
+	return r.Rows.Close()
+}
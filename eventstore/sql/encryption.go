@@ -0,0 +1,43 @@
+package sql
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+)
+
+// encrypt encrypts the marshaled data and metadata of the event if the store has encryption enabled
+func encrypt(enc *eventsourcing.EncryptingSerializer, event eventsourcing.Event, data, metadata []byte) ([]byte, []byte, error) {
+	if enc == nil {
+		return data, metadata, nil
+	}
+	data, err := enc.Encrypt(event.AggregateType, event.AggregateID, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if enc.EncryptMetadata() && len(metadata) > 0 {
+		metadata, err = enc.Encrypt(event.AggregateType, event.AggregateID, metadata)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return data, metadata, nil
+}
+
+// decrypt decrypts the stored data and metadata of an event if the store has encryption enabled
+func decrypt(enc *eventsourcing.EncryptingSerializer, aggregateType string, aggregateID uuid.UUID, data, metadata string) ([]byte, []byte, error) {
+	if enc == nil {
+		return []byte(data), []byte(metadata), nil
+	}
+	d, err := enc.Decrypt(aggregateType, aggregateID, []byte(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	m := []byte(metadata)
+	if enc.EncryptMetadata() && len(m) > 0 {
+		m, err = enc.Decrypt(aggregateType, aggregateID, m)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return d, m, nil
+}
@@ -0,0 +1,155 @@
+package sql
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore"
+)
+
+// ErrStoreNotEmpty is returned from Import when the store holds events and WithMerge is not used
+var ErrStoreNotEmpty = errors.New("event store is not empty")
+
+// exportRecord is one line in the export, the data and metadata are kept as stored
+type exportRecord struct {
+	EventID       uuid.UUID             `json:"event_id"`
+	AggregateID   uuid.UUID             `json:"aggregate_id"`
+	Version       eventsourcing.Version `json:"version"`
+	Reason        string                `json:"reason"`
+	AggregateType string                `json:"type"`
+	Timestamp     string                `json:"timestamp"`
+	Data          string                `json:"data"`
+	Metadata      string                `json:"metadata"`
+	Format        string                `json:"format"`
+	Signature     string                `json:"signature,omitempty"`
+}
+
+// ImportOption configures Import
+type ImportOption func(c *importConfig)
+
+type importConfig struct {
+	merge bool
+}
+
+// WithMerge makes Import add the events to a store that already holds events. Imported events of an existing
+// aggregate have to continue on its latest version.
+func WithMerge() ImportOption {
+	return func(c *importConfig) {
+		c.merge = true
+	}
+}
+
+// Export writes all events of the store tenant as newline delimited JSON in global (event id) order. The data and
+// metadata are written as stored, encrypted data stays encrypted.
+func (s *SQL) Export(ctx context.Context, w io.Writer) error {
+	c, err := openCursor(ctx, s.reader(), s.tableQueries(`WHERE tenant_id = ? ORDER BY event_id ASC`, s.tenant))
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	enc := json.NewEncoder(w)
+	for {
+		ok, err := c.next()
+		if err != nil {
+			return err
+		} else if !ok {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var r exportRecord
+		err = c.rows.Scan(&r.EventID, &r.AggregateID, &r.Version, &r.Reason, &r.AggregateType, &r.Timestamp, &r.Data, &r.Metadata, &r.Format, &r.Signature)
+		if err != nil {
+			return err
+		}
+		if err = enc.Encode(r); err != nil {
+			return err
+		}
+	}
+}
+
+// Import reads events written by Export into the store tenant in one transaction. The events are validated as on
+// Save, their type and reason have to be registered in the serializer. Importing into a store that holds events,
+// also archived ones, returns ErrStoreNotEmpty unless WithMerge is used.
+func (s *SQL) Import(ctx context.Context, r io.Reader, options ...ImportOption) error {
+	config := importConfig{}
+	for _, option := range options {
+		option(&config)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start a write transaction, %v", err)
+	}
+	defer tx.Rollback()
+
+	if !config.merge {
+		// archived events count as the archive table is created by the migrations whether archive is used or not
+		for _, table := range []string{"events", "events_archive"} {
+			var count int
+			err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE tenant_id = ?`, s.tenant).Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrStoreNotEmpty
+			}
+		}
+	}
+
+	// the last version of each aggregate, to validate that the imported events are in sequence
+	versions := make(map[string]eventsourcing.Version)
+	insert := `INSERT INTO events (event_id, tenant_id, aggregate_id, version, reason, type, timestamp, data, metadata, format, signature, occurred_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	scanner := bufio.NewScanner(r)
+	// events can be larger than the default 64KB line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var rec exportRecord
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return err
+		}
+		key := rec.AggregateType + "_" + rec.AggregateID.String()
+		current, ok := versions[key]
+		if !ok {
+			current, err = s.latestVersion(ctx, tx, rec.AggregateID, rec.AggregateType)
+			if err != nil {
+				return err
+			}
+		}
+		f, ok := s.serializer.Type(rec.AggregateType, rec.Reason)
+		if !ok {
+			return fmt.Errorf("%w: %s %s", eventsourcing.ErrUnknownEventType, rec.AggregateType, rec.Reason)
+		}
+		// the data is not unmarshalled, the empty value of the registered event carries the reason
+		event := eventsourcing.Event{AggregateID: rec.AggregateID, Version: rec.Version, AggregateType: rec.AggregateType, Data: f()}
+		err = eventstore.ValidateEvents(rec.AggregateID, current, []eventsourcing.Event{event})
+		if err != nil {
+			return err
+		}
+		versions[key] = rec.Version
+		occurredAt, err := time.Parse(time.RFC3339Nano, rec.Timestamp)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, insert, rec.EventID, s.tenant, rec.AggregateID, rec.Version, rec.Reason, rec.AggregateType, rec.Timestamp, rec.Data, rec.Metadata, rec.Format, rec.Signature, formatOccurredAt(occurredAt))
+		if err != nil {
+			return err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,53 +1,174 @@
 package sql
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/hallgren/eventsourcing"
 )
 
+// query is a select statement with its arguments
+type query struct {
+	stm  string
+	args []interface{}
+}
+
+// cursor reads the rows of its queries one query after the other. Reads that include the archive query the
+// events_archive table before the events table instead of a UNION, which not all sql drivers support. The archive
+// only holds events older than the events table, the rows of both tables are then in order.
+type cursor struct {
+	db      *sql.DB
+	ctx     context.Context
+	rows    *sql.Rows
+	queries []query
+}
+
+// openCursor runs the first query and returns a cursor positioned before its first row
+func openCursor(ctx context.Context, db *sql.DB, queries []query) (*cursor, error) {
+	rows, err := db.QueryContext(ctx, queries[0].stm, queries[0].args...)
+	if err != nil {
+		return nil, err
+	} else if ctx.Err() != nil {
+		rows.Close()
+		return nil, ctx.Err()
+	}
+	return &cursor{db: db, ctx: ctx, rows: rows, queries: queries[1:]}, nil
+}
+
+// next moves to the next row, the next query is run when the rows of the current query are read
+func (c *cursor) next() (bool, error) {
+	for !c.rows.Next() {
+		if err := c.rows.Err(); err != nil {
+			return false, err
+		}
+		if len(c.queries) == 0 {
+			return false, nil
+		}
+		c.rows.Close()
+		rows, err := c.db.QueryContext(c.ctx, c.queries[0].stm, c.queries[0].args...)
+		if err != nil {
+			return false, err
+		}
+		c.rows = rows
+		c.queries = c.queries[1:]
+	}
+	return true, nil
+}
+
+func (c *cursor) close() {
+	c.rows.Close()
+}
+
 type iterator struct {
-	rows       *sql.Rows
+	cursor     *cursor
 	serializer eventsourcing.Serializer
+	encryption *eventsourcing.EncryptingSerializer
+	signer     eventsourcing.Signer
+	tenant     string
+	logger     eventsourcing.Logger
+	// prefetch is the number of rows read ahead into buffer, 0 reads one row per Next
+	prefetch int
+	ctx      context.Context
+	buffer   []eventsourcing.Event
+	// err is returned when the buffer read before it is drained
+	err error
+	// scanned and skipped count the rows read and the unregistered events among them
+	scanned, skipped int
 }
 
 // Next return the next event
 func (i *iterator) Next() (eventsourcing.Event, error) {
-	var eventMetadata map[string]interface{}
+	if i.prefetch <= 0 {
+		return i.read()
+	}
+	if len(i.buffer) == 0 {
+		if i.err != nil {
+			return eventsourcing.Event{}, i.err
+		}
+		i.fill()
+		if len(i.buffer) == 0 {
+			return eventsourcing.Event{}, i.err
+		}
+	}
+	event := i.buffer[0]
+	i.buffer = i.buffer[1:]
+	return event, nil
+}
+
+// fill reads up to prefetch events into the buffer, a read error or a canceled context ends the fill and is
+// returned after the buffered events
+func (i *iterator) fill() {
+	i.buffer = make([]eventsourcing.Event, 0, i.prefetch)
+	for len(i.buffer) < i.prefetch {
+		if err := i.ctx.Err(); err != nil {
+			i.err = err
+			return
+		}
+		event, err := i.read()
+		if err != nil {
+			i.err = err
+			return
+		}
+		i.buffer = append(i.buffer, event)
+	}
+}
+
+// read scans and decodes the next row
+func (i *iterator) read() (eventsourcing.Event, error) {
 	var version eventsourcing.Version
 	var eventId, aggregateId uuid.UUID
 	var reason, typ, timestamp string
-	var data, metadata string
-	if !i.rows.Next() {
+	var data, metadata, tag, signature string
+	ok, err := i.cursor.next()
+	if err != nil {
+		return eventsourcing.Event{}, err
+	}
+	if !ok {
+		if i.skipped > 0 && i.skipped == i.scanned {
+			return eventsourcing.Event{}, eventsourcing.ErrAllEventsUnregistered
+		}
 		return eventsourcing.Event{}, eventsourcing.ErrNoMoreEvents
 	}
-	if err := i.rows.Scan(&eventId, &aggregateId, &version, &reason, &typ, &timestamp, &data, &metadata); err != nil {
+	i.scanned++
+	if err := i.cursor.rows.Scan(&eventId, &aggregateId, &version, &reason, &typ, &timestamp, &data, &metadata, &tag, &signature); err != nil {
+		return eventsourcing.Event{}, err
+	}
+	err = verify(i.signer, signedPayload(eventId, i.tenant, aggregateId, version, reason, typ, timestamp, data, metadata, tag), signature)
+	if err != nil {
 		return eventsourcing.Event{}, err
 	}
 
-	t, err := time.Parse(time.RFC3339, timestamp)
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
 	if err != nil {
 		return eventsourcing.Event{}, err
 	}
 
 	f, ok := i.serializer.Type(typ, reason)
 	if !ok {
+		if err = i.serializer.UnknownEvent(typ, reason); err != nil {
+			return eventsourcing.Event{}, err
+		}
+		i.logger.Warn("skipped unregistered event", "event_id", eventId, "type", typ, "reason", reason)
+		i.skipped++
 		// if the typ/reason is not register jump over the event
-		return i.Next()
+		return i.read()
 	}
 
-	eventData := f()
-	err = i.serializer.Unmarshal([]byte(data), &eventData)
+	d, m, err := decrypt(i.encryption, typ, aggregateId, data, metadata)
 	if err != nil {
 		return eventsourcing.Event{}, err
 	}
-	if metadata != "" {
-		err = i.serializer.Unmarshal([]byte(metadata), &eventMetadata)
-		if err != nil {
-			return eventsourcing.Event{}, err
-		}
+	eventData := f()
+	err = i.serializer.UnmarshalFormat(tag, d, &eventData)
+	if err != nil {
+		return eventsourcing.Event{}, fmt.Errorf("unmarshal event %s %s v%d: %w", reason, aggregateId, version, err)
+	}
+	eventMetadata, err := unmarshalMetadata(i.serializer, m)
+	if err != nil {
+		return eventsourcing.Event{}, fmt.Errorf("unmarshal metadata of event %s %s v%d: %w", reason, aggregateId, version, err)
 	}
 
 	event := eventsourcing.Event{
@@ -64,5 +185,23 @@ func (i *iterator) Next() (eventsourcing.Event, error) {
 
 // Close closes the iterator
 func (i *iterator) Close() {
-	i.rows.Close()
+	i.cursor.close()
+}
+
+// unmarshalMetadata returns the stored metadata, nil if no metadata was saved. Metadata is only stored for events
+// with a non-nil map, which makes stored metadata that unmarshals to nil, e.g. from a serializer that decodes an
+// empty object to a nil map, read back as the empty map it was saved from.
+func unmarshalMetadata(serializer eventsourcing.Serializer, m []byte) (map[string]interface{}, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	var metadata map[string]interface{}
+	err := serializer.Unmarshal(m, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	return metadata, nil
 }
@@ -1,35 +1,282 @@
 package sql
 
-import "context"
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
 
-const createTable = `CREATE TABLE events (event_id UUID PRIMARY KEY, aggregate_id UUID NOT NULL, version INTEGER, reason VARCHAR, type VARCHAR, timestamp VARCHAR, data BLOB, metadata BLOB);`
+// createTable is the events table as it was created before the migrations, later columns are added by migrations
+const createTable = `CREATE TABLE IF NOT EXISTS events (event_id UUID PRIMARY KEY, aggregate_id UUID NOT NULL, version INTEGER, reason VARCHAR, type VARCHAR, timestamp VARCHAR, data BLOB, metadata BLOB);`
+const createArchiveTable = `CREATE TABLE IF NOT EXISTS events_archive (event_id UUID PRIMARY KEY, tenant_id VARCHAR NOT NULL DEFAULT '', aggregate_id UUID NOT NULL, version INTEGER, reason VARCHAR, type VARCHAR, timestamp VARCHAR, data BLOB, metadata BLOB, format VARCHAR NOT NULL DEFAULT '', signature VARCHAR NOT NULL DEFAULT '');`
 
-// Migrate the database
-func (s *SQL) Migrate() error {
-	sqlStmt := []string{
+// createTestTable and createTestArchiveTable are the tables with the columns of all migrations as the test sql
+// driver does not support ALTER TABLE
+const createTestTable = `CREATE TABLE events (event_id UUID PRIMARY KEY, tenant_id VARCHAR, aggregate_id UUID NOT NULL, version INTEGER, reason VARCHAR, type VARCHAR, timestamp VARCHAR, data BLOB, metadata BLOB, format VARCHAR, signature VARCHAR, occurred_at TIMESTAMP);`
+const createTestArchiveTable = `CREATE TABLE events_archive (event_id UUID PRIMARY KEY, tenant_id VARCHAR, aggregate_id UUID NOT NULL, version INTEGER, reason VARCHAR, type VARCHAR, timestamp VARCHAR, data BLOB, metadata BLOB, format VARCHAR, signature VARCHAR, occurred_at TIMESTAMP);`
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at VARCHAR);`
+
+// migration is a numbered schema change, a migration that is recorded in schema_migrations is not run again.
+// down reverses up. fill runs after the up statements for data changes that can't be written portably in SQL.
+type migration struct {
+	version int
+	up      []string
+	down    []string
+	fill    func(tx *sql.Tx) error
+}
+
+// migrations are the schema changes in the order they are applied, new changes are added as new versions
+var migrations = []migration{
+	{version: 1, up: []string{
 		createTable,
-		`CREATE UNIQUE INDEX aggregate_id_type_version ON events(aggregate_id, type, version);`,
-		`CREATE INDEX aggregate_id_type ON events (aggregate_id, type);`,
-	}
-	return s.migrate(sqlStmt)
+		`CREATE UNIQUE INDEX IF NOT EXISTS aggregate_id_type_version ON events(aggregate_id, type, version);`,
+		`CREATE INDEX IF NOT EXISTS aggregate_id_type ON events (aggregate_id, type);`,
+	}, down: []string{
+		`DROP TABLE IF EXISTS events;`,
+	}},
+	// the events saved before tenants belong to the empty tenant, the store opened without WithTenant
+	{version: 2, up: []string{
+		`ALTER TABLE events ADD COLUMN tenant_id VARCHAR NOT NULL DEFAULT '';`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS tenant_id_aggregate_id_type_version ON events(tenant_id, aggregate_id, type, version);`,
+		`CREATE INDEX IF NOT EXISTS tenant_id_aggregate_id_type ON events (tenant_id, aggregate_id, type);`,
+		`DROP INDEX IF EXISTS aggregate_id_type_version;`,
+		`DROP INDEX IF EXISTS aggregate_id_type;`,
+	}, down: []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS aggregate_id_type_version ON events(aggregate_id, type, version);`,
+		`CREATE INDEX IF NOT EXISTS aggregate_id_type ON events (aggregate_id, type);`,
+		`DROP INDEX IF EXISTS tenant_id_aggregate_id_type_version;`,
+		`DROP INDEX IF EXISTS tenant_id_aggregate_id_type;`,
+		`ALTER TABLE events DROP COLUMN tenant_id;`,
+	}},
+	// the empty format is the default format of the serializer
+	{version: 3, up: []string{
+		`ALTER TABLE events ADD COLUMN format VARCHAR NOT NULL DEFAULT '';`,
+	}, down: []string{
+		`ALTER TABLE events DROP COLUMN format;`,
+	}},
+	// the events saved before signing has no signature
+	{version: 4, up: []string{
+		`ALTER TABLE events ADD COLUMN signature VARCHAR NOT NULL DEFAULT '';`,
+	}, down: []string{
+		`ALTER TABLE events DROP COLUMN signature;`,
+	}},
+	// events_archive holds the events moved by Archive, it's created whether archive is used or not
+	{version: 5, up: []string{
+		createArchiveTable,
+		`CREATE INDEX IF NOT EXISTS archive_tenant_id_aggregate_id_type ON events_archive (tenant_id, aggregate_id, type);`,
+	}, down: []string{
+		`DROP TABLE IF EXISTS events_archive;`,
+	}},
+	// occurred_at holds the event timestamp as a TIMESTAMP, the timestamp column is a string that can't be
+	// compared as time. The existing events are filled in Go as CAST of the timestamp string differs per database.
+	{version: 6, up: []string{
+		`ALTER TABLE events ADD COLUMN occurred_at TIMESTAMP;`,
+		`ALTER TABLE events_archive ADD COLUMN occurred_at TIMESTAMP;`,
+		`CREATE INDEX IF NOT EXISTS tenant_id_occurred_at ON events (tenant_id, occurred_at);`,
+	}, fill: fillOccurredAt, down: []string{
+		`DROP INDEX IF EXISTS tenant_id_occurred_at;`,
+		`ALTER TABLE events_archive DROP COLUMN occurred_at;`,
+		`ALTER TABLE events DROP COLUMN occurred_at;`,
+	}},
+	// the reason index keeps GlobalEventsByReason from scanning all events
+	{version: 7, up: []string{
+		`CREATE INDEX IF NOT EXISTS tenant_id_reason_event_id ON events (tenant_id, reason, event_id);`,
+	}, down: []string{
+		`DROP INDEX IF EXISTS tenant_id_reason_event_id;`,
+	}},
+}
+
+// testMigrations are the migrations without the statements that the test sql driver does not support, the tables
+// are created with all columns and dropped without IF EXISTS that the driver can't parse
+var testMigrations = []migration{
+	{version: 1, up: []string{createTestTable}, down: []string{`DROP TABLE events;`}},
+	{version: 2},
+	{version: 3},
+	{version: 4},
+	{version: 5, up: []string{createTestArchiveTable}, down: []string{`DROP TABLE events_archive;`}},
+	{version: 6, fill: fillOccurredAt},
+	{version: 7},
+}
+
+// Migrate the database, the migrations that already has run are skipped which makes it safe to call on each start
+func (s *SQL) Migrate() error {
+	return s.migrate(migrations)
 }
 
 // MigrateTest remove the index that the test sql driver does not support
 func (s *SQL) MigrateTest() error {
-	return s.migrate([]string{createTable})
+	return s.migrate(testMigrations)
+}
+
+// Rollback reverses the applied migrations with a version higher than toVersion, in the opposite order they were
+// applied. Rolling back to version 0 drops the tables and the events in them.
+func (s *SQL) Rollback(toVersion int) error {
+	return s.rollback(migrations, toVersion)
+}
+
+// SchemaVersion returns the highest applied migration version, 0 if no migration has run. It only reads the
+// migrations table and does not create it.
+func (s *SQL) SchemaVersion() (int, error) {
+	applied, err := appliedMigrations(s.db)
+	if err != nil {
+		// the migrations table is missing until the first Migrate, the error is returned if the database can't be
+		// reached
+		if err := s.db.Ping(); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
 }
 
-func (s *SQL) migrate(stm []string) error {
+func (s *SQL) migrate(steps []migration) error {
 	tx, err := s.db.BeginTx(context.Background(), nil)
 	if err != nil {
-		return nil
+		return err
 	}
 	defer tx.Rollback()
-	for _, b := range stm {
-		_, err := tx.Exec(b)
+	err = s.ensureMigrationsTable(tx)
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(tx)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if applied[step.version] {
+			continue
+		}
+		for _, b := range step.up {
+			_, err := tx.Exec(b)
+			if err != nil {
+				return err
+			}
+		}
+		if step.fill != nil {
+			err = step.fill(tx)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, step.version, time.Now().UTC().Format(time.RFC3339Nano))
 		if err != nil {
 			return err
 		}
 	}
 	return tx.Commit()
 }
+
+// fillOccurredAt sets occurred_at of the events saved before the column was added from their timestamp, in the
+// format the inserts write
+func fillOccurredAt(tx *sql.Tx) error {
+	for _, table := range []string{"events", "events_archive"} {
+		rows, err := tx.Query(`SELECT event_id, timestamp FROM ` + table + ` WHERE occurred_at IS NULL`)
+		if err != nil {
+			return err
+		}
+		occurredAt := make(map[string]string)
+		for rows.Next() {
+			var eventID, timestamp string
+			if err = rows.Scan(&eventID, &timestamp); err != nil {
+				rows.Close()
+				return err
+			}
+			t, err := time.Parse(time.RFC3339Nano, timestamp)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("event %s in %s: %w", eventID, table, err)
+			}
+			occurredAt[eventID] = formatOccurredAt(t)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		// the rows are updated one by one, not all sql drivers support updating from a select
+		for eventID, value := range occurredAt {
+			_, err = tx.Exec(`UPDATE `+table+` SET occurred_at = $1 WHERE event_id = $2`, value, eventID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SQL) rollback(steps []migration, toVersion int) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = s.ensureMigrationsTable(tx)
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(tx)
+	if err != nil {
+		return err
+	}
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.version <= toVersion || !applied[step.version] {
+			continue
+		}
+		for _, b := range step.down {
+			_, err := tx.Exec(b)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, step.version)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ensureMigrationsTable creates schema_migrations if it's missing. The existence is checked outside the
+// transaction, a failed statement aborts a Postgres transaction, and not left to IF NOT EXISTS as the test sql driver
+// ignores it.
+func (s *SQL) ensureMigrationsTable(tx *sql.Tx) error {
+	rows, err := s.db.Query(`SELECT version FROM schema_migrations`)
+	if err == nil {
+		rows.Close()
+		return nil
+	}
+	_, err = tx.Exec(createMigrationsTable)
+	return err
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations
+func appliedMigrations(q querier) (map[int]bool, error) {
+	rows, err := q.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,110 @@
+package sql
+
+import (
+	sqldriver "database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/proullon/ramsql/driver"
+)
+
+// baselineTable is the events table created by Migrate before the migrations were numbered
+const baselineTable = `CREATE TABLE events (event_id UUID PRIMARY KEY, aggregate_id UUID NOT NULL, version INTEGER, reason VARCHAR, type VARCHAR, timestamp VARCHAR, data BLOB, metadata BLOB);`
+
+// The test sql driver does not support ALTER TABLE, the migrations are checked to upgrade a baseline database
+// instead of run on one.
+func TestMigrationsUpgradeBaseline(t *testing.T) {
+	if strings.Replace(migrations[0].up[0], " IF NOT EXISTS", "", 1) != baselineTable {
+		t.Fatalf("expected the first migration to create the baseline table got %s", migrations[0].up[0])
+	}
+	for _, step := range migrations[1:] {
+		for _, stm := range step.up {
+			if strings.HasPrefix(stm, "CREATE TABLE events ") || strings.HasPrefix(stm, "CREATE TABLE IF NOT EXISTS events ") {
+				t.Fatalf("migration %d recreates the events table", step.version)
+			}
+			if strings.Contains(stm, "ADD COLUMN") && strings.Contains(stm, "NOT NULL") && !strings.Contains(stm, "DEFAULT") {
+				t.Fatalf("migration %d adds a NOT NULL column without default: %s", step.version, stm)
+			}
+		}
+	}
+	// the columns used by the store are added to the baseline table
+	for _, column := range []string{"tenant_id", "format", "signature", "occurred_at"} {
+		added := false
+		for _, step := range migrations[1:] {
+			for _, stm := range step.up {
+				if strings.HasPrefix(stm, "ALTER TABLE events ADD COLUMN "+column+" ") {
+					added = true
+				}
+			}
+		}
+		if !added {
+			t.Fatalf("expected a migration to add the %s column", column)
+		}
+	}
+	if len(testMigrations) != len(migrations) {
+		t.Fatalf("expected %d test migrations got %d", len(migrations), len(testMigrations))
+	}
+	for i, step := range testMigrations {
+		if step.version != migrations[i].version {
+			t.Fatalf("expected test migration %d to have version %d got %d", i, migrations[i].version, step.version)
+		}
+	}
+}
+
+func TestFillOccurredAt(t *testing.T) {
+	db, err := sqldriver.Open("ramsql", fmt.Sprintf("fill-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &SQL{db: db}
+	defer s.Close()
+	// the events saved before migration 6 have no occurred_at
+	err = s.migrate(testMigrations[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := "2024-05-01T10:00:00.5Z"
+	_, err = db.Exec(`INSERT INTO events (event_id, tenant_id, aggregate_id, version, reason, type, timestamp, data, metadata, format, signature) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		"06ad067e-b090-7001-bb98-c7b0f1e0fd5b", "", "06ad067e-b090-7001-bb98-c7b0f1e0fd5c", 1, "Created", "Person", timestamp, "{}", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var occurredAt sqldriver.NullString
+	err = db.QueryRow(`SELECT occurred_at FROM events WHERE event_id = $1`, "06ad067e-b090-7001-bb98-c7b0f1e0fd5b").Scan(&occurredAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if occurredAt.Valid {
+		t.Fatalf("expected no occurred_at before the migration got %s", occurredAt.String)
+	}
+
+	err = s.migrate(testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow(`SELECT occurred_at FROM events WHERE event_id = $1`, "06ad067e-b090-7001-bb98-c7b0f1e0fd5b").Scan(&occurredAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test sql driver returns the TIMESTAMP column in its own format
+	filled, err := time.Parse(time.RFC3339Nano, occurredAt.String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filled.Equal(time.Date(2024, 5, 1, 10, 0, 0, 500000000, time.UTC)) {
+		t.Fatalf("expected occurred_at from the timestamp got %s", occurredAt.String)
+	}
+}
+
+func TestOccurredAtFormatSorts(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	times := []time.Time{base, base.Add(500 * time.Millisecond), base.Add(time.Second), base.Add(30 * time.Minute)}
+	for i := 1; i < len(times); i++ {
+		if formatOccurredAt(times[i-1]) >= formatOccurredAt(times[i]) {
+			t.Fatalf("expected %s to sort before %s", formatOccurredAt(times[i-1]), formatOccurredAt(times[i]))
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid"
+)
+
+// NotificationSource is a Postgres connection that can listen to notifications. database/sql has no support for
+// notifications, wrap the listener of the driver, e.g. a dedicated pgx connection or a lib/pq Listener.
+type NotificationSource interface {
+	// Listen starts listening to the channel
+	Listen(ctx context.Context, channel string) error
+	// WaitForNotification blocks until a notification arrives and returns its payload
+	WaitForNotification(ctx context.Context) (string, error)
+}
+
+// Listen listens to the channel the store notifies with WithNotify and returns the event id of the last event of each
+// save made by any process. The notification is meant to wake up a subscriber that pulls the new events with
+// GlobalEvents. The returned channel is closed when the context is done or the source fails.
+func Listen(ctx context.Context, source NotificationSource, channel string) (<-chan uuid.UUID, error) {
+	err := source.Listen(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	c := make(chan uuid.UUID)
+	go func() {
+		defer close(c)
+		for {
+			payload, err := source.WaitForNotification(ctx)
+			if err != nil {
+				return
+			}
+			id, err := uuid.FromString(payload)
+			if err != nil {
+				// not a notification from the store
+				continue
+			}
+			select {
+			case c <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
@@ -0,0 +1,124 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+)
+
+// RawEvent is an event with the data and metadata left as stored. Decode unmarshal the data when the consumer
+// needs it, which makes scans that only read the event id, version or reason cheap.
+type RawEvent struct {
+	EventID       uuid.UUID
+	AggregateID   uuid.UUID
+	Version       eventsourcing.Version
+	AggregateType string
+	Reason        string
+	Timestamp     time.Time
+	Data          []byte
+	Metadata      []byte
+
+	format     string
+	serializer eventsourcing.Serializer
+	encryption *eventsourcing.EncryptingSerializer
+}
+
+// Decode decrypts and unmarshal the event data into v
+func (e RawEvent) Decode(v interface{}) error {
+	d, _, err := decrypt(e.encryption, e.AggregateType, e.AggregateID, string(e.Data), "")
+	if err != nil {
+		return err
+	}
+	return e.serializer.UnmarshalFormat(e.format, d, v)
+}
+
+// DecodeMetadata decrypts and unmarshal the event metadata into v
+func (e RawEvent) DecodeMetadata(v interface{}) error {
+	if len(e.Metadata) == 0 {
+		return nil
+	}
+	_, m, err := decrypt(e.encryption, e.AggregateType, e.AggregateID, "", string(e.Metadata))
+	if err != nil {
+		return err
+	}
+	return e.serializer.Unmarshal(m, v)
+}
+
+// RawIterator iterates raw events
+type RawIterator struct {
+	cursor *cursor
+	s      *SQL
+}
+
+// Next return the next raw event
+func (i *RawIterator) Next() (RawEvent, error) {
+	ok, err := i.cursor.next()
+	if err != nil {
+		return RawEvent{}, err
+	} else if !ok {
+		return RawEvent{}, eventsourcing.ErrNoMoreEvents
+	}
+	return i.s.rawEvent(i.cursor.rows)
+}
+
+// Close closes the iterator
+func (i *RawIterator) Close() {
+	i.cursor.close()
+}
+
+// GetRaw returns an iterator over the raw events of the aggregate after the version
+func (s *SQL) GetRaw(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (*RawIterator, error) {
+	queries := s.tableQueries(`WHERE tenant_id = ? AND aggregate_id = ? AND type = ? AND version > ? ORDER BY version ASC`, s.tenant, id, aggregateType, afterVersion)
+	c, err := openCursor(ctx, s.reader(), queries)
+	if err != nil {
+		return nil, err
+	}
+	return &RawIterator{cursor: c, s: s}, nil
+}
+
+// GlobalRawEvents return count raw events in global order from the start position
+func (s *SQL) GlobalRawEvents(start uuid.UUID, count uint64) ([]RawEvent, error) {
+	where, args := s.fromStart(`WHERE tenant_id = ?`, start, s.tenant)
+	rows, err := s.reader().Query(selectEvents+`events `+where+` ORDER BY event_id ASC LIMIT ?`, append(args, count)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []RawEvent
+	for rows.Next() {
+		event, err := s.rawEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// rawEvent scans the current row into a raw event
+func (s *SQL) rawEvent(rows *sql.Rows) (RawEvent, error) {
+	var event RawEvent
+	var timestamp, data, metadata, signature string
+	err := rows.Scan(&event.EventID, &event.AggregateID, &event.Version, &event.Reason, &event.AggregateType, &timestamp, &data, &metadata, &event.format, &signature)
+	if err != nil {
+		return RawEvent{}, err
+	}
+	err = verify(s.signer, signedPayload(event.EventID, s.tenant, event.AggregateID, event.Version, event.Reason, event.AggregateType, timestamp, data, metadata, event.format), signature)
+	if err != nil {
+		return RawEvent{}, err
+	}
+	event.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return RawEvent{}, err
+	}
+	event.Data = []byte(data)
+	if metadata != "" {
+		event.Metadata = []byte(metadata)
+	}
+	event.serializer = s.serializer
+	event.encryption = s.encryption
+	return event, nil
+}
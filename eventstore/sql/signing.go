@@ -0,0 +1,46 @@
+package sql
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+)
+
+// signedPayload is the canonical form of an event that is signed, the columns as stored. Each column is length
+// prefixed to make the boundaries between them unambiguous. The tenant is included so that an event moved to
+// another tenant does not verify.
+func signedPayload(eventID uuid.UUID, tenant string, aggregateID uuid.UUID, version eventsourcing.Version, reason, typ, timestamp, data, metadata, format string) []byte {
+	var b bytes.Buffer
+	for _, column := range []string{eventID.String(), tenant, aggregateID.String(), fmt.Sprint(version), reason, typ, timestamp, data, metadata, format} {
+		fmt.Fprintf(&b, "%d:%s", len(column), column)
+	}
+	return b.Bytes()
+}
+
+// sign returns the base64 encoded signature of the payload, empty if the store has no signer
+func sign(signer eventsourcing.Signer, payload []byte) (string, error) {
+	if signer == nil {
+		return "", nil
+	}
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// verify checks the stored signature of the payload if the store has a signer. Events without a signature are
+// treated as tampered as the signature could have been removed.
+func verify(signer eventsourcing.Signer, payload []byte, signature string) error {
+	if signer == nil {
+		return nil
+	}
+	s, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(s) == 0 {
+		return eventsourcing.ErrEventTampered
+	}
+	return signer.Verify(payload, s)
+}
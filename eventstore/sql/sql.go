@@ -1,9 +1,12 @@
 package sql
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -12,99 +15,658 @@ import (
 	"github.com/hallgren/eventsourcing/eventstore"
 )
 
+// ErrArchiveNotEnabled is returned from Archive when the store is not opened with WithArchive
+var ErrArchiveNotEnabled = errors.New("archive is not enabled")
+
+// ErrTenantMismatch is returned from Save when an event belongs to another tenant than the store
+var ErrTenantMismatch = errors.New("event tenant does not match the store tenant")
+
+// TenantMetadataKey is the event metadata key that holds the tenant of the event
+const TenantMetadataKey = "tenant_id"
+
 // SQL event store handler
 type SQL struct {
-	db         *sql.DB
+	db *sql.DB
+	// replica serves the reads when set, writes and the version check in Save always use db
+	replica    *sql.DB
 	serializer eventsourcing.Serializer
+	// archive makes reads include the events_archive table
+	archive bool
+	// tenant scopes all reads and writes to the tenant_id column
+	tenant string
+	// rowsPerInsert is the max number of events inserted in one statement
+	rowsPerInsert int
+	// encryption encrypts the event data when set
+	encryption *eventsourcing.EncryptingSerializer
+	// signer signs the events on save and verifies them on read when set
+	signer eventsourcing.Signer
+	// prefetch is the number of rows the Get iterators read ahead
+	prefetch int
+	// notifyChannel is the Postgres channel notified on save when set
+	notifyChannel string
+	logger        eventsourcing.Logger
+}
+
+// maxParameters is the max number of parameters in one statement, 65535 is the Postgres limit
+const maxParameters = 65535
+
+// insertColumns is the number of parameters used per event in the insert statement
+const insertColumns = 12
+
+// Option configures the SQL event store
+type Option func(s *SQL)
+
+// WithArchive makes Get and Save include events moved to the events_archive table by Archive.
+// Reads that span archived events are slower as both tables has to be queried.
+func WithArchive() Option {
+	return func(s *SQL) {
+		s.archive = true
+	}
+}
+
+// WithTenant scopes the store to a tenant. Events are saved with the tenant and Get, GlobalEvents,
+// EventCount and Archive only sees events of the tenant, which makes it possible for several tenants to
+// share the events table. A store opened without WithTenant uses the empty tenant.
+func WithTenant(id string) Option {
+	return func(s *SQL) {
+		s.tenant = id
+	}
+}
+
+// WithEncryption encrypts the event data, and the metadata if the serializer is set to, before it's stored and
+// decrypts it when read. Events of an aggregate whose key is lost can't be read.
+func WithEncryption(serializer *eventsourcing.EncryptingSerializer) Option {
+	return func(s *SQL) {
+		s.encryption = serializer
+	}
+}
+
+// WithSigner signs each event on save and verifies the signature when events are read, an event that does
+// not match its signature fails the read with eventsourcing.ErrEventTampered. The signature covers the columns
+// as stored, after the data is marshaled and encrypted, and the tenant of the store. Events saved before signing was enabled has no signature
+// and fails verification.
+func WithSigner(signer eventsourcing.Signer) Option {
+	return func(s *SQL) {
+		s.signer = signer
+	}
+}
+
+// WithReadReplica routes Get, GetRange, GlobalEvents, EventCount, CountByReason, the raw reads and Export to the
+// replica while Save, Archive and Import use the primary database. The version check in Save is made on the
+// primary to not get false ErrConcurrency from replication lag. Reads from the replica can lag behind the
+// primary, an aggregate fetched right after a save can miss the last events and the save that follows fails with
+// ErrConcurrency. LatestVersion use the primary.
+func WithReadReplica(replica *sql.DB) Option {
+	return func(s *SQL) {
+		s.replica = replica
+	}
+}
+
+// WithPrefetch makes the iterators returned from Get and GetRange read n rows ahead into a buffer, which cuts the
+// per event overhead when long streams are replayed. The context passed to Get is checked between the rows and a
+// canceled context ends the prefetch, the buffered events are returned before the context error.
+func WithPrefetch(n int) Option {
+	return func(s *SQL) {
+		s.prefetch = n
+	}
+}
+
+// WithNotify makes Save issue a Postgres NOTIFY on the channel in the save transaction with the event id of the last
+// saved event as payload. Other processes wake up on it with Listen, it only works with Postgres.
+func WithNotify(channel string) Option {
+	return func(s *SQL) {
+		s.notifyChannel = channel
+	}
+}
+
+// WithLogger sets the logger, it logs events that are skipped as they are not registered in the serializer
+func WithLogger(logger eventsourcing.Logger) Option {
+	return func(s *SQL) {
+		s.logger = logger
+	}
 }
 
 // Open connection to database
-func Open(db *sql.DB, serializer eventsourcing.Serializer) *SQL {
-	return &SQL{
-		db:         db,
-		serializer: serializer,
+func Open(db *sql.DB, serializer eventsourcing.Serializer, options ...Option) *SQL {
+	s := &SQL{
+		db:            db,
+		serializer:    serializer,
+		rowsPerInsert: maxParameters / insertColumns,
+		logger:        eventsourcing.NoopLogger{},
+	}
+	for _, option := range options {
+		option(s)
 	}
+	return s
 }
 
-// Close the connection
+// Close the connection, and the replica connection if set
 func (s *SQL) Close() {
 	s.db.Close()
+	if s.replica != nil {
+		s.replica.Close()
+	}
+}
+
+// reader returns the database to read from
+func (s *SQL) reader() *sql.DB {
+	if s.replica != nil {
+		return s.replica
+	}
+	return s.db
+}
+
+// Stats returns the connection pool statistics of the underlying database
+func (s *SQL) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// SetMaxOpenConns sets the maximum number of open connections to the database
+func (s *SQL) SetMaxOpenConns(n int) {
+	s.db.SetMaxOpenConns(n)
+}
+
+// SetMaxIdleConns sets the maximum number of connections in the idle connection pool
+func (s *SQL) SetMaxIdleConns(n int) {
+	s.db.SetMaxIdleConns(n)
+}
+
+// SetConnMaxLifetime sets the maximum amount of time a connection may be reused
+func (s *SQL) SetConnMaxLifetime(d time.Duration) {
+	s.db.SetConnMaxLifetime(d)
+}
+
+// SaveResult holds the position of a saved event. The event id is time ordered and is the global position
+// of the event.
+type SaveResult struct {
+	EventID uuid.UUID
+	Version eventsourcing.Version
 }
 
 // Save persists events to the database
 func (s *SQL) Save(events []eventsourcing.Event) error {
+	return s.SaveContext(context.Background(), events)
+}
+
+// SaveContext persists events to the database, the context is used for all statements in the transaction and a
+// cancelled context or passed deadline rolls back the events inserted so far
+func (s *SQL) SaveContext(ctx context.Context, events []eventsourcing.Event) error {
+	_, err := s.SaveDetailedContext(ctx, events)
+	return err
+}
+
+// SaveDetailed persists events to the database and returns the position of each saved event in the
+// same order as the events. The events are not modified.
+func (s *SQL) SaveDetailed(events []eventsourcing.Event) ([]SaveResult, error) {
+	return s.SaveDetailedContext(context.Background(), events)
+}
+
+// SaveDetailedContext is SaveDetailed with the context used for the statements in the transaction
+func (s *SQL) SaveDetailedContext(ctx context.Context, events []eventsourcing.Event) ([]SaveResult, error) {
 	// If no event return no error
 	if len(events) == 0 {
-		return nil
+		return nil, nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not start a write transaction, %v", err)
 	}
-	aggregateID := events[0].AggregateID
-	aggregateType := events[0].AggregateType
+	defer tx.Rollback()
 
-	tx, err := s.db.BeginTx(context.Background(), nil)
+	inserts, err := s.prepareInserts(ctx, tx, events)
+	if err != nil {
+		return nil, err
+	}
+	err = s.execInserts(ctx, tx, inserts)
+	if err != nil {
+		return nil, err
+	}
+	err = s.notify(ctx, tx, events[len(events)-1])
+	if err != nil {
+		return nil, err
+	}
+	// a context canceled after the last statement still rolls back the save
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SaveResult, len(events))
+	for i, event := range events {
+		results[i] = SaveResult{EventID: event.EventID, Version: event.Version}
+	}
+	return results, nil
+}
+
+// SaveMany persists the events of several aggregates in one transaction, either all or none of the events are
+// saved. Each slice holds the events of one aggregate. All events are validated and marshalled before the first
+// insert, an error in any of them rolls back the transaction.
+func (s *SQL) SaveMany(ctx context.Context, batches [][]eventsourcing.Event) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("could not start a write transaction, %v", err)
 	}
 	defer tx.Rollback()
 
-	var currentVersion eventsourcing.Version
-	var version int
-	selectStm := `SELECT version FROM events WHERE aggregate_id=? AND type=? ORDER BY version DESC LIMIT 1`
-	err = tx.QueryRow(selectStm, aggregateID, aggregateType).Scan(&version)
-	if err != nil && err != sql.ErrNoRows {
+	var inserts []insert
+	var last *eventsourcing.Event
+	// the versions of the aggregates in earlier batches, they are not stored when the later batches are validated
+	pending := map[string]eventsourcing.Version{}
+	for _, events := range batches {
+		if len(events) == 0 {
+			continue
+		}
+		key := events[0].AggregateType + "_" + events[0].AggregateID.String()
+		var batch []insert
+		if version, ok := pending[key]; ok {
+			batch, err = s.prepareInsertsFrom(version, events)
+		} else {
+			batch, err = s.prepareInserts(ctx, tx, events)
+		}
+		if err != nil {
+			return err
+		}
+		inserts = append(inserts, batch...)
+		last = &events[len(events)-1]
+		pending[key] = last.Version
+	}
+	if last == nil {
+		return nil
+	}
+	err = s.execInserts(ctx, tx, inserts)
+	if err != nil {
 		return err
-	} else if err == sql.ErrNoRows {
-		// if no events are saved before set the current version to zero
-		currentVersion = eventsourcing.Version(0)
-	} else {
-		// set the current version to the last event stored
-		currentVersion = eventsourcing.Version(version)
 	}
-
-	//Validate events
-	err = eventstore.ValidateEvents(aggregateID, currentVersion, events)
+	err = s.notify(ctx, tx, *last)
 	if err != nil {
 		return err
 	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return tx.Commit()
+}
+
+// insert is an insert statement with its arguments
+type insert struct {
+	stm  string
+	args []interface{}
+}
+
+// prepareInserts validates the events of an aggregate against its stored version and builds the statements that
+// insert them, the events are marshalled before anything is written
+func (s *SQL) prepareInserts(ctx context.Context, q queryRower, events []eventsourcing.Event) ([]insert, error) {
+	currentVersion, err := s.latestVersion(ctx, q, events[0].AggregateID, events[0].AggregateType)
+	if err != nil {
+		return nil, err
+	}
+	return s.prepareInsertsFrom(currentVersion, events)
+}
 
-	insert := `INSERT INTO events (event_id, aggregate_id, version, reason, type, timestamp, data, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+// prepareInsertsFrom validates the events against the current version of the aggregate and builds the statements
+// that insert them
+func (s *SQL) prepareInsertsFrom(currentVersion eventsourcing.Version, events []eventsourcing.Event) ([]insert, error) {
+	//Validate events
+	err := eventstore.ValidateEvents(events[0].AggregateID, currentVersion, events)
+	if err != nil {
+		return nil, err
+	}
 	for _, event := range events {
-		var e, m []byte
+		if tenant, ok := event.Metadata[TenantMetadataKey]; ok && tenant != s.tenant {
+			return nil, ErrTenantMismatch
+		}
+	}
+
+	// insert the events in as few statements as the parameter limit allows
+	var inserts []insert
+	for start := 0; start < len(events); start += s.rowsPerInsert {
+		end := start + s.rowsPerInsert
+		if end > len(events) {
+			end = len(events)
+		}
+		stm, args, err := s.insertStatement(events[start:end])
+		if err != nil {
+			return nil, err
+		}
+		inserts = append(inserts, insert{stm: stm, args: args})
+	}
+	return inserts, nil
+}
 
-		e, err := s.serializer.Marshal(event.Data)
+// execInserts runs the insert statements in the transaction, a context canceled while the statements were built
+// returns before the first one
+func (s *SQL) execInserts(ctx context.Context, tx *sql.Tx, inserts []insert) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for _, i := range inserts {
+		_, err := tx.ExecContext(ctx, i.stm, i.args...)
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// notify sends the event id of the last saved event on the notify channel if it's set, Postgres delivers the
+// notification when the transaction commits
+func (s *SQL) notify(ctx context.Context, tx *sql.Tx, last eventsourcing.Event) error {
+	if s.notifyChannel == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, s.notifyChannel, last.EventID.String())
+	return err
+}
+
+// AggregateRegistered tells if the aggregate type has events registered in the serializer, the repository use it
+// to return ErrUnregisteredAggregate instead of not found for a type the store can't read
+func (s *SQL) AggregateRegistered(aggregateType string) bool {
+	return s.serializer.AggregateRegistered(aggregateType)
+}
+
+// LatestVersion returns the version of the last stored event of the aggregate, 0 if the aggregate has no events
+func (s *SQL) LatestVersion(ctx context.Context, id uuid.UUID, aggregateType string) (eventsourcing.Version, error) {
+	return s.latestVersion(ctx, s.db, id, aggregateType)
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s *SQL) latestVersion(ctx context.Context, q queryRower, id uuid.UUID, aggregateType string) (eventsourcing.Version, error) {
+	var latest eventsourcing.Version
+	for _, table := range s.tables() {
+		var version int
+		err := q.QueryRowContext(ctx, `SELECT version FROM `+table+` WHERE tenant_id=? AND aggregate_id=? AND type=? ORDER BY version DESC LIMIT 1`, s.tenant, id, aggregateType).Scan(&version)
+		if err == sql.ErrNoRows {
+			// if no events are saved before the current version is zero
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		if eventsourcing.Version(version) > latest {
+			latest = eventsourcing.Version(version)
+		}
+	}
+	return latest, nil
+}
+
+// tables returns the tables that hold the events of the store, the archive is first as it holds the older events
+func (s *SQL) tables() []string {
+	if s.archive {
+		return []string{"events_archive", "events"}
+	}
+	return []string{"events"}
+}
+
+// selectEvents selects the columns of an event
+const selectEvents = `SELECT event_id, aggregate_id, version, reason, type, timestamp, data, metadata, format, signature FROM `
+
+// tableQueries returns the select of the columns of an event from each table of the store with the where clause
+func (s *SQL) tableQueries(where string, args ...interface{}) []query {
+	var queries []query
+	for _, table := range s.tables() {
+		queries = append(queries, query{stm: selectEvents + table + " " + where, args: args})
+	}
+	return queries
+}
+
+// insertStatement builds a multi-row insert statement for the events
+func (s *SQL) insertStatement(events []eventsourcing.Event) (string, []interface{}, error) {
+	var b strings.Builder
+	b.WriteString(`INSERT INTO events (event_id, tenant_id, aggregate_id, version, reason, type, timestamp, data, metadata, format, signature, occurred_at) VALUES `)
+	args := make([]interface{}, 0, len(events)*insertColumns)
+	for i, event := range events {
+		var m []byte
+
+		e, tag, err := s.serializer.MarshalFormat(event.AggregateType, event.Data)
+		if err != nil {
+			return "", nil, fmt.Errorf("marshal event %s %s v%d: %w", event.Reason(), event.AggregateID, event.Version, err)
+		}
 		if event.Metadata != nil {
 			m, err = s.serializer.Marshal(event.Metadata)
 			if err != nil {
-				return err
+				return "", nil, fmt.Errorf("marshal metadata of event %s %s v%d: %w", event.Reason(), event.AggregateID, event.Version, err)
 			}
 		}
-		_, err = tx.Exec(insert, event.EventID, event.AggregateID, event.Version, event.Reason(), event.AggregateType, event.Timestamp.Format(time.RFC3339), string(e), string(m))
+		e, m, err = encrypt(s.encryption, event, e, m)
 		if err != nil {
-			return err
+			return "", nil, err
+		}
+		timestamp := event.Timestamp.Format(time.RFC3339Nano)
+		signature, err := sign(s.signer, signedPayload(event.EventID, s.tenant, event.AggregateID, event.Version, event.Reason(), event.AggregateType, timestamp, string(e), string(m), tag))
+		if err != nil {
+			return "", nil, err
 		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for c := 1; c <= insertColumns; c++ {
+			if c > 1 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "$%d", i*insertColumns+c)
+		}
+		b.WriteString(")")
+		args = append(args, event.EventID, s.tenant, event.AggregateID, event.Version, event.Reason(), event.AggregateType, timestamp, string(e), string(m), tag, signature, formatOccurredAt(event.Timestamp))
 	}
-	return tx.Commit()
+	return b.String(), args, nil
 }
 
 // Get the events from database
 func (s *SQL) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
-	selectStm := `SELECT event_id, aggregate_id, version, reason, type, timestamp, data, metadata FROM events WHERE aggregate_id = ? AND type = ? AND version > ? ORDER BY version ASC`
-	rows, err := s.db.QueryContext(ctx, selectStm, id, aggregateType, afterVersion)
+	queries := s.tableQueries(`WHERE tenant_id = ? AND aggregate_id = ? AND type = ? AND version > ? ORDER BY version ASC`, s.tenant, id, aggregateType, afterVersion)
+	return s.iterator(ctx, queries)
+}
+
+// iterator returns an iterator over the events of the queries
+func (s *SQL) iterator(ctx context.Context, queries []query) (eventsourcing.EventIterator, error) {
+	c, err := openCursor(ctx, s.reader(), queries)
 	if err != nil {
 		return nil, err
-	} else if ctx.Err() != nil {
-		return nil, ctx.Err()
 	}
-	i := iterator{rows: rows, serializer: s.serializer}
+	i := iterator{cursor: c, serializer: s.serializer, encryption: s.encryption, signer: s.signer, tenant: s.tenant, logger: s.logger, prefetch: s.prefetch, ctx: ctx}
 	return &i, nil
 }
 
-// GlobalEvents return count events in order globaly from the start posistion
-func (s *SQL) GlobalEvents(start, count uint64) ([]eventsourcing.Event, error) {
-	selectStm := `SELECT event_id, aggregate_id, version, reason, type, timestamp, data, metadata FROM events WHERE event_id >= ? ORDER BY event_id ASC LIMIT ?`
-	rows, err := s.db.Query(selectStm, start, count)
+// GetRange returns the events of the aggregate with a version in the range (fromVersion, toVersion], i.e. after
+// fromVersion up to and including toVersion. A toVersion of zero means no upper bound and if fromVersion is equal
+// to or higher than toVersion the iterator is empty.
+func (s *SQL) GetRange(ctx context.Context, id uuid.UUID, aggregateType string, fromVersion, toVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	if toVersion == 0 {
+		return s.Get(ctx, id, aggregateType, fromVersion)
+	}
+	queries := s.tableQueries(`WHERE tenant_id = ? AND aggregate_id = ? AND type = ? AND version > ? AND version <= ? ORDER BY version ASC`, s.tenant, id, aggregateType, fromVersion, toVersion)
+	return s.iterator(ctx, queries)
+}
+
+// Archive moves events with an event id lower than before from the events table to the events_archive table
+// and returns the number of moved events. Event ids are time ordered which makes before the global position
+// to archive up to. The store has to be opened with WithArchive for the archived events to be included in Get.
+func (s *SQL) Archive(ctx context.Context, before uuid.UUID) (int64, error) {
+	if !s.archive {
+		return 0, ErrArchiveNotEnabled
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not start a write transaction, %v", err)
+	}
+	defer tx.Rollback()
+
+	// the events are read in event id order up to before and copied one by one, not all sql drivers support
+	// INSERT INTO ... SELECT or comparing uuids
+	rows, err := tx.QueryContext(ctx, `SELECT event_id, aggregate_id, version, reason, type, timestamp, data, metadata, format, signature FROM events WHERE tenant_id = ? ORDER BY event_id ASC`, s.tenant)
+	if err != nil {
+		return 0, err
+	}
+	var archived [][]interface{}
+	for rows.Next() {
+		var eventID, aggregateID uuid.UUID
+		var version int
+		var reason, typ, timestamp, data, metadata, format, signature string
+		err = rows.Scan(&eventID, &aggregateID, &version, &reason, &typ, &timestamp, &data, &metadata, &format, &signature)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if bytes.Compare(eventID.Bytes(), before.Bytes()) >= 0 {
+			break
+		}
+		occurredAt, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		archived = append(archived, []interface{}{eventID, s.tenant, aggregateID, version, reason, typ, timestamp, data, metadata, format, signature, formatOccurredAt(occurredAt)})
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	insert := `INSERT INTO events_archive (event_id, tenant_id, aggregate_id, version, reason, type, timestamp, data, metadata, format, signature, occurred_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	for _, args := range archived {
+		_, err = tx.ExecContext(ctx, insert, args...)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM events WHERE tenant_id = ? AND event_id = ?`, s.tenant, args[0])
+		if err != nil {
+			return 0, err
+		}
+	}
+	count := int64(len(archived))
+	return count, tx.Commit()
+}
+
+// Truncate deletes the events of the aggregate with a version up to and including toVersion, archived events are
+// deleted as well when the archive is enabled
+func (s *SQL) Truncate(ctx context.Context, id uuid.UUID, aggregateType string, toVersion eventsourcing.Version) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start a write transaction, %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range s.tables() {
+		err = truncate(ctx, tx, table, s.tenant, id, aggregateType, toVersion)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// truncate deletes the events of the aggregate in the table up to toVersion one by one on the event id, as Archive
+// does, the test sql driver skips rows when one statement deletes several
+func truncate(ctx context.Context, tx *sql.Tx, table, tenant string, id uuid.UUID, aggregateType string, toVersion eventsourcing.Version) error {
+	rows, err := tx.QueryContext(ctx, `SELECT event_id FROM `+table+` WHERE tenant_id = ? AND aggregate_id = ? AND type = ? AND version <= ?`, tenant, id, aggregateType, toVersion)
+	if err != nil {
+		return err
+	}
+	var eventIDs []uuid.UUID
+	for rows.Next() {
+		var eventID uuid.UUID
+		if err = rows.Scan(&eventID); err != nil {
+			rows.Close()
+			return err
+		}
+		eventIDs = append(eventIDs, eventID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, eventID := range eventIDs {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE tenant_id = ? AND event_id = ?`, tenant, eventID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EventCount returns the number of events for the aggregate without fetching them
+func (s *SQL) EventCount(ctx context.Context, id uuid.UUID, aggregateType string) (int, error) {
+	total := 0
+	for _, table := range s.tables() {
+		var count int
+		err := s.reader().QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE tenant_id = ? AND aggregate_id = ? AND type = ?`, s.tenant, id, aggregateType).Scan(&count)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// CountByReason returns the number of events of the aggregate per reason, only the reason column of the events is
+// read and the counting is done per table as GROUP BY and UNION is not supported by all databases
+func (s *SQL) CountByReason(ctx context.Context, id uuid.UUID, aggregateType string) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, table := range s.tables() {
+		err := s.countByReason(ctx, table, id, aggregateType, counts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+func (s *SQL) countByReason(ctx context.Context, table string, id uuid.UUID, aggregateType string, counts map[string]int) error {
+	rows, err := s.reader().QueryContext(ctx, `SELECT reason FROM `+table+` WHERE tenant_id = ? AND aggregate_id = ? AND type = ?`, s.tenant, id, aggregateType)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reason string
+		if err = rows.Scan(&reason); err != nil {
+			return err
+		}
+		counts[reason]++
+	}
+	return rows.Err()
+}
+
+// GlobalCount returns the number of events of the tenant, the events returned from GlobalEvents
+func (s *SQL) GlobalCount(ctx context.Context) (uint64, error) {
+	var count uint64
+	err := s.reader().QueryRowContext(ctx, `SELECT COUNT(*) FROM events WHERE tenant_id = ?`, s.tenant).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// LastGlobalVersion returns the event id of the last event of the tenant in global order, uuid.Nil if there are no
+// events. It's the position to start GlobalEvents from to only get new events.
+func (s *SQL) LastGlobalVersion(ctx context.Context) (uuid.UUID, error) {
+	var eventID uuid.UUID
+	err := s.reader().QueryRowContext(ctx, `SELECT event_id FROM events WHERE tenant_id = ? ORDER BY event_id DESC LIMIT 1`, s.tenant).Scan(&eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	} else if err != nil {
+		return uuid.Nil, err
+	}
+	return eventID, nil
+}
+
+// GlobalEvents return count events of the tenant in order globaly from the start posistion
+//
+// Deprecated: use GlobalEventsContext, GlobalEvents can't be canceled.
+func (s *SQL) GlobalEvents(start uuid.UUID, count uint64) ([]eventsourcing.Event, error) {
+	return s.GlobalEventsContext(context.Background(), start, count)
+}
+
+// GlobalEventsContext return count events of the tenant in order globaly from the start posistion, the query is
+// aborted when the context is canceled
+func (s *SQL) GlobalEventsContext(ctx context.Context, start uuid.UUID, count uint64) ([]eventsourcing.Event, error) {
+	where, args := s.fromStart(`WHERE tenant_id = ?`, start, s.tenant)
+	rows, err := s.reader().QueryContext(ctx, selectEvents+`events `+where+` ORDER BY event_id ASC LIMIT ?`, append(args, count)...)
 	if err != nil {
 		return nil, err
 	}
@@ -112,39 +674,95 @@ func (s *SQL) GlobalEvents(start, count uint64) ([]eventsourcing.Event, error) {
 	return s.eventsFromRows(rows)
 }
 
+// occurredAtFormat is the fixed width UTC format of the occurred_at column. Databases that keep the column as text
+// compare it as a string, the fixed width makes the text order the time order.
+const occurredAtFormat = "2006-01-02T15:04:05.000000000Z"
+
+// formatOccurredAt formats the time for the occurred_at column, the inserts and the queries use it on both sides
+// of the comparison
+func formatOccurredAt(t time.Time) string {
+	return t.UTC().Format(occurredAtFormat)
+}
+
+// GlobalEventsSince returns count events of the tenant that occurred at or after since, in global order. It uses the
+// index on the occurred_at column added by Migrate.
+func (s *SQL) GlobalEventsSince(ctx context.Context, since time.Time, count int) ([]eventsourcing.Event, error) {
+	selectStm := `SELECT event_id, aggregate_id, version, reason, type, timestamp, data, metadata, format, signature FROM events WHERE tenant_id = ? AND occurred_at >= ? ORDER BY event_id ASC LIMIT ?`
+	rows, err := s.reader().QueryContext(ctx, selectStm, s.tenant, formatOccurredAt(since), count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return s.eventsFromRows(rows)
+}
+
+// GlobalEventsByReason returns count events of the tenant with the reason, across all aggregates and in global
+// order from the events with an event id equal to or higher than start. It uses the reason index added by Migrate.
+func (s *SQL) GlobalEventsByReason(ctx context.Context, reason string, start uuid.UUID, count int) ([]eventsourcing.Event, error) {
+	where, args := s.fromStart(`WHERE tenant_id = ? AND reason = ?`, start, s.tenant, reason)
+	rows, err := s.reader().QueryContext(ctx, selectEvents+`events `+where+` ORDER BY event_id ASC LIMIT ?`, append(args, count)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return s.eventsFromRows(rows)
+}
+
+// fromStart adds the condition on the event id being at or after start to the where clause, uuid.Nil is before
+// every event id and adds no condition
+func (s *SQL) fromStart(where string, start uuid.UUID, args ...interface{}) (string, []interface{}) {
+	if start == uuid.Nil {
+		return where, args
+	}
+	return where + ` AND event_id >= ?`, append(args, start)
+}
+
 func (s *SQL) eventsFromRows(rows *sql.Rows) ([]eventsourcing.Event, error) {
 	var events []eventsourcing.Event
 	for rows.Next() {
-		var eventMetadata map[string]interface{}
 		var version eventsourcing.Version
 		var eventId, aggregateId uuid.UUID
 		var reason, typ, timestamp string
-		var data, metadata string
-		if err := rows.Scan(&eventId, &aggregateId, &version, &reason, &typ, &timestamp, &data, &metadata); err != nil {
+		var data, metadata, tag, signature string
+		if err := rows.Scan(&eventId, &aggregateId, &version, &reason, &typ, &timestamp, &data, &metadata, &tag, &signature); err != nil {
+			return nil, err
+		}
+		err := verify(s.signer, signedPayload(eventId, s.tenant, aggregateId, version, reason, typ, timestamp, data, metadata, tag), signature)
+		if err != nil {
 			return nil, err
 		}
 
-		t, err := time.Parse(time.RFC3339, timestamp)
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
 		if err != nil {
 			return nil, err
 		}
 
 		f, ok := s.serializer.Type(typ, reason)
 		if !ok {
+			if err = s.serializer.UnknownEvent(typ, reason); err != nil {
+				return nil, err
+			}
+			s.logger.Warn("skipped unregistered event", "event_id", eventId, "type", typ, "reason", reason)
 			// if the typ/reason is not register jump over the event
 			continue
 		}
 
+		d, m, err := decrypt(s.encryption, typ, aggregateId, data, metadata)
+		if errors.Is(err, eventsourcing.ErrKeyShredded) || errors.Is(err, eventsourcing.ErrKeyNotFound) {
+			// the key of the aggregate is erased, jump over the event to not stop readers of the global order
+			s.logger.Warn("skipped event without encryption key", "event_id", eventId, "type", typ, "reason", reason, "aggregate_id", aggregateId)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
 		eventData := f()
-		err = s.serializer.Unmarshal([]byte(data), &eventData)
+		err = s.serializer.UnmarshalFormat(tag, d, &eventData)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("unmarshal event %s %s v%d: %w", reason, aggregateId, version, err)
 		}
-		if metadata != "" {
-			err = s.serializer.Unmarshal([]byte(metadata), &eventMetadata)
-			if err != nil {
-				return nil, err
-			}
+		eventMetadata, err := unmarshalMetadata(s.serializer, m)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal metadata of event %s %s v%d: %w", reason, aggregateId, version, err)
 		}
 
 		events = append(events, eventsourcing.Event{
@@ -157,5 +775,6 @@ func (s *SQL) eventsFromRows(rows *sql.Rows) ([]eventsourcing.Event, error) {
 			Metadata:      eventMetadata,
 		})
 	}
-	return events, nil
+	// a canceled context ends the rows early
+	return events, rows.Err()
 }
@@ -1,16 +1,23 @@
 package sql_test
 
 import (
+	"bytes"
+	"context"
 	sqldriver "database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore"
 	"github.com/hallgren/eventsourcing/eventstore/sql"
 	"github.com/hallgren/eventsourcing/eventstore/suite"
-	_ "github.com/proullon/ramsql/driver"
 )
 
 var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -19,7 +26,7 @@ func TestSuite(t *testing.T) {
 	f := func(ser eventsourcing.Serializer) (eventsourcing.EventStore, func(), error) {
 		// use random int to get a new db on each test run
 		r := seededRand.Intn(999999999999)
-		db, err := sqldriver.Open("ramsql", fmt.Sprintf("%d", r))
+		db, err := sqldriver.Open("ramsql-drain", fmt.Sprintf("%d", r))
 		if err != nil {
 			return nil, nil, fmt.Errorf("could not open ramsql database %v", err)
 		}
@@ -39,3 +46,1584 @@ func TestSuite(t *testing.T) {
 	}
 	suite.Test(t, f)
 }
+
+// newDB returns a new in memory database
+func newDB(t *testing.T) *sqldriver.DB {
+	r := seededRand.Intn(999999999999)
+	db, err := sqldriver.Open("ramsql-drain", fmt.Sprintf("%d", r))
+	if err != nil {
+		t.Fatalf("could not open ramsql database %v", err)
+	}
+	return db
+}
+
+// newSerializer returns a json serializer with the suite events registered
+func newSerializer(t *testing.T) *eventsourcing.Serializer {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	err := ser.Register(&suite.FrequentFlierAccount{}, ser.Events(&suite.FrequentFlierAccountCreated{}, &suite.FlightTaken{}, &suite.StatusMatched{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ser
+}
+
+// newStore returns a migrated event store on a new in memory database
+func newStore(t *testing.T, options ...sql.Option) *sql.SQL {
+	es := sql.Open(newDB(t), *newSerializer(t), options...)
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatalf("could not migrate database %v", err)
+	}
+	return es
+}
+
+// flights returns count FlightTaken events for the aggregate starting at version from+1
+func flights(id uuid.UUID, from eventsourcing.Version, count int) []eventsourcing.Event {
+	var events []eventsourcing.Event
+	for i := 1; i <= count; i++ {
+		events = append(events, eventsourcing.Event{
+			EventID:       eventsourcing.NewUuid(),
+			AggregateID:   id,
+			Version:       from + eventsourcing.Version(i),
+			AggregateType: "FrequentFlierAccount",
+			Timestamp:     time.Now().UTC(),
+			Data:          &suite.FlightTaken{MilesAdded: i, TierPointsAdded: i},
+		})
+	}
+	return events
+}
+
+// getAll returns all events for the aggregate after version
+func getAll(t *testing.T, es *sql.SQL, id uuid.UUID, after eventsourcing.Version) []eventsourcing.Event {
+	iterator, err := es.Get(context.Background(), id, "FrequentFlierAccount", after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	var events []eventsourcing.Event
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, eventsourcing.ErrNoMoreEvents) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestArchive(t *testing.T) {
+	es := newStore(t, sql.WithArchive())
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	err := es.Save(flights(id, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := es.Archive(context.Background(), eventsourcing.NewUuid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 archived events got %d", count)
+	}
+	// archived events are still part of the aggregate
+	err = es.Save(flights(id, 3, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = es.Save(flights(id, 0, 1))
+	if !errors.Is(err, eventstore.ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency when saving on archived version got %v", err)
+	}
+	events := getAll(t, es, id, 0)
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events got %d", len(events))
+	}
+	for i, e := range events {
+		if e.Version != eventsourcing.Version(i+1) {
+			t.Fatalf("expected version %d got %d", i+1, e.Version)
+		}
+	}
+	eventCount, err := es.EventCount(context.Background(), id, "FrequentFlierAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eventCount != 4 {
+		t.Fatalf("expected 4 counted events got %d", eventCount)
+	}
+	version, err := es.LatestVersion(context.Background(), id, "FrequentFlierAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 4 {
+		t.Fatalf("expected latest version 4 got %d", version)
+	}
+	counts, err := es.CountByReason(context.Background(), id, "FrequentFlierAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["FlightTaken"] != 4 {
+		t.Fatalf("expected 4 counted FlightTaken events got %v", counts)
+	}
+}
+
+func TestArchiveNotEnabled(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	_, err := es.Archive(context.Background(), eventsourcing.NewUuid())
+	if !errors.Is(err, sql.ErrArchiveNotEnabled) {
+		t.Fatalf("expected ErrArchiveNotEnabled got %v", err)
+	}
+}
+
+func TestUnknownEventError(t *testing.T) {
+	db := newDB(t)
+	es := sql.Open(db, *newSerializer(t))
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read the events with a serializer that has no registered events
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	ser.OnUnknownEvent(eventsourcing.UnknownEventError)
+	other := sql.Open(db, *ser)
+	iterator, err := other.Get(context.Background(), id, "FrequentFlierAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	_, err = iterator.Next()
+	if !errors.Is(err, eventsourcing.ErrUnknownEventType) {
+		t.Fatalf("expected ErrUnknownEventType got %v", err)
+	}
+}
+
+func TestAllEventsUnregistered(t *testing.T) {
+	db := newDB(t)
+	es := sql.Open(db, *newSerializer(t))
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read the events with a serializer that skips them as unregistered
+	other := sql.Open(db, *eventsourcing.NewSerializer(json.Marshal, json.Unmarshal))
+	iterator, err := other.Get(context.Background(), id, "FrequentFlierAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	_, err = iterator.Next()
+	if !errors.Is(err, eventsourcing.ErrAllEventsUnregistered) {
+		t.Fatalf("expected ErrAllEventsUnregistered got %v", err)
+	}
+	if !errors.Is(err, eventsourcing.ErrNoMoreEvents) {
+		t.Fatalf("expected the error to end the iteration as ErrNoMoreEvents got %v", err)
+	}
+}
+
+func TestEmptyMetadata(t *testing.T) {
+	// a serializer that leaves the map nil for an empty object
+	ser := eventsourcing.NewSerializer(json.Marshal, func(b []byte, v interface{}) error {
+		if string(b) == "{}" {
+			return nil
+		}
+		return json.Unmarshal(b, v)
+	})
+	ser.Register(&suite.FrequentFlierAccount{}, ser.Events(&suite.FlightTaken{}))
+	es := sql.Open(newDB(t), *ser)
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	events := flights(id, 0, 3)
+	events[1].Metadata = map[string]interface{}{}
+	events[2].Metadata = map[string]interface{}{"test": "hello"}
+	err = es.Save(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := getAll(t, es, id, 0)
+	if len(fetched) != 3 {
+		t.Fatalf("expected 3 events got %d", len(fetched))
+	}
+	if fetched[0].Metadata != nil {
+		t.Fatalf("expected nil metadata got %v", fetched[0].Metadata)
+	}
+	if fetched[1].Metadata == nil || len(fetched[1].Metadata) != 0 {
+		t.Fatalf("expected empty non-nil metadata got %#v", fetched[1].Metadata)
+	}
+	if fetched[2].Metadata["test"] != "hello" {
+		t.Fatalf("expected the metadata test=hello got %v", fetched[2].Metadata)
+	}
+}
+
+func TestStats(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	es.SetMaxOpenConns(5)
+	es.SetMaxIdleConns(2)
+	es.SetConnMaxLifetime(time.Minute)
+	if es.Stats().MaxOpenConnections != 5 {
+		t.Fatalf("expected max open connections 5 got %d", es.Stats().MaxOpenConnections)
+	}
+}
+
+func TestEventCount(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	err := es.Save(flights(id, 0, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := es.EventCount(context.Background(), id, "FrequentFlierAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 6 {
+		t.Fatalf("expected 6 events got %d", count)
+	}
+	count, err = es.EventCount(context.Background(), eventsourcing.NewUuid(), "FrequentFlierAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected 0 events got %d", count)
+	}
+}
+
+func TestGlobalCount(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	count, err := es.GlobalCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := es.LastGlobalVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || last != uuid.Nil {
+		t.Fatalf("expected an empty store got count %d and last %s", count, last)
+	}
+
+	events := append(flights(eventsourcing.NewUuid(), 0, 3), flights(eventsourcing.NewUuid(), 0, 4)...)
+	err = es.Save(events[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = es.Save(events[3:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err = es.GlobalCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Fatalf("expected 7 events got %d", count)
+	}
+	last, err = es.LastGlobalVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != events[6].EventID {
+		t.Fatalf("expected last event %s got %s", events[6].EventID, last)
+	}
+}
+
+func TestGlobalEventsContextCanceled(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+	err := es.Save(flights(eventsourcing.NewUuid(), 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := es.GlobalEventsContext(context.Background(), uuid.Nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events got %d", len(events))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := es.GlobalEventsContext(ctx, uuid.Nil, 10)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the canceled query to return promptly")
+	}
+}
+
+func TestGlobalEventsSince(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	now := time.Now().UTC()
+	id := eventsourcing.NewUuid()
+	events := flights(id, 0, 4)
+	events[0].Timestamp = now.Add(-3 * time.Hour)
+	events[1].Timestamp = now.Add(-2 * time.Hour)
+	events[2].Timestamp = now.Add(-30 * time.Minute)
+	events[3].Timestamp = now.Add(-time.Minute)
+	err := es.Save(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = es.Save(flights(eventsourcing.NewUuid(), 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	since, err := es.GlobalEventsSince(context.Background(), now.Add(-time.Hour), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 2 || since[0].EventID != events[2].EventID || since[1].EventID != events[3].EventID {
+		t.Fatalf("expected the two events of the last hour got %v", since)
+	}
+	since, err = es.GlobalEventsSince(context.Background(), now.Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 3 {
+		t.Fatalf("expected 3 events in the last hour got %d", len(since))
+	}
+}
+
+func TestGlobalEventsSinceSubSecond(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	since := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	id := eventsourcing.NewUuid()
+	events := flights(id, 0, 4)
+	events[0].Timestamp = since.Add(-500 * time.Millisecond)
+	events[1].Timestamp = since
+	events[2].Timestamp = since.Add(500 * time.Millisecond)
+	events[3].Timestamp = since.Add(30 * time.Minute)
+	err := es.Save(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := es.GlobalEventsSince(context.Background(), since, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 || found[0].EventID != events[1].EventID || found[1].EventID != events[2].EventID {
+		t.Fatalf("expected the events at and after since got %v", found)
+	}
+	found, err = es.GlobalEventsSince(context.Background(), since.Add(250*time.Millisecond), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].EventID != events[2].EventID {
+		t.Fatalf("expected the events after the fraction of a second got %v", found)
+	}
+}
+
+func TestGlobalEventsByReason(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	id1 := eventsourcing.NewUuid()
+	id2 := eventsourcing.NewUuid()
+	events := []eventsourcing.Event{
+		{EventID: eventsourcing.NewUuid(), AggregateID: id1, Version: 1, AggregateType: "FrequentFlierAccount", Timestamp: time.Now().UTC(), Data: &suite.StatusMatched{NewStatus: suite.StatusSilver}},
+	}
+	events = append(events, flights(id1, 1, 2)...)
+	err := es.Save(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := append(flights(id2, 0, 1), eventsourcing.Event{EventID: eventsourcing.NewUuid(), AggregateID: id2, Version: 2, AggregateType: "FrequentFlierAccount", Timestamp: time.Now().UTC(), Data: &suite.StatusMatched{NewStatus: suite.StatusGold}})
+	err = es.Save(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matched, err := es.GlobalEventsByReason(context.Background(), "StatusMatched", uuid.Nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) != 2 || matched[0].EventID != events[0].EventID || matched[1].EventID != other[1].EventID {
+		t.Fatalf("expected the two StatusMatched events in global order got %v", matched)
+	}
+	// the test sql driver can't compare uuids, the start condition is the one of GlobalEvents and only uuid.Nil
+	// is used here
+	matched, err = es.GlobalEventsByReason(context.Background(), "StatusMatched", uuid.Nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) != 1 || matched[0].EventID != events[0].EventID {
+		t.Fatalf("expected the first StatusMatched event got %v", matched)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	db := newDB(t)
+	defer db.Close()
+	acme := sql.Open(db, *newSerializer(t), sql.WithTenant("acme"))
+	err := acme.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex := sql.Open(db, *newSerializer(t), sql.WithTenant("globex"))
+
+	// the same aggregate id in both tenants
+	id := eventsourcing.NewUuid()
+	err = acme.Save(flights(id, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = globex.Save(flights(id, 0, 1))
+	if err != nil {
+		t.Fatalf("the version of the aggregate in another tenant should not conflict, %v", err)
+	}
+
+	if events := getAll(t, acme, id, 0); len(events) != 3 {
+		t.Fatalf("expected 3 events in tenant acme got %d", len(events))
+	}
+	if events := getAll(t, globex, id, 0); len(events) != 1 {
+		t.Fatalf("expected 1 event in tenant globex got %d", len(events))
+	}
+	count, err := globex.EventCount(context.Background(), id, "FrequentFlierAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 event in tenant globex got %d", count)
+	}
+}
+
+func TestTenantMismatch(t *testing.T) {
+	es := newStore(t, sql.WithTenant("acme"))
+	defer es.Close()
+
+	events := flights(eventsourcing.NewUuid(), 0, 1)
+	events[0].Metadata = map[string]interface{}{sql.TenantMetadataKey: "globex"}
+	err := es.Save(events)
+	if !errors.Is(err, sql.ErrTenantMismatch) {
+		t.Fatalf("expected ErrTenantMismatch got %v", err)
+	}
+}
+
+func TestSaveInChunks(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+	es.SetRowsPerInsert(4)
+
+	// nine events as the test driver sorts the version column as text, the last chunk holds one event
+	id := eventsourcing.NewUuid()
+	err := es.Save(flights(id, 0, 9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := getAll(t, es, id, 0)
+	if len(events) != 9 {
+		t.Fatalf("expected 9 events got %d", len(events))
+	}
+	for i, e := range events {
+		if e.Version != eventsourcing.Version(i+1) {
+			t.Fatalf("expected version %d got %d", i+1, e.Version)
+		}
+	}
+}
+
+func TestSaveContextCanceled(t *testing.T) {
+	ser := newSerializer(t)
+	es := sql.Open(newDB(t), *ser)
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	es.SetRowsPerInsert(2)
+
+	// cancel the context while the second insert is built, the events are marshalled before the first insert runs
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	marshalled := 0
+	ser.RegisterFormat("FrequentFlierAccount", "counting", func(v interface{}) ([]byte, error) {
+		marshalled++
+		if marshalled == 3 {
+			cancel()
+		}
+		return json.Marshal(v)
+	}, json.Unmarshal)
+
+	id := eventsourcing.NewUuid()
+	err = es.SaveContext(ctx, flights(id, 0, 4))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+	version, err := es.LatestVersion(context.Background(), id, "FrequentFlierAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("expected the save to be rolled back got version %d", version)
+	}
+}
+
+func TestSaveMany(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	id1 := eventsourcing.NewUuid()
+	id2 := eventsourcing.NewUuid()
+	err := es.SaveMany(context.Background(), [][]eventsourcing.Event{flights(id1, 0, 2), flights(id2, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getAll(t, es, id1, 0)) != 2 || len(getAll(t, es, id2, 0)) != 1 {
+		t.Fatal("expected the events of both aggregates to be saved")
+	}
+
+	// a conflict on the second aggregate rolls back the first
+	id3 := eventsourcing.NewUuid()
+	err = es.SaveMany(context.Background(), [][]eventsourcing.Event{flights(id3, 0, 1), flights(id2, 0, 1)})
+	if !errors.Is(err, eventsourcing.ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency got %v", err)
+	}
+	version, err := es.LatestVersion(context.Background(), id3, "FrequentFlierAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("expected the save to be rolled back got version %d", version)
+	}
+
+	// the second batch of an aggregate continues from the first
+	err = es.SaveMany(context.Background(), [][]eventsourcing.Event{flights(id3, 0, 1), flights(id3, 1, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getAll(t, es, id3, 0)) != 3 {
+		t.Fatal("expected both batches of the aggregate to be saved")
+	}
+}
+
+func benchmarkSave(b *testing.B, rowsPerInsert int) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	ser.Register(&suite.FrequentFlierAccount{}, ser.Events(&suite.FlightTaken{}))
+	db, err := sqldriver.Open("ramsql-drain", fmt.Sprintf("%d", seededRand.Intn(999999999999)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	es := sql.Open(db, *ser)
+	defer es.Close()
+	if err = es.MigrateTest(); err != nil {
+		b.Fatal(err)
+	}
+	es.SetRowsPerInsert(rowsPerInsert)
+
+	const batch = 1000
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		events := flights(eventsourcing.NewUuid(), 0, batch)
+		b.StartTimer()
+		if err = es.Save(events); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// the number of insert statements sent to the database per save
+	b.ReportMetric(float64((batch+rowsPerInsert-1)/rowsPerInsert), "inserts/op")
+}
+
+func BenchmarkSavePerRow(b *testing.B) {
+	benchmarkSave(b, 1)
+}
+
+func BenchmarkSaveMultiRow(b *testing.B) {
+	benchmarkSave(b, 65535/9)
+}
+
+func TestTimestampPrecision(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	events := flights(id, 0, 1)
+	events[0].Timestamp = time.Date(2021, 3, 4, 5, 6, 7, 123456789, time.UTC)
+	err := es.Save(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := getAll(t, es, id, 0)
+	if !fetched[0].Timestamp.Equal(events[0].Timestamp) {
+		t.Fatalf("expected timestamp %v got %v", events[0].Timestamp, fetched[0].Timestamp)
+	}
+}
+
+func TestSaveDetailed(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	events := flights(id, 0, 3)
+	results, err := es.SaveDetailed(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(events) {
+		t.Fatalf("expected %d results got %d", len(events), len(results))
+	}
+	for i, r := range results {
+		if r.EventID != events[i].EventID || r.Version != events[i].Version {
+			t.Fatalf("result %d does not match the saved event, %v", i, r)
+		}
+	}
+}
+
+func TestSaveDoesNotMutateEvents(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	events := flights(eventsourcing.NewUuid(), 0, 2)
+	before := make([]eventsourcing.Event, len(events))
+	copy(before, events)
+	_, err := es.SaveDetailed(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range events {
+		if events[i].EventID != before[i].EventID || events[i].Version != before[i].Version || !events[i].Timestamp.Equal(before[i].Timestamp) || events[i].Metadata != nil {
+			t.Fatalf("event %d was modified by save", i)
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	db := newDB(t)
+	ser := newSerializer(t)
+	id := eventsourcing.NewUuid()
+	k := suite.Keys{id: bytes.Repeat([]byte{7}, 32)}
+	es := sql.Open(db, *ser, sql.WithEncryption(eventsourcing.NewEncryptingSerializer(ser, k, true)))
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := flights(id, 0, 2)
+	events[0].Metadata = map[string]interface{}{"user": "kalle"}
+	err = es.Save(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := getAll(t, es, id, 0)
+	if len(fetched) != 2 {
+		t.Fatalf("expected 2 events got %d", len(fetched))
+	}
+	if fetched[1].Data.(*suite.FlightTaken).MilesAdded != 2 {
+		t.Fatalf("expected the decrypted event data got %v", fetched[1].Data)
+	}
+	if fetched[0].Metadata["user"] != "kalle" {
+		t.Fatalf("expected the decrypted metadata got %v", fetched[0].Metadata)
+	}
+
+	// the stored data is not readable without the key
+	var data, metadata string
+	err = db.QueryRow(`SELECT data, metadata FROM events WHERE version = 1`).Scan(&data, &metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains([]byte(data), []byte("MilesAdded")) || bytes.Contains([]byte(metadata), []byte("kalle")) {
+		t.Fatal("expected the stored event to be encrypted")
+	}
+
+	// losing the key makes the events unreadable
+	delete(k, id)
+	iterator, err := es.Get(context.Background(), id, "FrequentFlierAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	_, err = iterator.Next()
+	if !errors.Is(err, eventsourcing.ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound got %v", err)
+	}
+}
+
+func TestGetShreddedAggregate(t *testing.T) {
+	ser := newSerializer(t)
+	keyStore := eventsourcing.NewMemoryKeyStore()
+	es := sql.Open(newDB(t), *ser, sql.WithEncryption(eventsourcing.NewEncryptingSerializer(ser, keyStore, true)))
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = keyStore.DeleteKey(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := eventsourcing.NewRepository(es, nil)
+	err = repo.Get(id, &suite.FrequentFlierAccount{})
+	if !errors.Is(err, eventsourcing.ErrKeyShredded) {
+		t.Fatalf("expected ErrKeyShredded got %v", err)
+	}
+}
+
+func TestGlobalEventsShreddedAggregate(t *testing.T) {
+	ser := newSerializer(t)
+	keyStore := eventsourcing.NewMemoryKeyStore()
+	es := sql.Open(newDB(t), *ser, sql.WithEncryption(eventsourcing.NewEncryptingSerializer(ser, keyStore, true)))
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shredded := eventsourcing.NewUuid()
+	err = es.Save(flights(shredded, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyStore.DeleteKey(shredded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the events of the shredded aggregate are skipped instead of failing the page
+	events, err := es.GlobalEventsContext(context.Background(), uuid.Nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].AggregateID != id {
+		t.Fatalf("expected the event of the readable aggregate got %v", events)
+	}
+}
+
+func TestMixedFormatStream(t *testing.T) {
+	ser := newSerializer(t)
+	es := sql.Open(newDB(t), *ser)
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// migrate the aggregate type to xml, the first events are still stored as json
+	ser.RegisterFormat("FrequentFlierAccount", "xml", xml.Marshal, xml.Unmarshal)
+	err = es.Save(flights(id, 2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := getAll(t, es, id, 0)
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events got %d", len(events))
+	}
+	for i, e := range events {
+		miles := e.Data.(*suite.FlightTaken).MilesAdded
+		// flights sets the miles from 1 in each batch
+		if miles != i%2+1 {
+			t.Fatalf("expected %d miles on event %d got %d", i%2+1, i, miles)
+		}
+	}
+}
+
+var errBadData = errors.New("bad data")
+
+func TestMarshalErrorContext(t *testing.T) {
+	db := newDB(t)
+	ser := newSerializer(t)
+	es := sql.Open(db, *ser)
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := eventsourcing.NewSerializer(
+		func(v interface{}) ([]byte, error) { return nil, errBadData },
+		func(data []byte, v interface{}) error { return errBadData },
+	)
+	err = failing.Register(&suite.FrequentFlierAccount{}, failing.Events(&suite.FrequentFlierAccountCreated{}, &suite.FlightTaken{}, &suite.StatusMatched{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := sql.Open(db, *failing)
+
+	err = broken.Save(flights(id, 2, 1))
+	if !errors.Is(err, errBadData) || !strings.Contains(err.Error(), "FlightTaken") || !strings.Contains(err.Error(), id.String()) {
+		t.Fatalf("expected wrapped errBadData with the reason and aggregate id from Save got %v", err)
+	}
+
+	iterator, err := broken.Get(context.Background(), id, "FrequentFlierAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	_, err = iterator.Next()
+	if !errors.Is(err, errBadData) || !strings.Contains(err.Error(), "FlightTaken") || !strings.Contains(err.Error(), "v1") {
+		t.Fatalf("expected wrapped errBadData with the reason and version from Get got %v", err)
+	}
+
+	// the working store reads the events, the error below is not from an empty page
+	events, err := es.GlobalEvents(uuid.Nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 global events got %d", len(events))
+	}
+	_, err = broken.GlobalEvents(uuid.Nil, 10)
+	if !errors.Is(err, errBadData) || !strings.Contains(err.Error(), "FlightTaken") {
+		t.Fatalf("expected wrapped errBadData with the reason from GlobalEvents got %v", err)
+	}
+}
+
+// getRange returns the events of the aggregate in the version range
+func getRange(t *testing.T, es *sql.SQL, id uuid.UUID, from, to eventsourcing.Version) []eventsourcing.Event {
+	iterator, err := es.GetRange(context.Background(), id, "FrequentFlierAccount", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	var events []eventsourcing.Event
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, eventsourcing.ErrNoMoreEvents) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestGetRange(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	err := es.Save(flights(id, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := getRange(t, es, id, 1, 3)
+	if len(events) != 2 || events[0].Version != 2 || events[1].Version != 3 {
+		t.Fatalf("expected version 2 and 3 got %v", events)
+	}
+	// zero to version is unbounded
+	if events = getRange(t, es, id, 3, 0); len(events) != 2 {
+		t.Fatalf("expected 2 events got %d", len(events))
+	}
+	// empty range
+	if events = getRange(t, es, id, 3, 3); len(events) != 0 {
+		t.Fatalf("expected no events got %d", len(events))
+	}
+	if events = getRange(t, es, id, 4, 2); len(events) != 0 {
+		t.Fatalf("expected no events got %d", len(events))
+	}
+}
+
+// recordingLogger records the warnings
+type recordingLogger struct {
+	eventsourcing.NoopLogger
+	warnings []string
+}
+
+func (r *recordingLogger) Warn(msg string, kv ...interface{}) {
+	r.warnings = append(r.warnings, msg)
+}
+
+func TestLogSkippedEvents(t *testing.T) {
+	db := newDB(t)
+	es := sql.Open(db, *newSerializer(t))
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read the events with a serializer that has no registered events
+	logger := &recordingLogger{}
+	other := sql.Open(db, *eventsourcing.NewSerializer(json.Marshal, json.Unmarshal), sql.WithLogger(logger))
+	iterator, err := other.Get(context.Background(), id, "FrequentFlierAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	_, err = iterator.Next()
+	if !errors.Is(err, eventsourcing.ErrNoMoreEvents) {
+		t.Fatalf("expected ErrNoMoreEvents got %v", err)
+	}
+	if len(logger.warnings) != 2 {
+		t.Fatalf("expected 2 logged skipped events got %d", len(logger.warnings))
+	}
+}
+
+func TestGetRaw(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	events := flights(id, 0, 2)
+	events[1].Metadata = map[string]interface{}{"user": "kalle"}
+	err := es.Save(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterator, err := es.GetRaw(context.Background(), id, "FrequentFlierAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	var raw []sql.RawEvent
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, eventsourcing.ErrNoMoreEvents) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		raw = append(raw, event)
+	}
+	if len(raw) != 2 {
+		t.Fatalf("expected 2 events got %d", len(raw))
+	}
+	if raw[1].Reason != "FlightTaken" || raw[1].Version != 2 || raw[1].EventID != events[1].EventID {
+		t.Fatalf("unexpected raw event %v", raw[1])
+	}
+	data := suite.FlightTaken{}
+	err = raw[1].Decode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.MilesAdded != 2 {
+		t.Fatalf("expected 2 miles got %d", data.MilesAdded)
+	}
+	metadata := map[string]interface{}{}
+	err = raw[1].DecodeMetadata(&metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["user"] != "kalle" {
+		t.Fatalf("expected metadata user kalle got %v", metadata)
+	}
+
+	global, err := es.GlobalRawEvents(uuid.Nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(global) != 2 || global[1].EventID != events[1].EventID {
+		t.Fatalf("expected the 2 raw events in global order got %v", global)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	source := newStore(t)
+	defer source.Close()
+	id1 := eventsourcing.NewUuid()
+	id2 := eventsourcing.NewUuid()
+	if err := source.Save(flights(id1, 0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Save(flights(id2, 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Save(flights(id1, 3, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err := source.Export(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(buf.Bytes(), []byte("\n")); lines != 6 {
+		t.Fatalf("expected 6 exported events got %d", lines)
+	}
+
+	target := newStore(t)
+	defer target.Close()
+	err = target.Import(context.Background(), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := getAll(t, target, id1, 0); len(events) != 4 {
+		t.Fatalf("expected 4 imported events got %d", len(events))
+	}
+	if events := getAll(t, target, id2, 0); len(events) != 2 {
+		t.Fatalf("expected 2 imported events got %d", len(events))
+	}
+
+	// a second import into the now non-empty store is rejected
+	err = target.Import(context.Background(), bytes.NewReader(buf.Bytes()))
+	if !errors.Is(err, sql.ErrStoreNotEmpty) {
+		t.Fatalf("expected ErrStoreNotEmpty got %v", err)
+	}
+	// merging the same events conflicts with the existing versions
+	err = target.Import(context.Background(), bytes.NewReader(buf.Bytes()), sql.WithMerge())
+	if !errors.Is(err, eventstore.ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency got %v", err)
+	}
+}
+
+func TestImportIntoArchivedStore(t *testing.T) {
+	source := newStore(t)
+	defer source.Close()
+	if err := source.Save(flights(eventsourcing.NewUuid(), 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err := source.Export(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a store with all its events archived is not empty
+	target := newStore(t, sql.WithArchive())
+	defer target.Close()
+	if err = target.Save(flights(eventsourcing.NewUuid(), 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = target.Archive(context.Background(), eventsourcing.NewUuid()); err != nil {
+		t.Fatal(err)
+	}
+	err = target.Import(context.Background(), bytes.NewReader(buf.Bytes()))
+	if !errors.Is(err, sql.ErrStoreNotEmpty) {
+		t.Fatalf("expected ErrStoreNotEmpty got %v", err)
+	}
+}
+
+func TestSigning(t *testing.T) {
+	db := newDB(t)
+	es := sql.Open(db, *newSerializer(t), sql.WithSigner(eventsourcing.NewHMACSigner([]byte("secret"))))
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getAll(t, es, id, 0)) != 2 {
+		t.Fatal("expected the signed events to verify")
+	}
+
+	// corrupt the stored data of the second event
+	_, err = db.Exec(`UPDATE events SET data = ? WHERE version = 2`, `{"MilesAdded":1000,"TierPointsAdded":2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterator, err := es.Get(context.Background(), id, "FrequentFlierAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	if _, err = iterator.Next(); err != nil {
+		t.Fatalf("expected the first event to verify got %v", err)
+	}
+	_, err = iterator.Next()
+	if !errors.Is(err, eventsourcing.ErrEventTampered) {
+		t.Fatalf("expected ErrEventTampered got %v", err)
+	}
+	_, err = es.GlobalEvents(uuid.Nil, 10)
+	if !errors.Is(err, eventsourcing.ErrEventTampered) {
+		t.Fatalf("expected ErrEventTampered from GlobalEvents got %v", err)
+	}
+
+	// move the first event to another tenant
+	_, err = db.Exec(`UPDATE events SET tenant_id = ? WHERE version = 1`, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := sql.Open(db, *newSerializer(t), sql.WithTenant("other"), sql.WithSigner(eventsourcing.NewHMACSigner([]byte("secret"))))
+	_, err = other.GlobalEvents(uuid.Nil, 10)
+	if !errors.Is(err, eventsourcing.ErrEventTampered) {
+		t.Fatalf("expected ErrEventTampered from the moved event got %v", err)
+	}
+}
+
+// person is an aggregate with no events registered in the serializer
+type person struct {
+	eventsourcing.AggregateRoot
+}
+
+func (p *person) Transition(e eventsourcing.Event) {}
+
+func TestGetUnregisteredAggregate(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+	repo := eventsourcing.NewRepository(es, nil)
+
+	// the Person aggregate is not registered in the serializer of the store
+	err := repo.Get(eventsourcing.NewUuid(), &person{})
+	if !errors.Is(err, eventsourcing.ErrUnregisteredAggregate) {
+		t.Fatalf("expected ErrUnregisteredAggregate got %v", err)
+	}
+	err = repo.Get(eventsourcing.NewUuid(), &suite.FrequentFlierAccount{})
+	if !errors.Is(err, eventsourcing.ErrAggregateNotFound) {
+		t.Fatalf("expected ErrAggregateNotFound got %v", err)
+	}
+}
+
+func TestCountByReason(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	events := []eventsourcing.Event{{EventID: eventsourcing.NewUuid(), AggregateID: id, Version: 1, AggregateType: "FrequentFlierAccount", Timestamp: time.Now().UTC(), Data: &suite.FrequentFlierAccountCreated{AccountId: "1234567"}}}
+	events = append(events, flights(id, 1, 3)...)
+	events = append(events, eventsourcing.Event{EventID: eventsourcing.NewUuid(), AggregateID: id, Version: 5, AggregateType: "FrequentFlierAccount", Timestamp: time.Now().UTC(), Data: &suite.StatusMatched{NewStatus: suite.StatusSilver}})
+	err := es.Save(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, err := eventsourcing.NewRepository(es, nil).CountByReason(context.Background(), id, "FrequentFlierAccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 3 || counts["FrequentFlierAccountCreated"] != 1 || counts["FlightTaken"] != 3 || counts["StatusMatched"] != 1 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+}
+
+func TestReadReplica(t *testing.T) {
+	primary := newDB(t)
+	replica := newDB(t)
+	// migrate both databases, the replica is left empty to see where the reads and writes go
+	for _, db := range []*sqldriver.DB{primary, replica} {
+		if err := sql.Open(db, *newSerializer(t)).MigrateTest(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	es := sql.Open(primary, *newSerializer(t), sql.WithReadReplica(replica))
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	err := es.Save(flights(id, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the version check is made on the primary, the empty replica would give a false ErrConcurrency
+	err = es.Save(flights(id, 2, 1))
+	if err != nil {
+		t.Fatalf("expected the save to check the version on the primary got %v", err)
+	}
+	var count int
+	err = primary.QueryRow(`SELECT COUNT(*) FROM events`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 events in the primary got %d", count)
+	}
+	// reads go to the replica
+	if len(getAll(t, es, id, 0)) != 0 {
+		t.Fatal("expected the read to hit the empty replica")
+	}
+}
+
+func TestMigrateTwice(t *testing.T) {
+	db := newDB(t)
+	es := sql.Open(db, *newSerializer(t))
+	defer es.Close()
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	err = es.Save(flights(id, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = es.MigrateTest()
+	if err != nil {
+		t.Fatalf("expected the second migrate to be a no-op got %v", err)
+	}
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one row per migration
+	if count != 7 {
+		t.Fatalf("expected 7 recorded migrations got %d", count)
+	}
+	if len(getAll(t, es, id, 0)) != 1 {
+		t.Fatal("expected the events to be kept")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	db := newDB(t)
+	es := sql.Open(db, *newSerializer(t))
+	defer es.Close()
+	// SchemaVersion only reads, the migrations table is not created before Migrate
+	version, err := es.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("expected schema version 0 before migrate got %d", version)
+	}
+	if _, err = db.Exec(`SELECT version FROM schema_migrations`); err == nil {
+		t.Fatal("expected SchemaVersion to not create the migrations table")
+	}
+	err = es.MigrateTestWithStep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err = es.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Fatalf("expected schema version 2 got %d", version)
+	}
+	err = es.RollbackTest(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err = es.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("expected schema version 1 got %d", version)
+	}
+	// the table of the rolled back step is dropped while the events table is kept
+	if _, err = db.Exec(`SELECT id FROM rollback_test`); err == nil {
+		t.Fatal("expected the rollback_test table to be dropped")
+	}
+	if err = es.Save(flights(eventsourcing.NewUuid(), 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	es := newStore(t, sql.WithPrefetch(4))
+	defer es.Close()
+
+	// the test sql driver sorts the version as a string, the events are kept below 10 to fill the buffer more than
+	// once in version order
+	id := eventsourcing.NewUuid()
+	err := es.Save(flights(id, 0, 9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := getAll(t, es, id, 0)
+	if len(events) != 9 {
+		t.Fatalf("expected 9 events got %d", len(events))
+	}
+	for i, e := range events {
+		if e.Version != eventsourcing.Version(i+1) {
+			t.Fatalf("expected version %d got %d", i+1, e.Version)
+		}
+	}
+}
+
+func TestPrefetchContextCanceled(t *testing.T) {
+	es := newStore(t, sql.WithPrefetch(5))
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	err := es.Save(flights(id, 0, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	iterator, err := es.Get(ctx, id, "FrequentFlierAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	if _, err = iterator.Next(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	// the buffered events are returned before the context error
+	count := 1
+	for {
+		_, err = iterator.Next()
+		if err != nil {
+			break
+		}
+		count++
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+	if count != 5 {
+		t.Fatalf("expected 5 events before the cancel got %d", count)
+	}
+}
+
+func benchmarkReplay(b *testing.B, options ...sql.Option) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	ser.Register(&suite.FrequentFlierAccount{}, ser.Events(&suite.FlightTaken{}))
+	db, err := sqldriver.Open("ramsql-drain", fmt.Sprintf("%d", seededRand.Intn(999999999999)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	es := sql.Open(db, *ser, options...)
+	defer es.Close()
+	if err = es.MigrateTest(); err != nil {
+		b.Fatal(err)
+	}
+	id := eventsourcing.NewUuid()
+	if err = es.Save(flights(id, 0, 10000)); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iterator, err := es.Get(context.Background(), id, "FrequentFlierAccount", 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			_, err = iterator.Next()
+			if errors.Is(err, eventsourcing.ErrNoMoreEvents) {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+		iterator.Close()
+	}
+}
+
+func BenchmarkReplay(b *testing.B) {
+	benchmarkReplay(b)
+}
+
+func BenchmarkReplayPrefetch(b *testing.B) {
+	benchmarkReplay(b, sql.WithPrefetch(100))
+}
+
+func TestTruncate(t *testing.T) {
+	es := newStore(t)
+	defer es.Close()
+
+	id := eventsourcing.NewUuid()
+	err := es.Save(flights(id, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = es.Truncate(context.Background(), id, "FrequentFlierAccount", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := getAll(t, es, id, 0)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events got %d", len(events))
+	}
+	if events[0].Version != 4 {
+		t.Fatalf("expected the first kept event to have version 4 got %d", events[0].Version)
+	}
+	// the version check still sees the kept events
+	err = es.Save(flights(id, 5, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeSource is a notification source fed from a channel, as the notifications of another connection
+type fakeSource struct {
+	channel       string
+	notifications chan string
+}
+
+func (f *fakeSource) Listen(ctx context.Context, channel string) error {
+	f.channel = channel
+	return nil
+}
+
+func (f *fakeSource) WaitForNotification(ctx context.Context) (string, error) {
+	select {
+	case payload := <-f.notifications:
+		return payload, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func TestListen(t *testing.T) {
+	source := &fakeSource{notifications: make(chan string, 2)}
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := sql.Listen(ctx, source, "events_channel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.channel != "events_channel" {
+		t.Fatalf("expected to listen on events_channel got %s", source.channel)
+	}
+	id := eventsourcing.NewUuid()
+	source.notifications <- "not an event id"
+	source.notifications <- id.String()
+	if received := <-c; received != id {
+		t.Fatalf("expected %s got %s", id, received)
+	}
+	cancel()
+	if _, ok := <-c; ok {
+		t.Fatal("expected the channel to be closed when the context is done")
+	}
+}
+
+// project inserts the events after the checkpoint in the flights read model and moves the checkpoint in the same
+// transaction, it stops after max events or handles all events if max is negative
+func project(t *testing.T, db *sqldriver.DB, es *sql.SQL, checkpoints *sql.Checkpoints, max int) {
+	checkpoint, err := checkpoints.Load("flights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test sql driver can't compare uuids, the events up to the checkpoint are skipped here instead of in the
+	// query
+	events, err := es.GlobalEvents(uuid.Nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if bytes.Compare(event.EventID.Bytes(), checkpoint.Bytes()) <= 0 {
+			continue
+		}
+		if max == 0 {
+			return
+		}
+		max--
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tx.Exec(`INSERT INTO flights (event_id) VALUES ($1)`, event.EventID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkpoints.SaveTx(tx, "flights", event.EventID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckpoints(t *testing.T) {
+	db := newDB(t)
+	es := sql.Open(db, *newSerializer(t))
+	err := es.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints := sql.NewCheckpoints(db)
+	err = checkpoints.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE flights (event_id UUID PRIMARY KEY);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := checkpoints.Load("flights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint != uuid.Nil {
+		t.Fatalf("expected no checkpoint got %s", checkpoint)
+	}
+	events := flights(eventsourcing.NewUuid(), 0, 5)
+	err = es.Save(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stop after the second event, the test sql driver has no rollback so a crash before the commit can't be shown
+	project(t, db, es, checkpoints, 2)
+
+	// the restarted projection resumes after the second event
+	checkpoints = sql.NewCheckpoints(db)
+	checkpoint, err = checkpoints.Load("flights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint != events[1].EventID {
+		t.Fatalf("expected checkpoint %s got %s", events[1].EventID, checkpoint)
+	}
+	project(t, db, es, checkpoints, -1)
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM flights`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(events) {
+		t.Fatalf("expected %d projected events got %d", len(events), count)
+	}
+	checkpoint, err = checkpoints.Load("flights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint != events[4].EventID {
+		t.Fatalf("expected checkpoint %s got %s", events[4].EventID, checkpoint)
+	}
+}
@@ -0,0 +1,18 @@
+package suite
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+)
+
+// Keys is a key provider for encryption tests that holds one key per aggregate id
+type Keys map[uuid.UUID][]byte
+
+// Key returns the key of the aggregate, eventsourcing.ErrKeyNotFound if it has none
+func (k Keys) Key(aggregateType string, aggregateID uuid.UUID) ([]byte, error) {
+	key, ok := k[aggregateID]
+	if !ok {
+		return nil, eventsourcing.ErrKeyNotFound
+	}
+	return key, nil
+}
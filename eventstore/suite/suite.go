@@ -34,6 +34,8 @@ func Test(t *testing.T, esFunc eventstoreFunc) {
 		{"should save and get event concurrently", saveAndGetEventsConcurrently},
 		{"should return error when no events", getErrWhenNoEvents},
 		{"should get global event order from save", saveReturnGlobalEventOrder},
+		{"should return the latest version", latestVersion},
+		{"should keep nil and empty metadata apart", metadataRoundTrip},
 	}
 	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
 
@@ -109,6 +111,15 @@ func testEventsWithID(aggregateID uuid.UUID) []eventsourcing.Event {
 	return history
 }
 
+// uniqueEvents returns the test events with new event ids, for stores where the event id is unique across aggregates
+func uniqueEvents(aggregateID uuid.UUID) []eventsourcing.Event {
+	events := testEventsWithID(aggregateID)
+	for i := range events {
+		events[i].EventID = eventsourcing.NewUuid()
+	}
+	return events
+}
+
 func testEvents(aggregateID uuid.UUID) []eventsourcing.Event {
 	return testEventsWithID(aggregateID)
 }
@@ -297,54 +308,61 @@ func saveEventsWithEmptyReason(es eventsourcing.EventStore) error {
 }
 
 func saveAndGetEventsConcurrently(es eventsourcing.EventStore) error {
-	wg := sync.WaitGroup{}
-	var err error
-
-	wg.Add(10)
-	for i := 0; i < 10; i++ {
-		events := testEventsWithID(AggregateID())
-		go func() {
-			e := es.Save(events)
-			if e != nil {
-				err = e
-			}
-			wg.Done()
-		}()
-	}
-	if err != nil {
-		return err
+	return ConcurrentSaveCheck(es, 10)
+}
+
+// ConcurrentSaveCheck saves the events of n aggregates from n goroutines and then reads them back from n
+// goroutines. The errors are collected and returned after all goroutines are done, which makes it safe to run
+// with -race when implementing an event store.
+func ConcurrentSaveCheck(es eventsourcing.EventStore, n int) error {
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		ids[i] = AggregateID()
 	}
+	errs := make(chan error, 2*n)
 
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for _, id := range ids {
+		go func(events []eventsourcing.Event) {
+			defer wg.Done()
+			if err := es.Save(events); err != nil {
+				errs <- err
+			}
+		}(uniqueEvents(id))
+	}
 	wg.Wait()
-	wg.Add(10)
-	for i := 0; i < 10; i++ {
-		go func() {
+
+	wg.Add(n)
+	for _, id := range ids {
+		go func(id uuid.UUID) {
 			defer wg.Done()
-			iterator, e := es.Get(context.Background(), AggregateID(), aggregateType, 0)
-			if e != nil {
-				err = e
+			iterator, err := es.Get(context.Background(), id, aggregateType, 0)
+			if err != nil {
+				errs <- err
 				return
 			}
-			events := make([]eventsourcing.Event, 0)
+			defer iterator.Close()
+			count := 0
 			for {
-				event, err := iterator.Next()
-				if err != nil {
+				_, err := iterator.Next()
+				if errors.Is(err, eventsourcing.ErrNoMoreEvents) {
 					break
+				} else if err != nil {
+					errs <- err
+					return
 				}
-				events = append(events, event)
+				count++
 			}
-			iterator.Close()
-			if len(events) != 6 {
-				err = fmt.Errorf("wrong number of events fetched, expecting 6 got %d", len(events))
-				return
+			if count != 6 {
+				errs <- fmt.Errorf("wrong number of events fetched, expecting 6 got %d", count)
 			}
-		}()
-	}
-	if err != nil {
-		return err
+		}(id)
 	}
 	wg.Wait()
-	return nil
+	close(errs)
+	// the first error is returned, the others are most likely the same
+	return <-errs
 }
 
 func getErrWhenNoEvents(es eventsourcing.EventStore) error {
@@ -364,6 +382,30 @@ func getErrWhenNoEvents(es eventsourcing.EventStore) error {
 	return nil
 }
 
+func latestVersion(es eventsourcing.EventStore) error {
+	aggregateID := AggregateID()
+	version, err := eventsourcing.LatestVersion(context.Background(), es, aggregateID, aggregateType)
+	if err != nil {
+		return err
+	}
+	if version != 0 {
+		return fmt.Errorf("expected version 0 for a stream that does not exist got %d", version)
+	}
+	events := testEvents(aggregateID)
+	err = es.Save(events)
+	if err != nil {
+		return err
+	}
+	version, err = eventsourcing.LatestVersion(context.Background(), es, aggregateID, aggregateType)
+	if err != nil {
+		return err
+	}
+	if version != events[len(events)-1].Version {
+		return fmt.Errorf("expected version %d got %d", events[len(events)-1].Version, version)
+	}
+	return nil
+}
+
 func saveReturnGlobalEventOrder(es eventsourcing.EventStore) error {
 	aggregateID := AggregateID()
 	aggregateID2 := AggregateID()
@@ -385,3 +427,43 @@ func saveReturnGlobalEventOrder(es eventsourcing.EventStore) error {
 	}
 	return nil
 }
+
+func metadataRoundTrip(es eventsourcing.EventStore) error {
+	aggregateID := AggregateID()
+	events := uniqueEvents(aggregateID)[:3]
+	events[0].Metadata = nil
+	events[1].Metadata = map[string]interface{}{}
+	events[2].Metadata = map[string]interface{}{"test": "hello"}
+	err := es.Save(events)
+	if err != nil {
+		return err
+	}
+	iterator, err := es.Get(context.Background(), aggregateID, aggregateType, 0)
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+	var fetched []eventsourcing.Event
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, eventsourcing.ErrNoMoreEvents) {
+			break
+		} else if err != nil {
+			return err
+		}
+		fetched = append(fetched, event)
+	}
+	if len(fetched) != 3 {
+		return fmt.Errorf("expected 3 events got %d", len(fetched))
+	}
+	if fetched[0].Metadata != nil {
+		return fmt.Errorf("expected nil metadata got %v", fetched[0].Metadata)
+	}
+	if fetched[1].Metadata == nil || len(fetched[1].Metadata) != 0 {
+		return fmt.Errorf("expected empty non-nil metadata got %#v", fetched[1].Metadata)
+	}
+	if fetched[2].Metadata["test"] != "hello" {
+		return fmt.Errorf("expected the metadata test=hello got %v", fetched[2].Metadata)
+	}
+	return nil
+}
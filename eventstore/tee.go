@@ -0,0 +1,86 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+)
+
+// ErrSecondarySave wraps the error from the secondary store of the TeeEventStore
+var ErrSecondarySave = errors.New("secondary save")
+
+// TeeEventStore writes the events to a primary and a secondary store and reads from the primary only. It's made
+// for migrating to another event store without downtime, the new store is set as secondary and backfilled while the
+// old store remains authoritative, once it has caught up the stores are swapped.
+type TeeEventStore struct {
+	primary           eventsourcing.EventStore
+	secondary         eventsourcing.EventStore
+	secondaryRequired bool
+	logger            eventsourcing.Logger
+}
+
+// NewTeeEventStore returns a TeeEventStore writing to primary and secondary
+func NewTeeEventStore(primary, secondary eventsourcing.EventStore) *TeeEventStore {
+	return &TeeEventStore{
+		primary:   primary,
+		secondary: secondary,
+		logger:    eventsourcing.NoopLogger{},
+	}
+}
+
+// SetSecondaryRequired makes a failed save to the secondary store return ErrSecondarySave, by default it's logged.
+// The events are then saved in the primary store even though the save returns an error.
+func (t *TeeEventStore) SetSecondaryRequired(required bool) {
+	t.secondaryRequired = required
+}
+
+// SetLogger sets the logger used to log failed saves to the secondary store
+func (t *TeeEventStore) SetLogger(logger eventsourcing.Logger) {
+	t.logger = logger
+}
+
+// Save saves the events in the primary and then the secondary store
+func (t *TeeEventStore) Save(events []eventsourcing.Event) error {
+	return t.SaveContext(context.Background(), events)
+}
+
+// SaveContext saves the events in the primary and then the secondary store, the secondary is not written if the
+// primary save fails
+func (t *TeeEventStore) SaveContext(ctx context.Context, events []eventsourcing.Event) error {
+	// each store gets a copy to not see changes made by the other
+	err := saveContext(ctx, t.primary, append([]eventsourcing.Event(nil), events...))
+	if err != nil {
+		return err
+	}
+	err = saveContext(ctx, t.secondary, append([]eventsourcing.Event(nil), events...))
+	if err == nil || len(events) == 0 {
+		return err
+	}
+	err = fmt.Errorf("%w: %v", ErrSecondarySave, err)
+	if t.secondaryRequired {
+		return err
+	}
+	t.logger.Warn("tee secondary save", "aggregate_type", events[0].AggregateType, "aggregate_id", events[0].AggregateID, "error", err)
+	return nil
+}
+
+// Get fetches the events from the primary store
+func (t *TeeEventStore) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	return t.primary.Get(ctx, id, aggregateType, afterVersion)
+}
+
+// LatestVersion fetches the latest version from the primary store
+func (t *TeeEventStore) LatestVersion(ctx context.Context, id uuid.UUID, aggregateType string) (eventsourcing.Version, error) {
+	return eventsourcing.LatestVersion(ctx, t.primary, id, aggregateType)
+}
+
+// saveContext saves the events with the context if the store supports it
+func saveContext(ctx context.Context, store eventsourcing.EventStore, events []eventsourcing.Event) error {
+	if saver, ok := store.(eventsourcing.ContextSaver); ok {
+		return saver.SaveContext(ctx, events)
+	}
+	return store.Save(events)
+}
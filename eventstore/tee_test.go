@@ -0,0 +1,67 @@
+package eventstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore"
+	"github.com/hallgren/eventsourcing/eventstore/memory"
+)
+
+func TestTeeEventStore(t *testing.T) {
+	primary := memory.Create()
+	secondary := memory.Create()
+	store := eventstore.NewTeeEventStore(primary, secondary)
+
+	id := eventsourcing.NewUuid()
+	err := store.Save(events(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []eventsourcing.EventStore{primary, secondary} {
+		version, err := eventsourcing.LatestVersion(context.Background(), s, id, "Person")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 1 {
+			t.Fatalf("expected the events in both stores got version %d", version)
+		}
+	}
+
+	// events only in the secondary are not read
+	other := eventsourcing.NewUuid()
+	err = secondary.Save(events(other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get(context.Background(), other, "Person", 0)
+	if !errors.Is(err, eventsourcing.ErrNoEvents) {
+		t.Fatalf("expected ErrNoEvents from the primary got %v", err)
+	}
+}
+
+func TestTeeEventStoreSecondaryFailure(t *testing.T) {
+	primary := memory.Create()
+	secondary := &failingStore{EventStore: memory.Create(), failures: 2, err: errTransient}
+	store := eventstore.NewTeeEventStore(primary, secondary)
+
+	err := store.Save(events(eventsourcing.NewUuid()))
+	if err != nil {
+		t.Fatalf("expected the secondary failure to be logged got %v", err)
+	}
+	store.SetSecondaryRequired(true)
+	id := eventsourcing.NewUuid()
+	err = store.Save(events(id))
+	if !errors.Is(err, eventstore.ErrSecondarySave) {
+		t.Fatalf("expected ErrSecondarySave got %v", err)
+	}
+	version, err := primary.LatestVersion(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("expected the events in the primary got version %d", version)
+	}
+}
@@ -1,9 +1,15 @@
 package eventsourcing
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
 )
 
 // EventStream struct that handles event subscription
@@ -12,26 +18,44 @@ type EventStream struct {
 	lock sync.Mutex
 
 	// holds subscribers of aggregate types events
-	aggregateTypes map[string][]*subscription
+	aggregateTypes map[aggregateRef][]*subscription
 	// holds subscribers of specific aggregates (type and identifier)
-	specificAggregates map[string][]*subscription
+	specificAggregates map[aggregateRef][]*subscription
 	// holds subscribers of specific events
 	specificEvents map[reflect.Type][]*subscription
 	// holds subscribers of all events
 	all []*subscription
+	// holds subscribers of all events that get the events of a publish as one batch
+	batches []*subscription
 	// holds subscribers of aggregate and events by name
 	names map[string][]*subscription
+	// holds the sinks forwarding events out of the process, the events are queued to them after the subscribers
+	sinks []*subscription
+	// logs the published events
+	logger Logger
+	// onError is called with errors from subscribers, e.g. a recovered panic
+	onError func(event Event, err error)
+}
+
+// aggregateRef references the aggregate subscriptions. The package path makes aggregate types with the same name in
+// different packages different references, the id is only set on subscriptions of specific aggregates.
+type aggregateRef struct {
+	path string
+	name string
+	id   uuid.UUID
 }
 
 // subscription holds the event function to be triggered when an event is triggering the subscription,
 // it also hols a close function to end the subscription.
 // event matches the subscription
 type subscription struct {
-	eventF func(e Event)
+	eventF func(ctx context.Context, e Event)
+	// batchF is set instead of eventF on batch subscriptions
+	batchF func(ctx context.Context, events []Event)
 	close  func()
 }
 
-// Close stops the subscription
+// Close stops the subscription and removes it from the event stream
 func (s *subscription) Close() {
 	s.close()
 }
@@ -39,69 +63,131 @@ func (s *subscription) Close() {
 // NewEventStream factory function
 func NewEventStream() *EventStream {
 	return &EventStream{
-		aggregateTypes:     make(map[string][]*subscription),
-		specificAggregates: make(map[string][]*subscription),
+		aggregateTypes:     make(map[aggregateRef][]*subscription),
+		specificAggregates: make(map[aggregateRef][]*subscription),
 		specificEvents:     make(map[reflect.Type][]*subscription),
 		all:                make([]*subscription, 0),
 		names:              make(map[string][]*subscription),
+		logger:             NoopLogger{},
 	}
 }
 
+// SetLogger sets the logger used by the event stream
+func (e *EventStream) SetLogger(logger Logger) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.logger = logger
+}
+
+// ErrSubscriberPanic wraps the value of a panic recovered from a subscriber
+var ErrSubscriberPanic = errors.New("subscriber panic")
+
+// SetErrorHandler sets the function that is called with errors from subscribers. A subscriber that panics is
+// recovered, the panic is passed to the handler as ErrSubscriberPanic and the event is delivered to the remaining
+// subscribers.
+func (e *EventStream) SetErrorHandler(f func(event Event, err error)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.onError = f
+}
+
 // Publish calls the functions that are subscribing to the event stream
 func (e *EventStream) Publish(agg AggregateRoot, events []Event) {
+	e.PublishWithContext(context.Background(), agg, events)
+}
+
+// PublishWithContext calls the functions that are subscribing to the event stream, the context is passed on to
+// subscribers that subscribe with context.
+func (e *EventStream) PublishWithContext(ctx context.Context, agg AggregateRoot, events []Event) {
 	// the lock prevent other event updates get mixed with this update
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
 	for _, event := range events {
-		e.allPublisher(event)
-		e.specificEventPublisher(event)
-		e.aggregateTypePublisher(agg, event)
-		e.specificAggregatesPublisher(agg, event)
-		e.namePublisher(event)
+		e.logger.Debug("publish event", "aggregate_type", event.AggregateType, "aggregate_id", event.AggregateID, "reason", event.Reason())
+		e.allPublisher(ctx, event)
+		e.specificEventPublisher(ctx, event)
+		e.aggregateTypePublisher(ctx, agg, event)
+		e.specificAggregatesPublisher(ctx, agg, event)
+		e.namePublisher(ctx, event)
+		e.publish(ctx, e.sinks, event)
+	}
+	if len(events) > 0 {
+		for _, s := range e.batches {
+			e.deliverBatch(ctx, s, events)
+		}
 	}
 }
 
 // call functions that has registered for all events
-func (e *EventStream) allPublisher(event Event) {
-	publish(e.all, event)
+func (e *EventStream) allPublisher(ctx context.Context, event Event) {
+	e.publish(ctx, e.all, event)
 }
 
 // call functions that has registered for the specific event
-func (e *EventStream) specificEventPublisher(event Event) {
+func (e *EventStream) specificEventPublisher(ctx context.Context, event Event) {
 	ref := reflect.TypeOf(event.Data)
 	if subs, ok := e.specificEvents[ref]; ok {
-		publish(subs, event)
+		e.publish(ctx, subs, event)
 	}
 }
 
 // call functions that has registered for the aggregate type events
-func (e *EventStream) aggregateTypePublisher(agg AggregateRoot, event Event) {
-	ref := fmt.Sprintf("%s_%s", agg.path(), event.AggregateType)
+func (e *EventStream) aggregateTypePublisher(ctx context.Context, agg AggregateRoot, event Event) {
+	if agg.path() == "" {
+		e.namedPublisher(ctx, e.aggregateTypes, aggregateRef{name: event.AggregateType}, event)
+		return
+	}
+	ref := aggregateRef{path: agg.path(), name: event.AggregateType}
 	if subs, ok := e.aggregateTypes[ref]; ok {
-		publish(subs, event)
+		e.publish(ctx, subs, event)
 	}
 }
 
 // call functions that has registered for the aggregate type and ID events
-func (e *EventStream) specificAggregatesPublisher(agg AggregateRoot, event Event) {
+func (e *EventStream) specificAggregatesPublisher(ctx context.Context, agg AggregateRoot, event Event) {
+	if agg.path() == "" {
+		e.namedPublisher(ctx, e.specificAggregates, aggregateRef{name: event.AggregateType, id: agg.ID()}, event)
+		return
+	}
 	// ref also include the package name ensuring that Aggregate Types can have the same name.
-	ref := fmt.Sprintf("%s_%s_%s", agg.path(), event.AggregateType, agg.ID())
+	ref := aggregateRef{path: agg.path(), name: event.AggregateType, id: agg.ID()}
 	if subs, ok := e.specificAggregates[ref]; ok {
-		publish(subs, event)
+		e.publish(ctx, subs, event)
+	}
+}
+
+// namedPublisher calls the functions registered on the refs with the name and id of ref in any package, ordered by
+// package path. It's used when the published root has no package path, e.g. a bare AggregateRoot passed to Publish.
+func (e *EventStream) namedPublisher(ctx context.Context, refs map[aggregateRef][]*subscription, ref aggregateRef, event Event) {
+	var matches []aggregateRef
+	for r := range refs {
+		if r.name == ref.name && r.id == ref.id {
+			matches = append(matches, r)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].path < matches[j].path })
+	for _, r := range matches {
+		e.publish(ctx, refs[r], event)
 	}
 }
 
 // call functions that has registered for the aggregate type events
-func (e *EventStream) namePublisher(event Event) {
+func (e *EventStream) namePublisher(ctx context.Context, event Event) {
 	ref := event.AggregateType + "_" + event.Reason()
 	if subs, ok := e.names[ref]; ok {
-		publish(subs, event)
+		e.publish(ctx, subs, event)
 	}
 }
 
 // All subscribe to all events that is stored in the repository
 func (e *EventStream) All(f func(e Event)) *subscription {
+	return e.AllWithContext(withoutContext(f))
+}
+
+// AllWithContext subscribe to all events that is stored in the repository. The context passed to Repository.SaveWithContext
+// is passed on to the subscriber making it possible to continue request scoped values like traces.
+func (e *EventStream) AllWithContext(f func(ctx context.Context, e Event)) *subscription {
 	s := subscription{
 		eventF: f,
 	}
@@ -117,10 +203,46 @@ func (e *EventStream) All(f func(e Event)) *subscription {
 	return &s
 }
 
+// AllBatch subscribe to all events that is stored in the repository, the events of a save are delivered in one call
+// after the per event subscribers. It makes it possible for a projection to commit a transaction per save instead
+// of per event.
+func (e *EventStream) AllBatch(f func(events []Event)) *subscription {
+	s := subscription{
+		batchF: func(ctx context.Context, events []Event) {
+			f(events)
+		},
+	}
+	s.close = func() {
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		s.batchF = nil
+		e.batches = clean(e.batches)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.batches = append(e.batches, &s)
+	return &s
+}
+
+// GlobalOrder subscribe to all events and deliver them in ascending global order (event id). Events from
+// concurrent saves can be published out of order, to handle this each event is held for the window duration
+// before it's delivered and the buffered events are sorted. The order is only guaranteed for events that are
+// published within the window of each other, a longer window gives stronger ordering at the cost of latency.
+func (e *EventStream) GlobalOrder(f func(e Event), window time.Duration) *subscription {
+	g := newGlobalOrder(f, window)
+	s := e.All(g.add)
+	closeAll := s.close
+	s.close = func() {
+		closeAll()
+		g.stop()
+	}
+	return s
+}
+
 // AggregateID subscribe to events that belongs to aggregate's based on its type and ID
 func (e *EventStream) AggregateID(f func(e Event), aggregates ...Aggregate) *subscription {
 	s := subscription{
-		eventF: f,
+		eventF: withoutContext(f),
 	}
 	s.close = func() {
 		e.lock.Lock()
@@ -128,16 +250,18 @@ func (e *EventStream) AggregateID(f func(e Event), aggregates ...Aggregate) *sub
 		s.eventF = nil
 		// clean all evenF functions that are nil
 		for ref, items := range e.specificAggregates {
-			e.specificAggregates[ref] = clean(items)
+			if items = clean(items); len(items) == 0 {
+				delete(e.specificAggregates, ref)
+			} else {
+				e.specificAggregates[ref] = items
+			}
 		}
 	}
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
 	for _, a := range aggregates {
-		name := reflect.TypeOf(a).Elem().Name()
-		root := a.Root()
-		ref := fmt.Sprintf("%s_%s_%s", root.path(), name, root.ID())
+		ref := aggregateRef{path: aggregatePath(a), name: aggregateName(a), id: a.Root().ID()}
 
 		// adds one more function to the aggregate
 		e.specificAggregates[ref] = append(e.specificAggregates[ref], &s)
@@ -148,7 +272,7 @@ func (e *EventStream) AggregateID(f func(e Event), aggregates ...Aggregate) *sub
 // Aggregate subscribe to events based on the aggregate type
 func (e *EventStream) Aggregate(f func(e Event), aggregates ...Aggregate) *subscription {
 	s := subscription{
-		eventF: f,
+		eventF: withoutContext(f),
 	}
 	s.close = func() {
 		e.lock.Lock()
@@ -156,16 +280,18 @@ func (e *EventStream) Aggregate(f func(e Event), aggregates ...Aggregate) *subsc
 		s.eventF = nil
 		// clean all evenF functions that are nil
 		for ref, items := range e.aggregateTypes {
-			e.aggregateTypes[ref] = clean(items)
+			if items = clean(items); len(items) == 0 {
+				delete(e.aggregateTypes, ref)
+			} else {
+				e.aggregateTypes[ref] = items
+			}
 		}
 	}
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
 	for _, a := range aggregates {
-		name := reflect.TypeOf(a).Elem().Name()
-		root := a.Root()
-		ref := fmt.Sprintf("%s_%s", root.path(), name)
+		ref := aggregateRef{path: aggregatePath(a), name: aggregateName(a)}
 
 		// adds one more function to the aggregate
 		e.aggregateTypes[ref] = append(e.aggregateTypes[ref], &s)
@@ -176,7 +302,7 @@ func (e *EventStream) Aggregate(f func(e Event), aggregates ...Aggregate) *subsc
 // Event subscribe on specific application defined events based on type referencing.
 func (e *EventStream) Event(f func(e Event), events ...interface{}) *subscription {
 	s := subscription{
-		eventF: f,
+		eventF: withoutContext(f),
 	}
 	s.close = func() {
 		e.lock.Lock()
@@ -184,7 +310,11 @@ func (e *EventStream) Event(f func(e Event), events ...interface{}) *subscriptio
 		s.eventF = nil
 		// clean all evenF functions that are nil
 		for ref, items := range e.specificEvents {
-			e.specificEvents[ref] = clean(items)
+			if items = clean(items); len(items) == 0 {
+				delete(e.specificEvents, ref)
+			} else {
+				e.specificEvents[ref] = items
+			}
 		}
 	}
 	e.lock.Lock()
@@ -198,11 +328,21 @@ func (e *EventStream) Event(f func(e Event), events ...interface{}) *subscriptio
 	return &s
 }
 
+// SubscribeEvent subscribes f to the events with data of type *E and passes the data typed, it replaces the type
+// switch in handlers subscribing to one event type via Event.
+func SubscribeEvent[E interface{}](stream EventSubscribers, f func(e Event, data *E)) *subscription {
+	return stream.Event(func(e Event) {
+		if data, ok := e.Data.(*E); ok {
+			f(e, data)
+		}
+	}, new(E))
+}
+
 // Name subscribe to aggregate name combined with event names. The Name subscriber makes it possible to subscribe to
 // events event if the aggregate and event types are within the current application context.
 func (e *EventStream) Name(f func(e Event), aggregate string, events ...string) *subscription {
 	s := subscription{
-		eventF: f,
+		eventF: withoutContext(f),
 	}
 	s.close = func() {
 		e.lock.Lock()
@@ -210,7 +350,11 @@ func (e *EventStream) Name(f func(e Event), aggregate string, events ...string)
 		s.eventF = nil
 		// clean all evenF functions that are nil
 		for ref, items := range e.names {
-			e.names[ref] = clean(items)
+			if items = clean(items); len(items) == 0 {
+				delete(e.names, ref)
+			} else {
+				e.names[ref] = items
+			}
 		}
 	}
 	e.lock.Lock()
@@ -223,19 +367,95 @@ func (e *EventStream) Name(f func(e Event), aggregate string, events ...string)
 	return &s
 }
 
-// removes subscriptions with event function equal to nil
+// Count returns the number of active subscriptions
+func (e *EventStream) Count() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	// a subscription can be registered on more than one reference
+	subs := make(map[*subscription]struct{})
+	for _, s := range e.all {
+		subs[s] = struct{}{}
+	}
+	for _, s := range e.batches {
+		subs[s] = struct{}{}
+	}
+	for _, m := range []map[aggregateRef][]*subscription{e.aggregateTypes, e.specificAggregates} {
+		for _, items := range m {
+			for _, s := range items {
+				subs[s] = struct{}{}
+			}
+		}
+	}
+	for _, m := range []map[string][]*subscription{e.names} {
+		for _, items := range m {
+			for _, s := range items {
+				subs[s] = struct{}{}
+			}
+		}
+	}
+	for _, items := range e.specificEvents {
+		for _, s := range items {
+			subs[s] = struct{}{}
+		}
+	}
+	return len(subs)
+}
+
+// removes subscriptions with event and batch function equal to nil
 func clean(items []*subscription) []*subscription {
-	for i, s := range items {
-		if s.eventF == nil {
-			items = append(items[:i], items[i+1:]...)
+	res := items[:0]
+	for _, s := range items {
+		if s.eventF != nil || s.batchF != nil {
+			res = append(res, s)
 		}
 	}
-	return items
+	// release the removed subscriptions for the garbage collector
+	for i := len(res); i < len(items); i++ {
+		items[i] = nil
+	}
+	return res
+}
+
+// withoutContext adapts an event function without context to the subscription
+func withoutContext(f func(e Event)) func(ctx context.Context, e Event) {
+	return func(ctx context.Context, e Event) {
+		f(e)
+	}
 }
 
 // publish event to all subscribers
-func publish(items []*subscription, e Event) {
+func (e *EventStream) publish(ctx context.Context, items []*subscription, event Event) {
 	for _, s := range items {
-		s.eventF(e)
+		e.deliver(ctx, s, event)
 	}
 }
+
+// deliver calls the subscriber and recovers if it panics, to not stop the delivery to the other subscribers
+func (e *EventStream) deliver(ctx context.Context, s *subscription, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("%w: %v", ErrSubscriberPanic, r)
+			e.logger.Error("subscriber panic", "aggregate_type", event.AggregateType, "aggregate_id", event.AggregateID, "reason", event.Reason(), "error", err)
+			if e.onError != nil {
+				e.onError(event, err)
+			}
+		}
+	}()
+	s.eventF(ctx, event)
+}
+
+// deliverBatch calls the batch subscriber and recovers if it panics, the error handler gets the first event of
+// the batch
+func (e *EventStream) deliverBatch(ctx context.Context, s *subscription, events []Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("%w: %v", ErrSubscriberPanic, r)
+			e.logger.Error("subscriber panic", "aggregate_type", events[0].AggregateType, "aggregate_id", events[0].AggregateID, "events", len(events), "error", err)
+			if e.onError != nil {
+				e.onError(events[0], err)
+			}
+		}
+	}()
+	s.batchF(ctx, events)
+}
@@ -1,11 +1,14 @@
 package eventsourcing_test
 
 import (
+	"errors"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/suite"
 )
 
 type AnAggregate struct {
@@ -67,6 +70,25 @@ func TestSubSpecificEvent(t *testing.T) {
 	}
 }
 
+func TestSubscribeEvent(t *testing.T) {
+	var miles []int
+	e := eventsourcing.NewEventStream()
+	s := eventsourcing.SubscribeEvent(e, func(e eventsourcing.Event, data *suite.FlightTaken) {
+		miles = append(miles, data.MilesAdded)
+	})
+	defer s.Close()
+	e.Publish(AnAggregate{}.AggregateRoot, []eventsourcing.Event{
+		{Version: 1, Data: &suite.FrequentFlierAccountCreated{OpeningMiles: 100}, AggregateType: "FrequentFlierAccount"},
+		{Version: 2, Data: &suite.FlightTaken{MilesAdded: 10}, AggregateType: "FrequentFlierAccount"},
+		event,
+		{Version: 3, Data: &suite.FlightTaken{MilesAdded: 20}, AggregateType: "FrequentFlierAccount"},
+	})
+
+	if len(miles) != 2 || miles[0] != 10 || miles[1] != 20 {
+		t.Fatalf("expected the FlightTaken events only got %v", miles)
+	}
+}
+
 func TestSubAggregateID(t *testing.T) {
 	// setup aggregates with identifiers
 
@@ -362,3 +384,137 @@ func TestName(t *testing.T) {
 		t.Fatalf("expected the event function to be hit once")
 	}
 }
+
+func TestGlobalOrder(t *testing.T) {
+	e := eventsourcing.NewEventStream()
+	received := make(chan eventsourcing.Event, 3)
+	s := e.GlobalOrder(func(e eventsourcing.Event) {
+		received <- e
+	}, 50*time.Millisecond)
+	defer s.Close()
+
+	first := eventsourcing.Event{EventID: eventsourcing.NewUuid(), Version: 1, Data: &AnEvent{}, AggregateType: "AnAggregate"}
+	time.Sleep(2 * time.Millisecond)
+	second := eventsourcing.Event{EventID: eventsourcing.NewUuid(), Version: 2, Data: &AnEvent{}, AggregateType: "AnAggregate"}
+	time.Sleep(2 * time.Millisecond)
+	third := eventsourcing.Event{EventID: eventsourcing.NewUuid(), Version: 3, Data: &AnEvent{}, AggregateType: "AnAggregate"}
+
+	// publish out of global order as concurrent saves could do
+	e.Publish(AnAggregate{}.AggregateRoot, []eventsourcing.Event{third})
+	e.Publish(AnAggregate{}.AggregateRoot, []eventsourcing.Event{first})
+	e.Publish(AnAggregate{}.AggregateRoot, []eventsourcing.Event{second})
+
+	for _, expected := range []eventsourcing.Event{first, second, third} {
+		select {
+		case got := <-received:
+			if got.EventID != expected.EventID {
+				t.Fatalf("expected event version %d got %d", expected.Version, got.Version)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+	}
+}
+
+func TestGlobalOrderClose(t *testing.T) {
+	e := eventsourcing.NewEventStream()
+	received := make(chan eventsourcing.Event, 1)
+	s := e.GlobalOrder(func(e eventsourcing.Event) {
+		received <- e
+	}, 10*time.Millisecond)
+	e.Publish(AnAggregate{}.AggregateRoot, []eventsourcing.Event{event})
+	s.Close()
+	select {
+	case <-received:
+		t.Fatal("buffered event should not be delivered after close")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCloseRemovesSubscriptions(t *testing.T) {
+	e := eventsourcing.NewEventStream()
+	f := func(e eventsourcing.Event) {}
+	s1 := e.All(f)
+	s2 := e.All(f)
+	s3 := e.Event(f, &AnEvent{}, &AnotherEvent{})
+	s4 := e.Name(f, "AnAggregate", "AnEvent")
+	if e.Count() != 4 {
+		t.Fatalf("expected 4 subscriptions got %d", e.Count())
+	}
+	s1.Close()
+	s2.Close()
+	if e.Count() != 2 {
+		t.Fatalf("expected 2 subscriptions got %d", e.Count())
+	}
+	s3.Close()
+	s4.Close()
+	if e.Count() != 0 {
+		t.Fatalf("expected 0 subscriptions got %d", e.Count())
+	}
+}
+
+func TestSubscriberPanic(t *testing.T) {
+	e := eventsourcing.NewEventStream()
+	var errs []error
+	e.SetErrorHandler(func(event eventsourcing.Event, err error) {
+		errs = append(errs, err)
+	})
+	var first, third bool
+	s1 := e.All(func(e eventsourcing.Event) { first = true })
+	defer s1.Close()
+	s2 := e.All(func(e eventsourcing.Event) { panic("boom") })
+	defer s2.Close()
+	s3 := e.All(func(e eventsourcing.Event) { third = true })
+	defer s3.Close()
+
+	e.Publish(AnAggregate{}.AggregateRoot, []eventsourcing.Event{event})
+	if !first || !third {
+		t.Fatalf("expected the first and third subscriber to run, got %v and %v", first, third)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], eventsourcing.ErrSubscriberPanic) {
+		t.Fatalf("expected one ErrSubscriberPanic got %v", errs)
+	}
+}
+
+// Old_AnAggregate has a name that ends with the name of AnAggregate
+type Old_AnAggregate struct {
+	eventsourcing.AggregateRoot
+}
+
+func (a *Old_AnAggregate) Transition(e eventsourcing.Event) {}
+
+func TestSubAggregateExactName(t *testing.T) {
+	e := eventsourcing.NewEventStream()
+	var received, receivedOld int
+	s := e.Aggregate(func(e eventsourcing.Event) { received++ }, &AnAggregate{})
+	defer s.Close()
+	s2 := e.Aggregate(func(e eventsourcing.Event) { receivedOld++ }, &Old_AnAggregate{})
+	defer s2.Close()
+
+	// the bare root has no package path and is matched on the aggregate name
+	e.Publish(eventsourcing.AggregateRoot{}, []eventsourcing.Event{event})
+	if received != 1 {
+		t.Fatalf("expected 1 event got %d", received)
+	}
+	if receivedOld != 0 {
+		t.Fatalf("expected no events to the aggregate with a name ending with the type got %d", receivedOld)
+	}
+}
+
+func TestSubAggregateBuiltFromHistory(t *testing.T) {
+	e := eventsourcing.NewEventStream()
+	var local, other int
+	s := e.Aggregate(func(e eventsourcing.Event) { local++ }, &FrequentFlierAccount{})
+	defer s.Close()
+	s2 := e.Aggregate(func(e eventsourcing.Event) { other++ }, &suite.FrequentFlierAccount{})
+	defer s2.Close()
+
+	events := []eventsourcing.Event{{AggregateID: eventsourcing.NewUuid(), Version: 1, AggregateType: "FrequentFlierAccount", Data: &suite.FrequentFlierAccountCreated{}}}
+	a := &FrequentFlierAccount{}
+	a.BuildFromHistory(a, events)
+	// the aggregate built from history has the package path and only reaches its own subscribers
+	e.Publish(a.AggregateRoot, events)
+	if local != 1 || other != 0 {
+		t.Fatalf("expected the event to reach the local subscriber only got local %d other %d", local, other)
+	}
+}
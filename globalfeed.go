@@ -0,0 +1,70 @@
+package eventsourcing
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrInvalidCursor returns from GlobalFeed when the cursor is not one returned from it
+var ErrInvalidCursor = errors.New("invalid global feed cursor")
+
+// ErrInvalidLimit returns from GlobalFeed when the limit is not positive
+var ErrInvalidLimit = errors.New("global feed limit must be greater than 0")
+
+// GlobalFeed returns up to limit events in global order after the cursor and the cursor of the last returned
+// event. The cursor is opaque to the caller, pass an empty cursor to start from the beginning of the feed. At the
+// end of the feed no events and an empty cursor is returned, keep the previous cursor to continue from when new
+// events are saved. ErrInvalidLimit is returned if limit is not positive.
+func (r *Repository) GlobalFeed(ctx context.Context, after string, limit int) ([]Event, string, error) {
+	if limit <= 0 {
+		return nil, "", ErrInvalidLimit
+	}
+	store, ok := r.eventStore.(GlobalEventStore)
+	if !ok {
+		return nil, "", ErrGlobalEventsNotSupported
+	}
+	start, err := decodeCursor(after)
+	if err != nil {
+		return nil, "", err
+	}
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+	// fetch one more event as the start position is included in the global events
+	events, err := globalEvents(ctx, store, start, uint64(limit)+1)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(events) > 0 && events[0].EventID == start {
+		events = events[1:]
+	}
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	if len(events) == 0 {
+		return nil, "", nil
+	}
+	return events, encodeCursor(events[len(events)-1].EventID), nil
+}
+
+func encodeCursor(id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString(id.Bytes())
+}
+
+func decodeCursor(cursor string) (uuid.UUID, error) {
+	if cursor == "" {
+		return uuid.Nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return uuid.Nil, ErrInvalidCursor
+	}
+	id, err := uuid.FromBytes(b)
+	if err != nil {
+		return uuid.Nil, ErrInvalidCursor
+	}
+	return id, nil
+}
@@ -0,0 +1,125 @@
+package eventsourcing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/memory"
+)
+
+func TestGlobalFeed(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	var saved []eventsourcing.Event
+	for i := 0; i < 3; i++ {
+		person, err := CreatePerson("kalle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		person.GrowOlder()
+		saved = append(saved, person.Events()...)
+		if err = repo.Save(person); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// first page
+	events, cursor, err := repo.GlobalFeed(context.Background(), "", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 || events[0].EventID != saved[0].EventID {
+		t.Fatalf("expected the first 4 events got %d", len(events))
+	}
+	if cursor == "" {
+		t.Fatal("expected a cursor")
+	}
+
+	// middle page
+	events, cursor, err = repo.GlobalFeed(context.Background(), cursor, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventID != saved[4].EventID {
+		t.Fatalf("expected the fifth event got %v", events)
+	}
+
+	// last page
+	events, cursor, err = repo.GlobalFeed(context.Background(), cursor, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventID != saved[5].EventID {
+		t.Fatalf("expected the last event got %v", events)
+	}
+
+	// end of feed
+	events, next, err := repo.GlobalFeed(context.Background(), cursor, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 || next != "" {
+		t.Fatalf("expected no events and empty cursor got %d events and cursor %q", len(events), next)
+	}
+}
+
+func TestGlobalFeedInvalidCursor(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	_, _, err := repo.GlobalFeed(context.Background(), "not a cursor", 10)
+	if !errors.Is(err, eventsourcing.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor got %v", err)
+	}
+}
+
+func TestGlobalFeedInvalidLimit(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	for _, limit := range []int{0, -1} {
+		_, _, err := repo.GlobalFeed(context.Background(), "", limit)
+		if !errors.Is(err, eventsourcing.ErrInvalidLimit) {
+			t.Fatalf("expected ErrInvalidLimit for limit %d got %v", limit, err)
+		}
+	}
+}
+
+// globalContextStore records the contexts the global events are fetched with
+type globalContextStore struct {
+	*memory.Memory
+	contexts []context.Context
+}
+
+func (c *globalContextStore) GlobalEventsContext(ctx context.Context, start uuid.UUID, count uint64) ([]eventsourcing.Event, error) {
+	c.contexts = append(c.contexts, ctx)
+	return c.GlobalEvents(start, count)
+}
+
+type contextKey struct{}
+
+func TestGlobalEventsWithContext(t *testing.T) {
+	store := &globalContextStore{Memory: memory.Create()}
+	repo := eventsourcing.NewRepository(store, nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), contextKey{}, "request")
+	if _, _, err = repo.GlobalFeed(ctx, "", 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.RebuildProjection(ctx, uuid.Nil, func(e eventsourcing.Event) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.contexts) == 0 {
+		t.Fatal("expected the global events to be fetched with the context")
+	}
+	for _, c := range store.contexts {
+		if c.Value(contextKey{}) != "request" {
+			t.Fatal("expected the context of the caller")
+		}
+	}
+}
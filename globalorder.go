@@ -0,0 +1,97 @@
+package eventsourcing
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// globalOrder buffers events and delivers them in ascending event id order. Event ids are time ordered
+// (UUIDv7) and holds the global order of events.
+type globalOrder struct {
+	lock    sync.Mutex
+	window  time.Duration
+	f       func(e Event)
+	pending []pendingEvent
+	timer   *time.Timer
+	closed  bool
+}
+
+// pendingEvent is an event waiting to be delivered when its deadline has passed
+type pendingEvent struct {
+	event    Event
+	deadline time.Time
+}
+
+func newGlobalOrder(f func(e Event), window time.Duration) *globalOrder {
+	return &globalOrder{
+		f:      f,
+		window: window,
+	}
+}
+
+// add buffers the event sorted by event id
+func (g *globalOrder) add(event Event) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.closed {
+		return
+	}
+	i := sort.Search(len(g.pending), func(i int) bool {
+		return bytes.Compare(g.pending[i].event.EventID[:], event.EventID[:]) > 0
+	})
+	g.pending = append(g.pending, pendingEvent{})
+	copy(g.pending[i+1:], g.pending[i:])
+	g.pending[i] = pendingEvent{event: event, deadline: time.Now().Add(g.window)}
+	if g.timer == nil {
+		g.timer = time.AfterFunc(g.window, g.flush)
+	}
+}
+
+// flush delivers the buffered events up to the last event that has waited the full window. Events before it
+// in the buffer has a lower event id and are delivered even if their own window has not passed.
+func (g *globalOrder) flush() {
+	g.lock.Lock()
+	now := time.Now()
+	last := -1
+	for i, p := range g.pending {
+		if !p.deadline.After(now) {
+			last = i
+		}
+	}
+	batch := make([]pendingEvent, last+1)
+	copy(batch, g.pending[:last+1])
+	g.pending = g.pending[last+1:]
+	g.lock.Unlock()
+
+	// deliver outside the lock to make it possible for the subscriber to save new events
+	for _, p := range batch {
+		g.f(p.event)
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.closed || len(g.pending) == 0 {
+		g.timer = nil
+		return
+	}
+	next := g.pending[0].deadline
+	for _, p := range g.pending {
+		if p.deadline.Before(next) {
+			next = p.deadline
+		}
+	}
+	g.timer = time.AfterFunc(time.Until(next), g.flush)
+}
+
+// stop ends the delivery and drops the buffered events
+func (g *globalOrder) stop() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.closed = true
+	g.pending = nil
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+}
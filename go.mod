@@ -1,5 +1,5 @@
 module github.com/hallgren/eventsourcing
 
-go 1.13
+go 1.18
 
 require github.com/gofrs/uuid v4.2.0+incompatible
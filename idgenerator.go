@@ -1,9 +1,15 @@
 package eventsourcing
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/gofrs/uuid"
 )
 
+// ErrUnsupportedUuidVersion is returned from NewUuidWith when the version is not 4, 6 or 7
+var ErrUnsupportedUuidVersion = errors.New("unsupported uuid version")
+
 // idFunc is a global function that generates aggregate IDs.
 // It could be changed from the outside via the SetIDFunc function.
 var idFunc = NewUuid
@@ -14,6 +20,7 @@ func SetIDFunc(f func() uuid.UUID) {
 	idFunc = f
 }
 
+// NewUuid returns a time ordered UUID (version 7) with millisecond precision, it's the default id function
 func NewUuid() uuid.UUID {
 	id, err := uuid.NewV7(uuid.MillisecondPrecision)
 
@@ -23,3 +30,48 @@ func NewUuid() uuid.UUID {
 
 	return id
 }
+
+// NewUuidV4 returns a random UUID (version 4), the ids are not time ordered
+func NewUuidV4() uuid.UUID {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return emptyAggregateID
+	}
+	return id
+}
+
+// NewUuidV6 returns a time ordered UUID (version 6) based on the same timestamp as version 1
+func NewUuidV6() uuid.UUID {
+	id, err := uuid.NewV6()
+	if err != nil {
+		return emptyAggregateID
+	}
+	return id
+}
+
+// NewUuidWith returns an id function for SetIDFunc that generates UUIDs of the version (4, 6 or 7), the
+// precision is only used by version 7. Other versions return ErrUnsupportedUuidVersion. Version 7 with
+// millisecond precision, the default, gives k-ordered ids.
+func NewUuidWith(version int, precision uuid.Precision) (func() uuid.UUID, error) {
+	switch version {
+	case 4:
+		return NewUuidV4, nil
+	case 6:
+		return NewUuidV6, nil
+	case 7:
+		return func() uuid.UUID {
+			id, err := uuid.NewV7(precision)
+			if err != nil {
+				return emptyAggregateID
+			}
+			return id
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnsupportedUuidVersion, version)
+}
+
+// DeterministicID returns a name based UUID (version 5) from the namespace and name. The same input always gives
+// the same id, which makes it possible to map natural or composite keys (e.g. tenant and entity) onto aggregate ids.
+func DeterministicID(namespace uuid.UUID, name string) uuid.UUID {
+	return uuid.NewV5(namespace, name)
+}
@@ -0,0 +1,79 @@
+package eventsourcing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// errTrailingData is returned from unmarshal when the data holds more than one json value, as json.Unmarshal does
+var errTrailingData = errors.New("json: invalid data after top-level value")
+
+// JSONOption configures the serializer returned from NewJSONSerializer
+type JSONOption func(c *jsonConfig)
+
+type jsonConfig struct {
+	escapeHTML            bool
+	useNumber             bool
+	disallowUnknownFields bool
+}
+
+// JSONEscapeHTML sets if &, < and > should be escaped in strings, encoding/json escapes them by default
+func JSONEscapeHTML(escape bool) JSONOption {
+	return func(c *jsonConfig) {
+		c.escapeHTML = escape
+	}
+}
+
+// JSONUseNumber unmarshal numbers into interface{} values (e.g. in the metadata) as json.Number instead of float64
+func JSONUseNumber() JSONOption {
+	return func(c *jsonConfig) {
+		c.useNumber = true
+	}
+}
+
+// JSONDisallowUnknownFields makes unmarshal fail when the data has fields that are not in the struct
+func JSONDisallowUnknownFields() JSONOption {
+	return func(c *jsonConfig) {
+		c.disallowUnknownFields = true
+	}
+}
+
+// NewJSONSerializer returns a serializer based on encoding/json configured by the options. With no options it
+// behaves as NewSerializer(json.Marshal, json.Unmarshal). The output is deterministic, struct fields are written
+// in declaration order and map keys sorted, which makes it usable for hashing payloads.
+func NewJSONSerializer(options ...JSONOption) *Serializer {
+	c := jsonConfig{escapeHTML: true}
+	for _, option := range options {
+		option(&c)
+	}
+	marshal := func(v interface{}) ([]byte, error) {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(c.escapeHTML)
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		// Encode adds a newline that json.Marshal does not
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+	}
+	unmarshal := func(data []byte, v interface{}) error {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if c.useNumber {
+			dec.UseNumber()
+		}
+		if c.disallowUnknownFields {
+			dec.DisallowUnknownFields()
+		}
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+		// the decoder stops after the first value, json.Unmarshal fails on trailing data
+		if _, err := dec.Token(); err != io.EOF {
+			return errTrailingData
+		}
+		return nil
+	}
+	return NewSerializer(marshal, unmarshal)
+}
@@ -0,0 +1,62 @@
+package eventsourcing
+
+import (
+	"crypto/rand"
+	"errors"
+	"sync"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrKeyShredded returns when the encryption key of an aggregate has been deleted and its events can't be read
+var ErrKeyShredded = errors.New("encryption key has been shredded")
+
+// KeyStore is a KeyProvider with one key per aggregate id that can be deleted to make the events of the
+// aggregate unreadable without deleting them (crypto-shredding).
+type KeyStore interface {
+	KeyProvider
+	DeleteKey(aggregateID uuid.UUID) error
+}
+
+// MemoryKeyStore is an in memory KeyStore, keys are created with the first call to Key
+type MemoryKeyStore struct {
+	lock     sync.Mutex
+	keys     map[uuid.UUID][]byte
+	shredded map[uuid.UUID]struct{}
+}
+
+// NewMemoryKeyStore returns an empty in memory key store
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{
+		keys:     make(map[uuid.UUID][]byte),
+		shredded: make(map[uuid.UUID]struct{}),
+	}
+}
+
+// Key returns the key of the aggregate and creates it if it's not present. ErrKeyShredded returns if the key
+// has been deleted.
+func (m *MemoryKeyStore) Key(aggregateType string, aggregateID uuid.UUID) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.shredded[aggregateID]; ok {
+		return nil, ErrKeyShredded
+	}
+	key, ok := m.keys[aggregateID]
+	if !ok {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		m.keys[aggregateID] = key
+	}
+	return key, nil
+}
+
+// DeleteKey deletes the key of the aggregate
+func (m *MemoryKeyStore) DeleteKey(aggregateID uuid.UUID) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.keys, aggregateID)
+	m.shredded[aggregateID] = struct{}{}
+	return nil
+}
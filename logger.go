@@ -0,0 +1,25 @@
+package eventsourcing
+
+// Logger is the logging interface used by the repository, event stream and event stores. kv holds key value
+// pairs, it's made to be easy to adapt to structured loggers like slog, zap or logrus.
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// NoopLogger discards all log messages, it's the default logger
+type NoopLogger struct{}
+
+// Debug does nothing
+func (NoopLogger) Debug(msg string, kv ...interface{}) {}
+
+// Info does nothing
+func (NoopLogger) Info(msg string, kv ...interface{}) {}
+
+// Warn does nothing
+func (NoopLogger) Warn(msg string, kv ...interface{}) {}
+
+// Error does nothing
+func (NoopLogger) Error(msg string, kv ...interface{}) {}
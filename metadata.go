@@ -0,0 +1,48 @@
+package eventsourcing
+
+import "context"
+
+// metadataKey is the context key of the ambient metadata
+type metadataKey struct{}
+
+// WithMetadata returns a context that holds metadata to be set on events tracked with TrackChangeCtx, e.g. the
+// correlation id or the user of a request. Metadata already on the context is kept, the new metadata wins on key
+// conflicts.
+func WithMetadata(ctx context.Context, metadata map[string]interface{}) context.Context {
+	merged := make(map[string]interface{})
+	for k, v := range MetadataFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFromContext returns the ambient metadata of the context, nil if there is none
+func MetadataFromContext(ctx context.Context) map[string]interface{} {
+	m, _ := ctx.Value(metadataKey{}).(map[string]interface{})
+	return m
+}
+
+// TrackChangeCtx is TrackChangeChecked with the ambient metadata of the context set on the event
+func (ar *AggregateRoot) TrackChangeCtx(ctx context.Context, a Aggregate, data interface{}) error {
+	return ar.TrackChangeWithMetadataCtx(ctx, a, data, nil)
+}
+
+// TrackChangeWithMetadataCtx is TrackChangeWithMetadataChecked with the ambient metadata of the context merged with the
+// metadata, the metadata wins on key conflicts.
+func (ar *AggregateRoot) TrackChangeWithMetadataCtx(ctx context.Context, a Aggregate, data interface{}, metadata map[string]interface{}) error {
+	ambient := MetadataFromContext(ctx)
+	if len(ambient) == 0 {
+		return ar.TrackChangeWithMetadataChecked(a, data, metadata)
+	}
+	merged := make(map[string]interface{}, len(ambient)+len(metadata))
+	for k, v := range ambient {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	return ar.TrackChangeWithMetadataChecked(a, data, merged)
+}
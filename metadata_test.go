@@ -0,0 +1,33 @@
+package eventsourcing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hallgren/eventsourcing"
+)
+
+func TestTrackChangeCtx(t *testing.T) {
+	ctx := eventsourcing.WithMetadata(context.Background(), map[string]interface{}{"correlation_id": "abc", "user": "kalle"})
+	ctx = eventsourcing.WithMetadata(ctx, map[string]interface{}{"tenant": "t1"})
+
+	person := Person{}
+	err := person.TrackChangeCtx(ctx, &person, &Born{Name: "kalle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = person.TrackChangeWithMetadataCtx(ctx, &person, &AgedOneYear{}, map[string]interface{}{"user": "anka"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := person.Events()
+	if events[0].Metadata["correlation_id"] != "abc" || events[0].Metadata["tenant"] != "t1" {
+		t.Fatalf("expected the ambient metadata on the event got %v", events[0].Metadata)
+	}
+	if events[1].Metadata["user"] != "anka" {
+		t.Fatalf("expected the per call metadata to win got %v", events[1].Metadata["user"])
+	}
+	if events[1].Metadata["correlation_id"] != "abc" {
+		t.Fatalf("expected the ambient metadata to be merged got %v", events[1].Metadata)
+	}
+}
@@ -0,0 +1,118 @@
+package eventsourcing
+
+import (
+	"context"
+	"time"
+
+	"github.com/hallgren/eventsourcing/backoff"
+)
+
+// Poller polls the global events of an event store and publishes the new events on an event stream. It makes
+// subscribers of one process receive the events saved by other processes sharing the event store. The position is
+// saved in a checkpoint store after each published event and a restarted poller continues after it.
+//
+// The position is the event id, a UUIDv7 generated when the change is tracked and not when it's committed. An event
+// with a lower id than the checkpoint that commits after the poll that moved the checkpoint past it is never
+// published, e.g. when saves of two processes overlap.
+type Poller struct {
+	store       GlobalEventStore
+	stream      *EventStream
+	checkpoints CheckpointStore
+	name        string
+	interval    time.Duration
+	batchSize   uint64
+	logger      Logger
+	// backoff gives the delay after failed polls, nil waits the interval
+	backoff backoff.Backoff
+}
+
+// NewPoller returns a poller that publishes the events of the store on the stream, the checkpoint is saved under
+// name in the checkpoint store. It polls every second with a batch size of 100.
+func NewPoller(store GlobalEventStore, stream *EventStream, checkpoints CheckpointStore, name string) *Poller {
+	return &Poller{
+		store:       store,
+		stream:      stream,
+		checkpoints: checkpoints,
+		name:        name,
+		interval:    time.Second,
+		batchSize:   100,
+		logger:      NoopLogger{},
+	}
+}
+
+// SetInterval sets the time between polls when there are no new events
+func (p *Poller) SetInterval(interval time.Duration) {
+	p.interval = interval
+}
+
+// SetBackoff sets the delay after failed polls, the attempt passed to the backoff is the number of polls that has
+// failed in a row. Without a backoff a failed poll is retried after the interval.
+func (p *Poller) SetBackoff(b backoff.Backoff) {
+	p.backoff = b
+}
+
+// SetBatchSize sets the max number of new events fetched in one call to GlobalEvents, one more event is requested
+// as the checkpoint event is included in the global events
+func (p *Poller) SetBatchSize(size uint64) {
+	p.batchSize = size
+}
+
+// SetLogger sets the logger
+func (p *Poller) SetLogger(logger Logger) {
+	p.logger = logger
+}
+
+// Run polls until the context is done and returns the context error. Failed polls are logged and retried on the
+// next interval, an error saving the checkpoint stops the poller as the position can't be trusted.
+func (p *Poller) Run(ctx context.Context) error {
+	checkpoint, err := p.checkpoints.Load(p.name)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay := p.interval
+		for {
+			events, err := globalEvents(ctx, p.store, checkpoint, p.batchSize+1)
+			if err != nil && ctx.Err() != nil {
+				return ctx.Err()
+			} else if err != nil {
+				p.logger.Error("poll global events", "poller", p.name, "error", err)
+				failures++
+				if p.backoff != nil {
+					delay = p.backoff.Next(failures)
+				}
+				break
+			}
+			failures = 0
+			published := 0
+			for _, event := range events {
+				// the start position is included in the global events
+				if event.EventID == checkpoint {
+					continue
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				p.stream.PublishWithContext(ctx, rootOf(event), []Event{event})
+				checkpoint = event.EventID
+				if err = p.checkpoints.Save(p.name, checkpoint); err != nil {
+					return err
+				}
+				published++
+			}
+			// poll again right away if the batch was full
+			if published == 0 || uint64(len(events)) < p.batchSize+1 {
+				break
+			}
+		}
+		timer.Reset(delay)
+	}
+}
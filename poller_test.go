@@ -0,0 +1,178 @@
+package eventsourcing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hallgren/eventsourcing"
+)
+
+// growingStore is a global event store where events are added between the polls
+type growingStore struct {
+	lock   sync.Mutex
+	events []eventsourcing.Event
+}
+
+func (g *growingStore) add(count int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for i := 0; i < count; i++ {
+		g.events = append(g.events, eventsourcing.Event{
+			EventID:       eventsourcing.NewUuid(),
+			AggregateID:   eventsourcing.NewUuid(),
+			Version:       1,
+			AggregateType: "Person",
+			Data:          &Born{Name: "kalle"},
+		})
+	}
+}
+
+func (g *growingStore) GlobalEvents(start uuid.UUID, count uint64) ([]eventsourcing.Event, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	var events []eventsourcing.Event
+	for _, e := range g.events {
+		if bytes.Compare(e.EventID[:], start[:]) >= 0 && uint64(len(events)) < count {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// receive waits for count events on the channel
+func receive(t *testing.T, c chan eventsourcing.Event, count int) []eventsourcing.Event {
+	var events []eventsourcing.Event
+	for len(events) < count {
+		select {
+		case e := <-c:
+			events = append(events, e)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d events got %d", count, len(events))
+		}
+	}
+	return events
+}
+
+// runPoller starts a poller and returns a function that stops it
+func runPoller(t *testing.T, store *growingStore, checkpoints eventsourcing.CheckpointStore, c chan eventsourcing.Event, batchSize uint64) func() {
+	stream := eventsourcing.NewEventStream()
+	stream.All(func(e eventsourcing.Event) { c <- e })
+	poller := eventsourcing.NewPoller(store, stream, checkpoints, "test")
+	poller.SetInterval(time.Millisecond)
+	poller.SetBatchSize(batchSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- poller.Run(ctx) }()
+	return func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled got %v", err)
+		}
+	}
+}
+
+func TestPoller(t *testing.T) {
+	store := &growingStore{}
+	checkpoints := eventsourcing.NewMemoryCheckpointStore()
+	c := make(chan eventsourcing.Event, 10)
+	store.add(3)
+	stop := runPoller(t, store, checkpoints, c, 2)
+	events := receive(t, c, 3)
+	store.add(2)
+	events = append(events, receive(t, c, 2)...)
+	stop()
+
+	// a restarted poller continues after the checkpoint
+	stop = runPoller(t, store, checkpoints, c, 2)
+	store.add(1)
+	events = append(events, receive(t, c, 1)...)
+	stop()
+
+	select {
+	case e := <-c:
+		t.Fatalf("unexpected event %s", e.EventID)
+	default:
+	}
+	for i, e := range events {
+		if e.EventID != store.events[i].EventID {
+			t.Fatalf("expected event %d to be %s got %s", i, store.events[i].EventID, e.EventID)
+		}
+	}
+}
+
+// flakyStore fails the first failures polls
+type flakyStore struct {
+	growingStore
+	failures int
+}
+
+func (f *flakyStore) GlobalEvents(start uuid.UUID, count uint64) ([]eventsourcing.Event, error) {
+	f.lock.Lock()
+	failing := f.failures > 0
+	f.failures--
+	f.lock.Unlock()
+	if failing {
+		return nil, errors.New("connection reset")
+	}
+	return f.growingStore.GlobalEvents(start, count)
+}
+
+// recordingBackoff records the attempts it's called with
+type recordingBackoff struct {
+	lock     sync.Mutex
+	attempts []int
+}
+
+func (r *recordingBackoff) Next(attempt int) time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.attempts = append(r.attempts, attempt)
+	return time.Millisecond
+}
+
+func TestPollerBackoff(t *testing.T) {
+	store := &flakyStore{failures: 3}
+	store.add(1)
+	c := make(chan eventsourcing.Event, 10)
+	stream := eventsourcing.NewEventStream()
+	stream.All(func(e eventsourcing.Event) { c <- e })
+	poller := eventsourcing.NewPoller(store, stream, eventsourcing.NewMemoryCheckpointStore(), "test")
+	// the poll after the failures has to come from the backoff
+	poller.SetInterval(time.Hour)
+	b := &recordingBackoff{}
+	poller.SetBackoff(b)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- poller.Run(ctx) }()
+	receive(t, c, 1)
+	cancel()
+	<-done
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.attempts) != 3 || b.attempts[0] != 1 || b.attempts[2] != 3 {
+		t.Fatalf("expected attempts 1 to 3 got %v", b.attempts)
+	}
+}
+
+func TestPollerBatchSizeOne(t *testing.T) {
+	store := &growingStore{}
+	checkpoints := eventsourcing.NewMemoryCheckpointStore()
+	c := make(chan eventsourcing.Event, 10)
+	store.add(3)
+
+	// the checkpoint event doesn't take the place of the new event
+	stop := runPoller(t, store, checkpoints, c, 1)
+	events := receive(t, c, 3)
+	stop()
+	for i, e := range events {
+		if e.EventID != store.events[i].EventID {
+			t.Fatalf("expected event %d to be %s got %s", i, store.events[i].EventID, e.EventID)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package eventsourcing
+
+import (
+	"reflect"
+	"sync"
+)
+
+// registry holds registered aggregate types, it's used to skip reflection on the name of the aggregate
+// and to create aggregates from their stored type name.
+var registry = struct {
+	lock       sync.RWMutex
+	names      map[reflect.Type]string
+	factories  map[string]func() Aggregate
+	validators map[validatorKey][]func(data interface{}) error
+}{
+	names:      make(map[reflect.Type]string),
+	factories:  make(map[string]func() Aggregate),
+	validators: make(map[validatorKey][]func(data interface{}) error),
+}
+
+// validatorKey identifies the validators of an event reason on an aggregate type
+type validatorKey struct {
+	aggregateType string
+	reason        string
+}
+
+// RegisterAggregate registers an aggregate type under name and caches it for the aggregate type the factory returns.
+// The name is the aggregate type stored on the events instead of the struct name, it's used on save, get,
+// serializer registration and subscriptions. Register the name before the aggregate is registered in the
+// serializer or subscribed to, see RegisterAggregateName for renamed aggregates.
+func RegisterAggregate(name string, factory func() Aggregate) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.names[reflect.TypeOf(factory())] = name
+	registry.factories[name] = factory
+}
+
+// RegisterAggregateName sets the aggregate type stored on the events of the aggregate to storedName instead of the
+// struct name. It's RegisterAggregate with a factory that creates an empty aggregate of the type of a, a struct that
+// is renamed or moved to another package keeps loading its historical events by registering the old name.
+func RegisterAggregateName(a Aggregate, storedName string) {
+	t := reflect.TypeOf(a).Elem()
+	RegisterAggregate(storedName, func() Aggregate {
+		return reflect.New(t).Interface().(Aggregate)
+	})
+}
+
+// NewAggregate creates a new aggregate from the registered name
+func NewAggregate(name string) (Aggregate, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	f, ok := registry.factories[name]
+	if !ok {
+		return nil, false
+	}
+	return f(), true
+}
+
+// aggregateName returns the aggregate type name from the registry and falls back to reflection
+// if the aggregate is not registered.
+func aggregateName(i interface{}) string {
+	t := reflect.TypeOf(i)
+	registry.lock.RLock()
+	name, ok := registry.names[t]
+	registry.lock.RUnlock()
+	if ok {
+		return name
+	}
+	return t.Elem().Name()
+}
+
+// RegisterValidator registers a function that validates the data of events with the reason tracked on aggregates
+// of aggregateType. The validators are run in TrackChangeChecked and TrackChangeWithMetadataChecked before the
+// event is applied on the aggregate, an event that fails validation is never tracked. Events tracked with
+// TrackChange are validated by Repository.Save, an event that fails validation is not persisted.
+func RegisterValidator(aggregateType, reason string, fn func(data interface{}) error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	key := validatorKey{aggregateType: aggregateType, reason: reason}
+	registry.validators[key] = append(registry.validators[key], fn)
+}
+
+// validateData runs the validators registered for the aggregate type and the reason of the data
+func validateData(aggregateType string, data interface{}) error {
+	t := reflect.TypeOf(data)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil
+	}
+	key := validatorKey{aggregateType: aggregateType, reason: t.Elem().Name()}
+	registry.lock.RLock()
+	validators := registry.validators[key]
+	registry.lock.RUnlock()
+	for _, validator := range validators {
+		if err := validator(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
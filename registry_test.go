@@ -0,0 +1,195 @@
+package eventsourcing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/memory"
+)
+
+// RegisteredPerson is a Person aggregate that is registered in the aggregate registry
+type RegisteredPerson struct {
+	Person
+}
+
+func init() {
+	eventsourcing.RegisterAggregate("RegisteredPerson", func() eventsourcing.Aggregate { return &RegisteredPerson{} })
+}
+
+func TestNewAggregate(t *testing.T) {
+	a, ok := eventsourcing.NewAggregate("RegisteredPerson")
+	if !ok {
+		t.Fatal("expected RegisteredPerson to be registered")
+	}
+	if _, ok := a.(*RegisteredPerson); !ok {
+		t.Fatalf("expected *RegisteredPerson got %T", a)
+	}
+	_, ok = eventsourcing.NewAggregate("NotRegistered")
+	if ok {
+		t.Fatal("expected NotRegistered to not be registered")
+	}
+}
+
+func TestRegisteredAggregateType(t *testing.T) {
+	p := RegisteredPerson{}
+	p.TrackChange(&p, &Born{Name: "kalle"})
+	if p.Events()[0].AggregateType != "RegisteredPerson" {
+		t.Fatalf("expected aggregate type RegisteredPerson got %s", p.Events()[0].AggregateType)
+	}
+}
+
+// RenamedPerson is an aggregate after a rename, it keeps the stored name LegacyPerson. The name is only used by
+// this test to not change how the Person aggregate of the other tests is registered.
+type RenamedPerson struct {
+	eventsourcing.AggregateRoot
+	Name string
+	Age  int
+}
+
+func (person *RenamedPerson) Transition(event eventsourcing.Event) {
+	switch e := event.Data.(type) {
+	case *Born:
+		person.Name = e.Name
+	case *AgedOneYear:
+		person.Age++
+	}
+}
+
+func TestRegisterAggregateName(t *testing.T) {
+	eventsourcing.RegisterAggregateName(&RenamedPerson{}, "LegacyPerson")
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+
+	// the events stored before the rename
+	id := eventsourcing.NewUuid()
+	err := repo.Append(context.Background(), id, "LegacyPerson", &Born{Name: "kalle"}, &AgedOneYear{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := RenamedPerson{}
+	if err = repo.Get(id, &renamed); err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "kalle" || renamed.Age != 1 {
+		t.Fatalf("expected kalle aged 1 got %q %d", renamed.Name, renamed.Age)
+	}
+	renamed.TrackChange(&renamed, &AgedOneYear{})
+	if renamed.Events()[0].AggregateType != "LegacyPerson" {
+		t.Fatalf("expected the stored name LegacyPerson got %s", renamed.Events()[0].AggregateType)
+	}
+	if err = repo.Save(&renamed); err != nil {
+		t.Fatal(err)
+	}
+	// the renamed aggregate is built from the stored name
+	a, ok := eventsourcing.NewAggregate("LegacyPerson")
+	if !ok {
+		t.Fatal("expected LegacyPerson to be registered")
+	}
+	if _, ok := a.(*RenamedPerson); !ok {
+		t.Fatalf("expected *RenamedPerson got %T", a)
+	}
+	if err = repo.Get(id, a); err != nil {
+		t.Fatal(err)
+	}
+	if a.(*RenamedPerson).Age != 2 {
+		t.Fatalf("expected the event saved on the renamed aggregate got age %d", a.(*RenamedPerson).Age)
+	}
+}
+
+func BenchmarkTrackChangeReflection(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := Person{}
+		p.TrackChange(&p, &Born{Name: "kalle"})
+	}
+}
+
+func BenchmarkTrackChangeRegistered(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := RegisteredPerson{}
+		p.TrackChange(&p, &Born{Name: "kalle"})
+	}
+}
+
+// MilesAdded is an event that is validated to not hold negative miles
+type MilesAdded struct {
+	Miles int
+}
+
+var errNegativeMiles = errors.New("miles must be non-negative")
+
+func init() {
+	eventsourcing.RegisterValidator("Person", "MilesAdded", func(data interface{}) error {
+		if data.(*MilesAdded).Miles < 0 {
+			return errNegativeMiles
+		}
+		return nil
+	})
+}
+
+func TestValidatorRejectsEvent(t *testing.T) {
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = person.TrackChangeChecked(person, &MilesAdded{Miles: -1})
+	if !errors.Is(err, errNegativeMiles) {
+		t.Fatalf("expected errNegativeMiles got %v", err)
+	}
+	if len(person.Events()) != 1 {
+		t.Fatalf("rejected event should not be tracked, got %d events", len(person.Events()))
+	}
+	if person.Version() != 1 {
+		t.Fatalf("expected version 1 got %d", person.Version())
+	}
+}
+
+func TestValidatorAcceptsEvent(t *testing.T) {
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = person.TrackChangeChecked(person, &MilesAdded{Miles: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(person.Events()) != 2 {
+		t.Fatalf("expected 2 events got %d", len(person.Events()))
+	}
+}
+
+func TestValidatorScopedToAggregateType(t *testing.T) {
+	account := Account{Balance: 10}
+	err := account.TrackChangeChecked(&account, &MilesAdded{Miles: -1})
+	if err != nil {
+		t.Fatalf("expected the Person validator to not run on Account got %v", err)
+	}
+}
+
+func TestTrackChangeValidatedOnSave(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// TrackChange tracks the event, the validators run when it's saved
+	person.TrackChange(person, &MilesAdded{Miles: -1})
+	if len(person.Events()) != 2 {
+		t.Fatalf("expected 2 events got %d", len(person.Events()))
+	}
+	err = repo.Save(person)
+	if !errors.Is(err, errNegativeMiles) {
+		t.Fatalf("expected errNegativeMiles got %v", err)
+	}
+	err = repo.SaveAll(person)
+	if !errors.Is(err, errNegativeMiles) {
+		t.Fatalf("expected errNegativeMiles from SaveAll got %v", err)
+	}
+	err = repo.Get(person.ID(), &Person{})
+	if !errors.Is(err, eventsourcing.ErrAggregateNotFound) {
+		t.Fatalf("expected nothing to be saved got %v", err)
+	}
+}
@@ -2,8 +2,13 @@ package eventsourcing
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/gofrs/uuid"
 )
@@ -14,18 +19,98 @@ type EventIterator interface {
 	Close()
 }
 
-// EventStore interface expose the methods an event store must uphold
+// EventStore interface expose the methods an event store must uphold. Save gets a copy of the aggregate events
+// and the repository never reads it back, stores should not rely on modifying the events to return values.
 type EventStore interface {
 	Save(events []Event) error
 	Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion Version) (EventIterator, error)
 }
 
+// LatestVersionReader is implemented by event stores that can return the version of the last stored event of an
+// aggregate without fetching the events, 0 if the aggregate has no events
+type LatestVersionReader interface {
+	LatestVersion(ctx context.Context, id uuid.UUID, aggregateType string) (Version, error)
+}
+
+// ContextSaver is implemented by event stores that can save with a context, the repository then passes the
+// context of SaveWithContext and a cancelled context or passed deadline aborts the save
+type ContextSaver interface {
+	SaveContext(ctx context.Context, events []Event) error
+}
+
+// MultiSaver is implemented by event stores that can save the events of several aggregates atomically, each slice
+// holds the events of one aggregate
+type MultiSaver interface {
+	SaveMany(ctx context.Context, events [][]Event) error
+}
+
+// EventCounter is implemented by event stores that can count the events of an aggregate without fetching them
+type EventCounter interface {
+	EventCount(ctx context.Context, id uuid.UUID, aggregateType string) (int, error)
+}
+
+// ReasonCounter is implemented by event stores that can count the events of an aggregate per reason without
+// fetching them
+type ReasonCounter interface {
+	CountByReason(ctx context.Context, id uuid.UUID, aggregateType string) (map[string]int, error)
+}
+
+// GlobalEventStore is implemented by event stores that can return events in global order. The event id is time
+// ordered and is the global position of the event, start is included in the returned events.
+type GlobalEventStore interface {
+	GlobalEvents(start uuid.UUID, count uint64) ([]Event, error)
+}
+
+// GlobalEventContextStore is implemented by global event stores that can abort the query when the context is
+// canceled, RebuildProjection, GlobalFeed and the Poller use it instead of GlobalEvents when it's implemented
+type GlobalEventContextStore interface {
+	GlobalEventsContext(ctx context.Context, start uuid.UUID, count uint64) ([]Event, error)
+}
+
+// globalEvents fetches the global events with the context if the store implements GlobalEventContextStore
+func globalEvents(ctx context.Context, store GlobalEventStore, start uuid.UUID, count uint64) ([]Event, error) {
+	if c, ok := store.(GlobalEventContextStore); ok {
+		return c.GlobalEventsContext(ctx, start, count)
+	}
+	return store.GlobalEvents(start, count)
+}
+
+// GlobalCounter is implemented by event stores that can count all events without fetching them, e.g. to compute
+// the progress of a projection rebuild
+type GlobalCounter interface {
+	GlobalCount(ctx context.Context) (uint64, error)
+}
+
+// AggregateTypeChecker is implemented by event stores that know which aggregate types they can read, e.g. the
+// types registered in their serializer
+type AggregateTypeChecker interface {
+	AggregateRegistered(aggregateType string) bool
+}
+
 // SnapshotStore interface expose the methods an snapshot store must uphold
 type SnapshotStore interface {
 	Save(s Snapshot) error
 	Get(ctx context.Context, id uuid.UUID, typ string) (Snapshot, error)
 }
 
+// SnapshotDeleter is implemented by snapshot stores that can remove the snapshot of an aggregate, deleting a
+// snapshot that does not exist is not an error
+type SnapshotDeleter interface {
+	Delete(ctx context.Context, id uuid.UUID, typ string) error
+}
+
+// SnapshotHistoryStore is implemented by snapshot stores that keep older snapshots of an aggregate. GetAt returns
+// the newest snapshot with a version at or below version, ErrSnapshotNotFound if there is none.
+type SnapshotHistoryStore interface {
+	GetAt(ctx context.Context, id uuid.UUID, typ string, version Version) (Snapshot, error)
+}
+
+// BatchSnapshotStore is implemented by snapshot stores that can fetch the snapshots of many aggregates in one
+// call. The returned map only holds the found snapshots.
+type BatchSnapshotStore interface {
+	GetMany(ctx context.Context, ids []uuid.UUID, typ string) (map[uuid.UUID]Snapshot, error)
+}
+
 // Aggregate interface to use the aggregate root specific methods
 type Aggregate interface {
 	Root() *AggregateRoot
@@ -40,26 +125,157 @@ type EventSubscribers interface {
 	Name(f func(e Event), aggregate string, events ...string) *subscription
 }
 
+// ContextSubscriber is implemented by EventSubscribers that pass the context of the save to the subscribers
+type ContextSubscriber interface {
+	AllWithContext(f func(ctx context.Context, e Event)) *subscription
+}
+
+// BatchSubscriber is implemented by EventSubscribers that can deliver the events of a save in one call
+type BatchSubscriber interface {
+	AllBatch(f func(events []Event)) *subscription
+}
+
+// GlobalOrderSubscriber is implemented by EventSubscribers that can deliver events in ascending global order
+type GlobalOrderSubscriber interface {
+	GlobalOrder(f func(e Event), window time.Duration) *subscription
+}
+
+// SubscriptionCounter is implemented by EventSubscribers that can count their active subscriptions
+type SubscriptionCounter interface {
+	Count() int
+}
+
 // ErrSnapshotNotFound returns if snapshot not found
 var ErrSnapshotNotFound = errors.New("snapshot not found")
 
 // ErrAggregateNotFound returns if snapshot or event not found for aggregate
 var ErrAggregateNotFound = errors.New("aggregate not found")
 
+// ErrConcurrency returns from Save when the currently saved version of the aggregate differs from the new ones
+var ErrConcurrency = errors.New("concurrency error")
+
+// ErrInvalidVersion returns from Save when the first event has version 0, the first event of an aggregate has version 1.
+// GetVersion returns it for version 0.
+var ErrInvalidVersion = errors.New("event version must be greater than 0")
+
+// ErrEventMultipleAggregates returns from Save when the events holds different aggregate ids
+var ErrEventMultipleAggregates = errors.New("events holds events for more than one aggregate")
+
+// ErrEventMultipleAggregateTypes returns from Save when the events holds different aggregate types
+var ErrEventMultipleAggregateTypes = errors.New("events holds events for more than one aggregate type")
+
+// ErrReasonMissing returns from Save when the reason is not present in the events
+var ErrReasonMissing = errors.New("event holds no reason")
+
+// ErrNoSnapshotStore returns from the snapshot methods of the repository when it's created without a snapshot handler
+var ErrNoSnapshotStore = errors.New("no snapshot store has been initialized")
+
+// ErrSnapshotDeleteNotSupported returns from DeleteSnapshot when the snapshot store does not implement SnapshotDeleter
+var ErrSnapshotDeleteNotSupported = errors.New("snapshot store does not support delete")
+
+// ErrUnregisteredAggregate returns from Get when the event store can't read events of the aggregate type as it's
+// not registered, e.g. in the serializer of the event store
+var ErrUnregisteredAggregate = errors.New("aggregate type is not registered")
+
+// ErrStreamTooLong returns from Save when the aggregate would have more events after its last snapshot than the
+// max stream length
+var ErrStreamTooLong = errors.New("aggregate stream is too long, save a snapshot")
+
+// ErrGlobalEventsNotSupported returns if the event store can't return events in global order
+var ErrGlobalEventsNotSupported = errors.New("event store does not support global events")
+
+// ErrGlobalCountNotSupported returns from GlobalCount if the event store can't count all events
+var ErrGlobalCountNotSupported = errors.New("event store does not support global count")
+
+// buildBatchSize is the number of events applied per BuildFromHistory call when an aggregate is built
+const buildBatchSize = 256
+
+// rebuildBatchSize is the number of events fetched from the global event feed per call in RebuildProjection
+const rebuildBatchSize = 100
+
 // Repository is the returned instance from the factory function
 type Repository struct {
 	eventStream *EventStream
 	eventStore  EventStore
 	snapshot    *SnapshotHandler
+	logger      Logger
+	// maxStreamLength is the max number of events after the last snapshot of an aggregate, 0 means no limit
+	maxStreamLength Version
+	// conflictResolver resolves ErrConcurrency on save, nil is FailOnConflict
+	conflictResolver ConflictResolver
+	// compaction enables Compact
+	compaction bool
+	// progress is called every progressInterval events applied in RebuildProjection when set
+	progress         ProgressReporter
+	progressInterval int
+	// snapshotAfter is the min duration between the snapshots Save takes of an aggregate, 0 is off
+	snapshotAfter time.Duration
+	// snapshotTimes holds the time of the last snapshot per aggregate when snapshotAfter is set
+	snapshotTimes map[string]time.Time
+	snapshotLock  sync.Mutex
+	// onApply is called with each event applied when an aggregate is built from the event store
+	onApply func(event Event)
 }
 
+// ProgressReporter is called with the number of events processed so far and the event id of the last one
+type ProgressReporter func(processed int, checkpoint uuid.UUID)
+
 // NewRepository factory function
 func NewRepository(eventStore EventStore, snapshot *SnapshotHandler) *Repository {
 	return &Repository{
 		eventStore:  eventStore,
 		snapshot:    snapshot,
 		eventStream: NewEventStream(),
+		logger:      NoopLogger{},
+	}
+}
+
+// SetLogger sets the logger used by the repository, its event stream and snapshot handler
+func (r *Repository) SetLogger(logger Logger) {
+	r.logger = logger
+	r.eventStream.SetLogger(logger)
+	if r.snapshot != nil {
+		r.snapshot.SetLogger(logger)
+	}
+}
+
+// SetOnApply sets a function called with each event applied on an aggregate built from the event store, e.g. to
+// log, trace or measure the replay. Events restored from a snapshot are not passed to it, and it's not called on
+// save, use the subscriptions for that.
+func (r *Repository) SetOnApply(f func(event Event)) {
+	r.onApply = f
+}
+
+// SetSubscriberErrorHandler sets the function called with errors from subscribers, e.g. a recovered panic
+func (r *Repository) SetSubscriberErrorHandler(f func(event Event, err error)) {
+	r.eventStream.SetErrorHandler(f)
+}
+
+// SetMaxStreamLength makes Save return ErrStreamTooLong if the aggregate would have more than n events after
+// the snapshot it was loaded from or last saved to. It protects against unbounded streams that are never
+// snapshotted. Zero removes the limit.
+func (r *Repository) SetMaxStreamLength(n Version) {
+	r.maxStreamLength = n
+}
+
+// SetProgressReporter makes RebuildProjection call f after every n applied events, the total number of events to
+// compute a percentage from is returned by GlobalCount. A nil f or n below 1 removes the reporter.
+func (r *Repository) SetProgressReporter(n int, f ProgressReporter) {
+	if n < 1 {
+		f = nil
 	}
+	r.progress = f
+	r.progressInterval = n
+}
+
+// GlobalCount returns the number of events in the event store, ErrGlobalCountNotSupported is returned if the
+// event store does not implement GlobalCounter
+func (r *Repository) GlobalCount(ctx context.Context) (uint64, error) {
+	store, ok := r.eventStore.(GlobalCounter)
+	if !ok {
+		return 0, ErrGlobalCountNotSupported
+	}
+	return store.GlobalCount(ctx)
 }
 
 // Subscribers returns an interface with all event subscribers
@@ -69,25 +285,309 @@ func (r *Repository) Subscribers() EventSubscribers {
 
 // Save an aggregates events
 func (r *Repository) Save(aggregate Aggregate) error {
+	return r.SaveWithContext(context.Background(), aggregate)
+}
+
+// SaveWithContext saves an aggregates events, the context is passed on to subscribers subscribing with context
+func (r *Repository) SaveWithContext(ctx context.Context, aggregate Aggregate) error {
 	root := aggregate.Root()
-	err := r.eventStore.Save(root.aggregateEvents)
+	if r.maxStreamLength > 0 && root.Version()-root.aggregateSnapshotVersion > r.maxStreamLength {
+		return ErrStreamTooLong
+	}
+	// the store gets a copy to keep the aggregate events untouched
+	events := root.Events()
+	err := validateEvents(aggregate, events)
 	if err != nil {
 		return err
 	}
-	// publish the saved events to subscribers
-	r.eventStream.Publish(*root, root.Events())
+	err = r.save(ctx, events)
+	if errors.Is(err, ErrConcurrency) && len(events) > 0 {
+		events, err = r.resolveConflict(ctx, events)
+		if err == nil {
+			root.aggregateEvents = events
+		}
+	}
+	if err != nil {
+		return err
+	}
+	r.saved(ctx, aggregate)
+	return nil
+}
 
-	// update the internal aggregate state
-	root.update()
+// SaveAll saves the events of several aggregates, see SaveAllWithContext
+func (r *Repository) SaveAll(aggregates ...Aggregate) error {
+	return r.SaveAllWithContext(context.Background(), aggregates...)
+}
+
+// SaveAllWithContext saves the events of several aggregates in one call. If the event store implements MultiSaver
+// the events are saved in one transaction, the events are published and the aggregates updated only when all
+// events are saved. Other stores save the aggregates one by one in the given order, on a failure the aggregates
+// before the failing one are saved, published and updated while the failing aggregate and the ones after it are
+// left with their unsaved events. The conflict resolver is not used.
+func (r *Repository) SaveAllWithContext(ctx context.Context, aggregates ...Aggregate) error {
+	for _, aggregate := range aggregates {
+		root := aggregate.Root()
+		if r.maxStreamLength > 0 && root.Version()-root.aggregateSnapshotVersion > r.maxStreamLength {
+			return ErrStreamTooLong
+		}
+		if err := validateEvents(aggregate, root.aggregateEvents); err != nil {
+			return err
+		}
+	}
+	saver, ok := r.eventStore.(MultiSaver)
+	if !ok {
+		for _, aggregate := range aggregates {
+			err := r.save(ctx, aggregate.Root().Events())
+			if err != nil {
+				return err
+			}
+			r.saved(ctx, aggregate)
+		}
+		return nil
+	}
+	batches := make([][]Event, len(aggregates))
+	for i, aggregate := range aggregates {
+		// the store gets a copy to keep the aggregate events untouched
+		batches[i] = aggregate.Root().Events()
+	}
+	err := saver.SaveMany(ctx, batches)
+	if err != nil {
+		return err
+	}
+	for _, aggregate := range aggregates {
+		r.saved(ctx, aggregate)
+	}
+	return nil
+}
+
+// validateEvents runs the validators registered for the unsaved events and the Validator of the aggregate before they
+// are saved, events tracked with TrackChange and TrackChangeWithMetadata are not validated when they are tracked
+func validateEvents(aggregate Aggregate, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	for _, event := range events {
+		if err := validateData(event.AggregateType, event.Data); err != nil {
+			return err
+		}
+	}
+	if v, ok := aggregate.(Validator); ok {
+		return v.Validate()
+	}
 	return nil
 }
 
+// saved publishes the saved events of the aggregate and updates the internal aggregate state
+func (r *Repository) saved(ctx context.Context, aggregate Aggregate) {
+	root := aggregate.Root()
+	events := root.Events()
+	if len(events) > 0 {
+		r.logger.Debug("saved events", "aggregate_type", events[0].AggregateType, "aggregate_id", root.ID(), "count", len(events))
+	}
+	// publish the saved events to subscribers
+	r.eventStream.PublishWithContext(ctx, *root, events)
+	root.update()
+	if r.snapshot != nil && len(events) > 0 {
+		r.snapshotIfDue(aggregate)
+	}
+}
+
+// save saves the events with the context if the event store implements ContextSaver
+func (r *Repository) save(ctx context.Context, events []Event) error {
+	if saver, ok := r.eventStore.(ContextSaver); ok {
+		return saver.SaveContext(ctx, events)
+	}
+	return r.eventStore.Save(events)
+}
+
 // SaveSnapshot saves the current state of the aggregate but only if it has no unsaved events
 func (r *Repository) SaveSnapshot(aggregate Aggregate) error {
 	if r.snapshot == nil {
-		return errors.New("no snapshot store has been initialized")
+		return ErrNoSnapshotStore
+	}
+	err := r.snapshot.Save(aggregate)
+	if err != nil {
+		return err
+	}
+	root := aggregate.Root()
+	root.aggregateSnapshotVersion = root.Version()
+	return nil
+}
+
+// Append saves events with the data on the aggregate without building it. The versions are assigned after the
+// latest stored version of the aggregate and the events get new event ids, timestamps and the ambient metadata of
+// the context. The event store validates the events as on Save, a concurrent save makes it return ErrConcurrency.
+// It's meant for ingesting events from external systems where there is no Aggregate to track the changes on.
+func (r *Repository) Append(ctx context.Context, id uuid.UUID, aggregateType string, data ...interface{}) error {
+	if id == emptyAggregateID {
+		return ErrEmptyID
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	version, err := LatestVersion(ctx, r.eventStore, id, aggregateType)
+	if err != nil {
+		return err
+	}
+	metadata := MetadataFromContext(ctx)
+	events := make([]Event, 0, len(data))
+	for _, d := range data {
+		if err = validateData(aggregateType, d); err != nil {
+			return err
+		}
+		version++
+		events = append(events, Event{
+			EventID:       NewUuid(),
+			AggregateID:   id,
+			Version:       version,
+			AggregateType: aggregateType,
+			Timestamp:     time.Now().UTC(),
+			Data:          d,
+			Metadata:      metadata,
+		})
+	}
+	// the store gets a copy to keep the events untouched
+	err = r.save(ctx, append([]Event(nil), events...))
+	if err != nil {
+		return err
+	}
+	r.logger.Debug("appended events", "aggregate_type", aggregateType, "aggregate_id", id, "count", len(events))
+	r.eventStream.PublishWithContext(ctx, rootOf(events[0]), events)
+	return nil
+}
+
+// rootOf returns an aggregate root for publishing the event when the aggregate is not built. The package path of
+// registered aggregates makes the event reach the aggregate subscribers.
+func rootOf(event Event) AggregateRoot {
+	root := AggregateRoot{aggregateID: event.AggregateID}
+	if a, ok := NewAggregate(event.AggregateType); ok {
+		root.aggregatePath = aggregatePath(a)
+	}
+	return root
+}
+
+// EventCounts returns the number of events for each aggregate id. If the event store implements EventCounter the
+// count is made in the store, otherwise the events are iterated without building the aggregates.
+func (r *Repository) EventCounts(ctx context.Context, aggregateType string, ids ...uuid.UUID) (map[uuid.UUID]int, error) {
+	counts := make(map[uuid.UUID]int, len(ids))
+	for _, id := range ids {
+		count, err := r.eventCount(ctx, id, aggregateType)
+		if err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+	return counts, nil
+}
+
+// Exists returns true if the aggregate has events in the event store, or a snapshot if a snapshot store is set.
+// It's cheaper than Get for existence checks as the aggregate is not built.
+func (r *Repository) Exists(ctx context.Context, id uuid.UUID, aggregateType string) (bool, error) {
+	version, err := LatestVersion(ctx, r.eventStore, id, aggregateType)
+	if err != nil {
+		return false, err
+	}
+	if version > 0 {
+		return true, nil
+	}
+	if r.snapshot == nil {
+		return false, nil
+	}
+	_, err = r.snapshot.snapshotStore.Get(ctx, id, aggregateType)
+	if errors.Is(err, ErrSnapshotNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LatestVersion returns the version of the last stored event of the aggregate, 0 if it has no events. If the event
+// store implements LatestVersionReader the version is read from the store, otherwise the events are iterated.
+func LatestVersion(ctx context.Context, es EventStore, id uuid.UUID, aggregateType string) (Version, error) {
+	if reader, ok := es.(LatestVersionReader); ok {
+		return reader.LatestVersion(ctx, id, aggregateType)
+	}
+	iterator, err := es.Get(ctx, id, aggregateType, 0)
+	if errors.Is(err, ErrNoEvents) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer iterator.Close()
+	var version Version
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, ErrNoMoreEvents) {
+			return version, nil
+		} else if err != nil {
+			return 0, err
+		} else if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		version = event.Version
 	}
-	return r.snapshot.Save(aggregate)
+}
+
+func (r *Repository) eventCount(ctx context.Context, id uuid.UUID, aggregateType string) (int, error) {
+	if counter, ok := r.eventStore.(EventCounter); ok {
+		return counter.EventCount(ctx, id, aggregateType)
+	}
+	iterator, err := r.eventStore.Get(ctx, id, aggregateType, 0)
+	if errors.Is(err, ErrNoEvents) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer iterator.Close()
+	count := 0
+	for {
+		_, err := iterator.Next()
+		if errors.Is(err, ErrNoMoreEvents) {
+			return count, nil
+		} else if err != nil {
+			return 0, err
+		} else if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		count++
+	}
+}
+
+// CountByReason returns the number of events of the aggregate per reason. If the event store implements
+// ReasonCounter the count is made in the store, otherwise the events are iterated without building the aggregate.
+func (r *Repository) CountByReason(ctx context.Context, id uuid.UUID, aggregateType string) (map[string]int, error) {
+	if counter, ok := r.eventStore.(ReasonCounter); ok {
+		return counter.CountByReason(ctx, id, aggregateType)
+	}
+	counts := make(map[string]int)
+	iterator, err := r.eventStore.Get(ctx, id, aggregateType, 0)
+	if errors.Is(err, ErrNoEvents) {
+		return counts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, ErrNoMoreEvents) {
+			return counts, nil
+		} else if err != nil {
+			return nil, err
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		counts[event.Reason()]++
+	}
+}
+
+// DeleteSnapshot removes the snapshot of the aggregate, the next Get builds the aggregate from its events only.
+// ErrSnapshotDeleteNotSupported is returned if the snapshot store does not implement SnapshotDeleter.
+func (r *Repository) DeleteSnapshot(ctx context.Context, id uuid.UUID, aggregate Aggregate) error {
+	if r.snapshot == nil {
+		return ErrNoSnapshotStore
+	}
+	return r.snapshot.Delete(ctx, id, aggregate)
 }
 
 // GetWithContext fetches the aggregates event and build up the aggregate
@@ -98,6 +598,9 @@ func (r *Repository) GetWithContext(ctx context.Context, id uuid.UUID, aggregate
 	if reflect.ValueOf(aggregate).Kind() != reflect.Ptr {
 		return errors.New("aggregate needs to be a pointer")
 	}
+	if err := r.checkRegistered(aggregateName(aggregate)); err != nil {
+		return err
+	}
 	// if there is a snapshot store try fetch aggregate snapshot
 	if r.snapshot != nil {
 		err := r.snapshot.Get(ctx, id, aggregate)
@@ -107,39 +610,411 @@ func (r *Repository) GetWithContext(ctx context.Context, id uuid.UUID, aggregate
 			return ctx.Err()
 		}
 	}
+	return r.buildFromEvents(ctx, id, aggregate)
+}
+
+// GetVersion builds the aggregate as it was at the version, e.g. to debug how its state evolved. The newest
+// snapshot at or below the version is used if the snapshot store implements SnapshotHistoryStore, otherwise the
+// latest snapshot is used if it's not beyond the version. An aggregate with fewer events is built to its last
+// version. Version 0 has no state and returns ErrInvalidVersion.
+func (r *Repository) GetVersion(ctx context.Context, id uuid.UUID, aggregate Aggregate, version Version) error {
+	if reflect.ValueOf(aggregate).Kind() != reflect.Ptr {
+		return errors.New("aggregate needs to be a pointer")
+	}
+	if version == 0 {
+		return ErrInvalidVersion
+	}
+	if err := r.checkRegistered(aggregateName(aggregate)); err != nil {
+		return err
+	}
+	if r.snapshot != nil {
+		err := r.snapshot.getAt(ctx, id, aggregate, version)
+		if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+			return err
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return r.buildFromEventsTo(ctx, id, aggregate, version)
+}
+
+// GetMany builds the aggregates with the ids, the factory creates an empty aggregate of the type. The snapshots of
+// all the aggregates are fetched in one call if the snapshot store implements BatchSnapshotStore, before the
+// aggregates are topped up with their events. Aggregates that are not found are left out of the returned map.
+func (r *Repository) GetMany(ctx context.Context, ids []uuid.UUID, factory func() Aggregate) (map[uuid.UUID]Aggregate, error) {
+	typ := aggregateName(factory())
+	if err := r.checkRegistered(typ); err != nil {
+		return nil, err
+	}
+	snapshots := map[uuid.UUID]Snapshot{}
+	if r.snapshot != nil && len(ids) > 0 {
+		var err error
+		snapshots, err = r.snapshot.getMany(ctx, ids, typ)
+		if err != nil {
+			return nil, err
+		}
+	}
+	aggregates := make(map[uuid.UUID]Aggregate, len(ids))
+	for _, id := range ids {
+		aggregate := factory()
+		if snap, ok := snapshots[id]; ok {
+			err := r.snapshot.restore(snap, aggregate)
+			if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+				return nil, err
+			}
+		}
+		err := r.buildFromEvents(ctx, id, aggregate)
+		if errors.Is(err, ErrAggregateNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		aggregates[id] = aggregate
+	}
+	return aggregates, nil
+}
+
+// checkRegistered returns ErrUnregisteredAggregate if the event store knows its aggregate types and the
+// aggregate type is not one of them
+func (r *Repository) checkRegistered(aggregateType string) error {
+	checker, ok := r.eventStore.(AggregateTypeChecker)
+	if ok && !checker.AggregateRegistered(aggregateType) {
+		return fmt.Errorf("%w: %s", ErrUnregisteredAggregate, aggregateType)
+	}
+	return nil
+}
+
+// buildFromEvents applies the events after the current version of the aggregate
+func (r *Repository) buildFromEvents(ctx context.Context, id uuid.UUID, aggregate Aggregate) error {
+	return r.buildFromEventsTo(ctx, id, aggregate, 0)
+}
+
+// buildFromEventsTo applies the events after the current version of the aggregate up to and including the version
+// to, all events if to is 0
+func (r *Repository) buildFromEventsTo(ctx context.Context, id uuid.UUID, aggregate Aggregate, to Version) error {
 	root := aggregate.Root()
-	aggregateType := reflect.TypeOf(aggregate).Elem().Name()
+	aggregateType := aggregateName(aggregate)
 	// fetch events after the current version of the aggregate that could be fetched from the snapshot store
 	eventIterator, err := r.eventStore.Get(ctx, id, aggregateType, root.Version())
 	if err != nil && !errors.Is(err, ErrNoEvents) {
 		return err
-	} else if errors.Is(err, ErrNoEvents) && root.Version() == 0 {
-		// no events and no snapshot
-		return ErrAggregateNotFound
+	} else if errors.Is(err, ErrNoEvents) {
+		if root.Version() == 0 {
+			// no events and no snapshot
+			return ErrAggregateNotFound
+		}
+		// no events after the snapshot
+		return nil
 	} else if ctx.Err() != nil {
 		return ctx.Err()
 	}
 	defer eventIterator.Close()
-DONE:
+	// the events are applied in batches to not allocate a slice per event
+	batch := make([]Event, 0, buildBatchSize)
 	for {
 		select {
 		case <-ctx.Done():
+			// apply the fetched events to leave the aggregate at the version reached for GetBestEffort
+			r.apply(aggregate, batch)
 			return ctx.Err()
 		default:
 			event, err := eventIterator.Next()
+			if err == nil && to > 0 && event.Version > to {
+				// the events after the version are not applied
+				err = ErrNoMoreEvents
+			}
 			if err != nil && !errors.Is(err, ErrNoMoreEvents) {
 				return err
-			} else if errors.Is(err, ErrNoMoreEvents) && root.Version() == 0 {
-				// no events and no snapshot (some eventstore will not return the error ErrNoEvent on Get())
-				return ErrAggregateNotFound
 			} else if errors.Is(err, ErrNoMoreEvents) {
-				break DONE
+				r.apply(aggregate, batch)
+				if root.Version() == 0 && errors.Is(err, ErrAllEventsUnregistered) {
+					// the stream exists but the serializer of the event store skipped all its events
+					return ErrAllEventsUnregistered
+				} else if root.Version() == 0 {
+					// no events and no snapshot (some eventstore will not return the error ErrNoEvent on Get())
+					return ErrAggregateNotFound
+				}
+				return nil
+			}
+			batch = append(batch, event)
+			if len(batch) == cap(batch) {
+				// apply the events on the aggregate
+				r.apply(aggregate, batch)
+				batch = batch[:0]
 			}
-			// apply the event on the aggregate
-			root.BuildFromHistory(aggregate, []Event{event})
 		}
 	}
-	return nil
+}
+
+// apply applies the events on the aggregate, passing them to the on apply function first if it's set
+func (r *Repository) apply(aggregate Aggregate, events []Event) {
+	if r.onApply != nil {
+		for _, event := range events {
+			r.onApply(event)
+		}
+	}
+	aggregate.Root().BuildFromHistory(aggregate, events)
+}
+
+// Events returns an iterator over the events of an aggregate stream after the version without building
+// the aggregate. The caller is responsible for closing the iterator.
+func (r *Repository) Events(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion Version) (EventIterator, error) {
+	return r.eventStore.Get(ctx, id, aggregateType, afterVersion)
+}
+
+// exportedEvent is an event as it's written by ExportAggregate
+type exportedEvent struct {
+	Reason    string          `json:"reason"`
+	Version   Version         `json:"version"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+	Metadata  json.RawMessage `json:"metadata,omitempty"`
+}
+
+// ExportAggregate writes the events of an aggregate as an indented JSON array to w without building the aggregate.
+// The events are written as they are read from the event store, the history is never held in memory. The data and
+// metadata are marshalled with the serializer of the snapshot handler, which has to produce JSON, or encoding/json
+// if the repository has no snapshot handler. ErrAggregateNotFound is returned before anything is written if the
+// aggregate has no events.
+func (r *Repository) ExportAggregate(ctx context.Context, id uuid.UUID, aggregateType string, w io.Writer) error {
+	marshal := json.Marshal
+	if r.snapshot != nil && r.snapshot.serializer.marshal != nil {
+		marshal = r.snapshot.serializer.Marshal
+	}
+	iterator, err := r.eventStore.Get(ctx, id, aggregateType, 0)
+	if errors.Is(err, ErrNoEvents) {
+		return ErrAggregateNotFound
+	} else if err != nil {
+		return err
+	}
+	defer iterator.Close()
+	written := 0
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		event, err := iterator.Next()
+		if errors.Is(err, ErrNoMoreEvents) {
+			break
+		} else if err != nil {
+			return err
+		}
+		e := exportedEvent{Reason: event.Reason(), Version: event.Version, Timestamp: event.Timestamp}
+		if e.Data, err = marshal(event.Data); err != nil {
+			return err
+		}
+		if event.Metadata != nil {
+			if e.Metadata, err = marshal(event.Metadata); err != nil {
+				return err
+			}
+		}
+		b, err := json.MarshalIndent(e, "\t", "\t")
+		if err != nil {
+			return err
+		}
+		separator := ",\n\t"
+		if written == 0 {
+			separator = "[\n\t"
+		}
+		if _, err = io.WriteString(w, separator); err != nil {
+			return err
+		}
+		if _, err = w.Write(b); err != nil {
+			return err
+		}
+		written++
+	}
+	if written == 0 {
+		// some event stores return an empty iterator instead of ErrNoEvents
+		return ErrAggregateNotFound
+	}
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
+
+// RebuildProjection applies the events in the global event feed after the checkpoint, the event id of the last
+// processed event, and returns the event id of the last applied event for the caller to persist. It's a one-shot
+// synchronous rebuild meant for warming read models at startup. If there are no events after the checkpoint the
+// checkpoint is returned. Pass uuid.Nil to rebuild from the start of the feed.
+func (r *Repository) RebuildProjection(ctx context.Context, checkpoint uuid.UUID, apply func(Event) error) (uuid.UUID, error) {
+	store, ok := r.eventStore.(GlobalEventStore)
+	if !ok {
+		return checkpoint, ErrGlobalEventsNotSupported
+	}
+	processed := 0
+	for {
+		if ctx.Err() != nil {
+			return checkpoint, ctx.Err()
+		}
+		events, err := globalEvents(ctx, store, checkpoint, rebuildBatchSize)
+		if err != nil {
+			return checkpoint, err
+		}
+		applied := 0
+		for _, event := range events {
+			// the start position is included in the global events
+			if event.EventID == checkpoint {
+				continue
+			}
+			if ctx.Err() != nil {
+				return checkpoint, ctx.Err()
+			}
+			if err = apply(event); err != nil {
+				return checkpoint, err
+			}
+			checkpoint = event.EventID
+			applied++
+			processed++
+			if r.progress != nil && processed%r.progressInterval == 0 {
+				r.progress(processed, checkpoint)
+			}
+		}
+		if applied == 0 {
+			return checkpoint, nil
+		}
+	}
+}
+
+// SubscribeFromSnapshot subscribes to the events of the aggregate after its snapshot version. The aggregate ID has
+// to be set, its snapshot is loaded into the aggregate to learn the version (version 0 if there is no snapshot) and
+// the events stored after it are delivered to f before the live events. Each event is delivered once and in
+// version order, it's made for keeping a cached view of an aggregate up to date.
+func (r *Repository) SubscribeFromSnapshot(ctx context.Context, aggregate Aggregate, f func(e Event)) (*subscription, error) {
+	root := aggregate.Root()
+	if root.ID() == emptyAggregateID {
+		return nil, ErrEmptyID
+	}
+	if r.snapshot != nil {
+		err := r.snapshot.Get(ctx, root.ID(), aggregate)
+		if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+			return nil, err
+		}
+	}
+
+	return r.subscribeAfter(ctx, aggregate, root.Version(), f)
+}
+
+// SubscribeWithReplay subscribes to the events of the aggregate, the aggregate ID has to be set. The events already
+// stored are delivered to f before the live events, which gives a view of the aggregate its full history and then
+// the updates. Each event is delivered once and in version order, also the events saved during the replay. The
+// aggregate is only used for its ID and type and is not built.
+func (r *Repository) SubscribeWithReplay(ctx context.Context, aggregate Aggregate, f func(e Event)) (*subscription, error) {
+	if aggregate.Root().ID() == emptyAggregateID {
+		return nil, ErrEmptyID
+	}
+	return r.subscribeAfter(ctx, aggregate, 0, f)
+}
+
+// subscribeAfter subscribes to the live events of the aggregate and delivers the stored events after the version
+// before them
+func (r *Repository) subscribeAfter(ctx context.Context, aggregate Aggregate, after Version, f func(e Event)) (*subscription, error) {
+	var lock sync.Mutex
+	delivered := after
+	deliver := func(e Event) {
+		if e.Version <= delivered {
+			return
+		}
+		delivered = e.Version
+		f(e)
+	}
+	// subscribe before the catch up to not miss events saved during it, live events wait for the catch up
+	// and the ones already delivered by it are skipped
+	var err error
+	lock.Lock()
+	s := r.eventStream.AggregateID(func(e Event) {
+		lock.Lock()
+		defer lock.Unlock()
+		// the subscription failed in the catch up and is about to be closed
+		if err != nil {
+			return
+		}
+		deliver(e)
+	}, aggregate)
+	err = r.catchUp(ctx, aggregate, delivered, deliver)
+	lock.Unlock()
+	if err != nil {
+		// closed after the unlock, a live event waiting for the catch up holds the event stream lock that
+		// Close takes
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// catchUp delivers the stored events of the aggregate after the version
+func (r *Repository) catchUp(ctx context.Context, aggregate Aggregate, after Version, deliver func(e Event)) error {
+	iterator, err := r.eventStore.Get(ctx, aggregate.Root().ID(), aggregateName(aggregate), after)
+	if errors.Is(err, ErrNoEvents) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer iterator.Close()
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, ErrNoMoreEvents) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		deliver(event)
+	}
+}
+
+// Load builds a new aggregate from the factory with the id and returns it, as GetWithContext but without an
+// aggregate to build into
+func (r *Repository) Load(ctx context.Context, id uuid.UUID, factory func() Aggregate) (Aggregate, error) {
+	aggregate := factory()
+	err := r.GetWithContext(ctx, id, aggregate)
+	if err != nil {
+		return nil, err
+	}
+	return aggregate, nil
+}
+
+// Clone returns a copy of the aggregate to run commands on without affecting the original, e.g. to preview the
+// events of a command. The state is copied as in a snapshot, a round trip through the serializer of the snapshot
+// handler or the JSON serializer if the repository has none, which means the unexported fields of the aggregate are
+// only copied if it's a SnapshotAggregate or keeps them in a snapshot state. The version and unsaved events of the
+// aggregate root are copied as is.
+func (r *Repository) Clone(a Aggregate) (Aggregate, error) {
+	v := reflect.ValueOf(a)
+	if v.Kind() != reflect.Ptr {
+		return nil, errors.New("aggregate needs to be a pointer")
+	}
+	ser := NewJSONSerializer()
+	if r.snapshot != nil {
+		ser = &r.snapshot.serializer
+	}
+	clone := reflect.New(v.Elem().Type()).Interface().(Aggregate)
+	if sa, ok := a.(SnapshotAggregate); ok {
+		b, err := sa.Marshal(ser.Marshal)
+		if err != nil {
+			return nil, err
+		}
+		if err = clone.(SnapshotAggregate).Unmarshal(ser.Unmarshal, b); err != nil {
+			return nil, err
+		}
+	} else {
+		b, err := marshalState(ser.Marshal, a)
+		if err != nil {
+			return nil, err
+		}
+		if err = unmarshalState(ser.Unmarshal, b, clone); err != nil {
+			return nil, err
+		}
+	}
+	root := *a.Root()
+	root.aggregateEvents = append([]Event(nil), root.aggregateEvents...)
+	if root.idempotencyKeys != nil {
+		keys := make(map[string]struct{}, len(root.idempotencyKeys))
+		for key := range root.idempotencyKeys {
+			keys[key] = struct{}{}
+		}
+		root.idempotencyKeys = keys
+	}
+	*clone.Root() = root
+	return clone, nil
 }
 
 // Get fetches the aggregates event and build up the aggregate
@@ -148,3 +1023,13 @@ DONE:
 func (r *Repository) Get(id uuid.UUID, aggregate Aggregate) error {
 	return r.GetWithContext(context.Background(), id, aggregate)
 }
+
+// GetBestEffort builds the aggregate as GetWithContext but when the context is canceled during the build the
+// aggregate is left with the events applied so far. The returned version is the version the aggregate reached
+// together with the context error, the aggregate is then not at its latest version and should only be used for
+// stale but useful views. The aggregate must not be saved after a canceled build as the save would fail with
+// ErrConcurrency.
+func (r *Repository) GetBestEffort(ctx context.Context, id uuid.UUID, aggregate Aggregate) (Version, error) {
+	err := r.GetWithContext(ctx, id, aggregate)
+	return aggregate.Root().Version(), err
+}
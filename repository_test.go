@@ -1,12 +1,16 @@
 package eventsourcing_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/hallgren/eventsourcing"
 	"github.com/hallgren/eventsourcing/eventstore/memory"
 	memsnap "github.com/hallgren/eventsourcing/snapshotstore/memory"
@@ -216,6 +220,136 @@ func TestSaveSnapshotWithoutSnapshotStore(t *testing.T) {
 	}
 }
 
+func TestOnApply(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(memsnap.New(), *eventsourcing.NewJSONSerializer()))
+	var versions []eventsourcing.Version
+	repo.SetOnApply(func(e eventsourcing.Event) {
+		versions = append(versions, e.Version)
+	})
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.SaveSnapshot(person); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		person.GrowOlder()
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Fatalf("expected no applied events on save got %v", versions)
+	}
+
+	p := Person{}
+	if err = repo.Get(person.ID(), &p); err != nil {
+		t.Fatal(err)
+	}
+	// the events up to the snapshot version are not replayed
+	if len(versions) != 3 || versions[0] != 3 || versions[2] != 5 {
+		t.Fatalf("expected versions 3 to 5 got %v", versions)
+	}
+	if p.Age != 4 {
+		t.Fatalf("expected age 4 got %d", p.Age)
+	}
+}
+
+func TestSaveAll(t *testing.T) {
+	es := memory.Create()
+	repo := eventsourcing.NewRepository(es, nil)
+	published := 0
+	s := repo.Subscribers().All(func(e eventsourcing.Event) {
+		published++
+	})
+	defer s.Close()
+
+	kalle, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anka, err := CreatePerson("anka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anka.GrowOlder()
+	err = repo.SaveAll(kalle, anka)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published != 3 {
+		t.Fatalf("expected 3 published events got %d", published)
+	}
+	if kalle.UnsavedEvents() || anka.UnsavedEvents() || anka.Version() != 2 {
+		t.Fatal("expected both aggregates to be updated")
+	}
+
+	// a stale aggregate fails the save of all aggregates
+	stale := Person{}
+	if err = repo.Get(kalle.ID(), &stale); err != nil {
+		t.Fatal(err)
+	}
+	kalle.GrowOlder()
+	if err = repo.Save(kalle); err != nil {
+		t.Fatal(err)
+	}
+	stale.GrowOlder()
+	anka.GrowOlder()
+	err = repo.SaveAll(anka, &stale)
+	if !errors.Is(err, eventsourcing.ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency got %v", err)
+	}
+	version, err := es.LatestVersion(context.Background(), anka.ID(), "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || !anka.UnsavedEvents() {
+		t.Fatalf("expected no events of anka to be saved got version %d", version)
+	}
+}
+
+func TestSaveAllWithoutMultiSaver(t *testing.T) {
+	repo := eventsourcing.NewRepository(iteratorOnlyStore{memory.Create()}, nil)
+	kalle, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(kalle); err != nil {
+		t.Fatal(err)
+	}
+	stale := Person{}
+	if err = repo.Get(kalle.ID(), &stale); err != nil {
+		t.Fatal(err)
+	}
+	kalle.GrowOlder()
+	if err = repo.Save(kalle); err != nil {
+		t.Fatal(err)
+	}
+
+	// the aggregates before the failing one are saved
+	anka, err := CreatePerson("anka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.GrowOlder()
+	err = repo.SaveAll(anka, &stale)
+	if !errors.Is(err, eventsourcing.ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency got %v", err)
+	}
+	if anka.UnsavedEvents() {
+		t.Fatal("expected the aggregate before the failing one to be saved")
+	}
+	if !stale.UnsavedEvents() {
+		t.Fatal("expected the failing aggregate to keep its events")
+	}
+}
+
 func TestSubscriptionAllEvent(t *testing.T) {
 	counter := 0
 	f := func(e eventsourcing.Event) {
@@ -242,6 +376,42 @@ func TestSubscriptionAllEvent(t *testing.T) {
 	}
 }
 
+func TestSubscriptionAllBatch(t *testing.T) {
+	var batches [][]eventsourcing.Event
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	s := repo.Subscribers().(eventsourcing.BatchSubscriber).AllBatch(func(events []eventsourcing.Event) {
+		batches = append(batches, events)
+	})
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		person.GrowOlder()
+	}
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || len(batches[0]) != 6 {
+		t.Fatalf("expected one batch of 6 events got %d batches", len(batches))
+	}
+	if repo.Subscribers().(eventsourcing.SubscriptionCounter).Count() != 1 {
+		t.Fatalf("expected 1 subscription got %d", repo.Subscribers().(eventsourcing.SubscriptionCounter).Count())
+	}
+
+	s.Close()
+	person.GrowOlder()
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("expected no batch after close got %d batches", len(batches))
+	}
+}
+
 func TestSubscriptionSpecificEvent(t *testing.T) {
 	counter := 0
 	f := func(e eventsourcing.Event) {
@@ -389,3 +559,1442 @@ func TestEventChainDoesNotHang(t *testing.T) {
 		t.Errorf("wrong number in ageCounter expected 6, got %v", ageCounter)
 	}
 }
+
+func TestEvents(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	person.GrowOlder()
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iterator, err := repo.Events(context.Background(), person.ID(), "Person", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	var events []eventsourcing.Event
+	for {
+		event, err := iterator.Next()
+		if errors.Is(err, eventsourcing.ErrNoMoreEvents) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events after version 1 got %d", len(events))
+	}
+	if events[0].Reason() != "AgedOneYear" {
+		t.Fatalf("expected AgedOneYear got %s", events[0].Reason())
+	}
+}
+
+func TestExportAggregate(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = repo.ExportAggregate(context.Background(), person.ID(), "Person", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported []struct {
+		Reason  string                 `json:"reason"`
+		Version eventsourcing.Version  `json:"version"`
+		Data    map[string]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatalf("could not parse the export %q, %v", buf.String(), err)
+	}
+	if len(exported) != 3 {
+		t.Fatalf("expected 3 exported events got %d", len(exported))
+	}
+	if exported[0].Reason != "Born" || exported[0].Data["Name"] != "kalle" {
+		t.Fatalf("unexpected first event %+v", exported[0])
+	}
+	if exported[2].Version != 3 {
+		t.Fatalf("expected the last event at version 3 got %d", exported[2].Version)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = repo.ExportAggregate(ctx, person.ID(), "Person", &bytes.Buffer{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+	err = repo.ExportAggregate(context.Background(), eventsourcing.NewUuid(), "Person", &bytes.Buffer{})
+	if !errors.Is(err, eventsourcing.ErrAggregateNotFound) {
+		t.Fatalf("expected ErrAggregateNotFound got %v", err)
+	}
+}
+
+func TestLoadAndClone(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := repo.Load(context.Background(), person.ID(), func() eventsourcing.Aggregate { return &Person{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := loaded.(*Person)
+	if original.Age != 1 || original.Version() != 2 {
+		t.Fatalf("expected the loaded person at age 1 and version 2 got %d and %d", original.Age, original.Version())
+	}
+	c, err := repo.Clone(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := c.(*Person)
+	clone.GrowOlder()
+	if clone.Age != 2 || clone.Version() != 3 || len(clone.Events()) != 1 {
+		t.Fatalf("expected the clone at age 2 and version 3 with one event got %d, %d and %d", clone.Age, clone.Version(), len(clone.Events()))
+	}
+	if clone.ID() != original.ID() || clone.Name != "kalle" {
+		t.Fatalf("expected the clone to keep the id and name got %s and %s", clone.ID(), clone.Name)
+	}
+	if original.Age != 1 || original.Version() != 2 || original.UnsavedEvents() {
+		t.Fatalf("expected the original to be unchanged got age %d and version %d", original.Age, original.Version())
+	}
+
+	_, err = repo.Load(context.Background(), eventsourcing.NewUuid(), func() eventsourcing.Aggregate { return &Person{} })
+	if !errors.Is(err, eventsourcing.ErrAggregateNotFound) {
+		t.Fatalf("expected ErrAggregateNotFound got %v", err)
+	}
+}
+
+func TestSubscriptionClosedBeforeSave(t *testing.T) {
+	called := false
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	s := repo.Subscribers().All(func(e eventsourcing.Event) {
+		called = true
+	})
+	if repo.Subscribers().(eventsourcing.SubscriptionCounter).Count() != 1 {
+		t.Fatalf("expected 1 subscription got %d", repo.Subscribers().(eventsourcing.SubscriptionCounter).Count())
+	}
+	s.Close()
+	if repo.Subscribers().(eventsourcing.SubscriptionCounter).Count() != 0 {
+		t.Fatalf("expected 0 subscriptions got %d", repo.Subscribers().(eventsourcing.SubscriptionCounter).Count())
+	}
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Fatal("closed subscription should not be called")
+	}
+}
+
+type traceKey struct{}
+
+func TestSubscriptionAllWithContext(t *testing.T) {
+	var traces []interface{}
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	s := repo.Subscribers().(eventsourcing.ContextSubscriber).AllWithContext(func(ctx context.Context, e eventsourcing.Event) {
+		traces = append(traces, ctx.Value(traceKey{}))
+	})
+	defer s.Close()
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+	err = repo.SaveWithContext(ctx, person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 2 {
+		t.Fatalf("expected 2 events got %d", len(traces))
+	}
+	for _, trace := range traces {
+		if trace != "trace-1" {
+			t.Fatalf("expected trace-1 from the save context got %v", trace)
+		}
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	snapshotStore := memsnap.New()
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(snapshotStore, *ser))
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.SaveSnapshot(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.DeleteSnapshot(context.Background(), person.ID(), person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = snapshotStore.Get(context.Background(), person.ID(), "Person")
+	if !errors.Is(err, eventsourcing.ErrSnapshotNotFound) {
+		t.Fatalf("expected snapshot to be deleted got %v", err)
+	}
+
+	// the aggregate is built from the events
+	twin := Person{}
+	err = repo.Get(person.ID(), &twin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if twin.Name != person.Name {
+		t.Fatalf("expected name %s got %s", person.Name, twin.Name)
+	}
+}
+
+// readOnlySnapshotStore is a snapshot store without Delete
+type readOnlySnapshotStore struct {
+	eventsourcing.SnapshotStore
+}
+
+func TestDeleteSnapshotNotSupported(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(readOnlySnapshotStore{memsnap.New()}, *ser))
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.DeleteSnapshot(context.Background(), person.ID(), person)
+	if !errors.Is(err, eventsourcing.ErrSnapshotDeleteNotSupported) {
+		t.Fatalf("expected ErrSnapshotDeleteNotSupported got %v", err)
+	}
+}
+
+// iteratorOnlyStore hides optional event store interfaces to test the fallback paths in the repository
+// contextStore fails the save if the context is done
+type contextStore struct {
+	eventsourcing.EventStore
+}
+
+func (c contextStore) SaveContext(ctx context.Context, events []eventsourcing.Event) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return c.Save(events)
+}
+
+func TestSaveWithContextSaver(t *testing.T) {
+	repo := eventsourcing.NewRepository(contextStore{memory.Create()}, nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = repo.SaveWithContext(ctx, person)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+	if !person.UnsavedEvents() {
+		t.Fatal("expected the events to be unsaved")
+	}
+	err = repo.SaveWithContext(context.Background(), person)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type iteratorOnlyStore struct {
+	eventsourcing.EventStore
+}
+
+func TestEventCounts(t *testing.T) {
+	for _, store := range []eventsourcing.EventStore{memory.Create(), iteratorOnlyStore{memory.Create()}} {
+		repo := eventsourcing.NewRepository(store, nil)
+
+		person, err := CreatePerson("kalle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			person.GrowOlder()
+		}
+		err = repo.Save(person)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unsaved := eventsourcing.NewUuid()
+
+		counts, err := repo.EventCounts(context.Background(), "Person", person.ID(), unsaved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts[person.ID()] != 6 {
+			t.Fatalf("expected 6 events got %d", counts[person.ID()])
+		}
+		if counts[unsaved] != 0 {
+			t.Fatalf("expected 0 events got %d", counts[unsaved])
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	snapshots := memsnap.New()
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(snapshots, *eventsourcing.NewJSONSerializer()))
+	ctx := context.Background()
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := repo.Exists(ctx, person.ID(), "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected the unsaved aggregate not to exist")
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	exists, err = repo.Exists(ctx, person.ID(), "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected the saved aggregate to exist")
+	}
+
+	// an aggregate only in the snapshot store exists
+	id := eventsourcing.NewUuid()
+	err = snapshots.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: 1, State: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err = repo.Exists(ctx, id, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected the aggregate with a snapshot to exist")
+	}
+}
+
+func TestCountByReason(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	counts, err := repo.CountByReason(context.Background(), person.ID(), "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["Born"] != 1 || counts["AgedOneYear"] != 2 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+	counts, err = repo.CountByReason(context.Background(), eventsourcing.NewUuid(), "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 0 {
+		t.Fatalf("expected no counts got %v", counts)
+	}
+}
+
+func TestRebuildProjection(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+
+	// empty store returns the checkpoint
+	checkpoint, err := repo.RebuildProjection(context.Background(), uuid.Nil, func(e eventsourcing.Event) error {
+		t.Fatal("no events should be applied")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint != uuid.Nil {
+		t.Fatalf("expected the input checkpoint got %s", checkpoint)
+	}
+
+	var saved []eventsourcing.Event
+	for i := 0; i < 3; i++ {
+		person, err := CreatePerson("kalle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		person.GrowOlder()
+		saved = append(saved, person.Events()...)
+		if err = repo.Save(person); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var applied []eventsourcing.Event
+	checkpoint, err = repo.RebuildProjection(context.Background(), uuid.Nil, func(e eventsourcing.Event) error {
+		applied = append(applied, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(saved) {
+		t.Fatalf("expected %d applied events got %d", len(saved), len(applied))
+	}
+	if checkpoint != saved[len(saved)-1].EventID {
+		t.Fatalf("expected checkpoint %s got %s", saved[len(saved)-1].EventID, checkpoint)
+	}
+
+	// rebuild from the middle of the feed
+	applied = nil
+	_, err = repo.RebuildProjection(context.Background(), saved[3].EventID, func(e eventsourcing.Event) error {
+		applied = append(applied, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("expected 2 applied events after the checkpoint got %d", len(applied))
+	}
+}
+
+func TestRebuildProjectionCanceled(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	first := person.Events()[0].EventID
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	checkpoint, err := repo.RebuildProjection(ctx, uuid.Nil, func(e eventsourcing.Event) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+	if checkpoint != first {
+		t.Fatalf("expected the checkpoint of the first applied event got %s", checkpoint)
+	}
+}
+
+func TestRebuildProjectionProgress(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 249; i++ {
+		person.GrowOlder()
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	total, err := repo.GlobalCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 250 {
+		t.Fatalf("expected 250 events got %d", total)
+	}
+
+	var reported []int
+	var last uuid.UUID
+	repo.SetProgressReporter(50, func(processed int, checkpoint uuid.UUID) {
+		reported = append(reported, processed)
+		last = checkpoint
+	})
+	checkpoint, err := repo.RebuildProjection(context.Background(), uuid.Nil, func(e eventsourcing.Event) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 5 || reported[0] != 50 || reported[4] != 250 {
+		t.Fatalf("expected progress every 50 events got %v", reported)
+	}
+	if last != checkpoint {
+		t.Fatalf("expected the last report at checkpoint %s got %s", checkpoint, last)
+	}
+
+	_, err = eventsourcing.NewRepository(mutatingStore{memory.Create()}, nil).GlobalCount(context.Background())
+	if !errors.Is(err, eventsourcing.ErrGlobalCountNotSupported) {
+		t.Fatalf("expected ErrGlobalCountNotSupported got %v", err)
+	}
+}
+
+// mutatingStore modifies the events passed to Save
+type mutatingStore struct {
+	eventsourcing.EventStore
+}
+
+func (m mutatingStore) Save(events []eventsourcing.Event) error {
+	for i := range events {
+		events[i].AggregateType = "mutated"
+	}
+	return nil
+}
+
+func TestSaveDoesNotExposeAggregateEvents(t *testing.T) {
+	repo := eventsourcing.NewRepository(mutatingStore{memory.Create()}, nil)
+	var published []eventsourcing.Event
+	repo.Subscribers().All(func(e eventsourcing.Event) {
+		published = append(published, e)
+	})
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 1 {
+		t.Fatalf("expected 1 published event got %d", len(published))
+	}
+	if published[0].AggregateType != "Person" {
+		t.Fatalf("the store should not modify the aggregate events, got aggregate type %s", published[0].AggregateType)
+	}
+}
+
+func TestSaveConcurrencyError(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two copies of the aggregate changed in parallel
+	first := Person{}
+	second := Person{}
+	if err = repo.Get(person.ID(), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(person.ID(), &second); err != nil {
+		t.Fatal(err)
+	}
+	first.GrowOlder()
+	second.GrowOlder()
+	if err = repo.Save(&first); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Save(&second)
+	if !errors.Is(err, eventsourcing.ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency got %v", err)
+	}
+}
+
+// longLivedPerson saves a person with count events
+func longLivedPerson(b *testing.B, repo *eventsourcing.Repository, count int) *Person {
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 1; i < count; i++ {
+		person.GrowOlder()
+	}
+	if err = repo.Save(person); err != nil {
+		b.Fatal(err)
+	}
+	return person
+}
+
+func BenchmarkGetPerEvent(b *testing.B) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person := longLivedPerson(b, repo, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// the aggregate built with one BuildFromHistory call per event
+		iterator, err := repo.Events(context.Background(), person.ID(), "Person", 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		p := Person{}
+		for {
+			event, err := iterator.Next()
+			if err != nil {
+				break
+			}
+			p.BuildFromHistory(&p, []eventsourcing.Event{event})
+		}
+		iterator.Close()
+	}
+}
+
+func BenchmarkGetBatched(b *testing.B) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person := longLivedPerson(b, repo, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := Person{}
+		if err := repo.Get(person.ID(), &p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDeterministicID(t *testing.T) {
+	tenant := eventsourcing.DeterministicID(uuid.NamespaceURL, "https://acme.example")
+	id := eventsourcing.DeterministicID(tenant, "customer-42")
+	if id != eventsourcing.DeterministicID(tenant, "customer-42") {
+		t.Fatal("expected the same id from the same input")
+	}
+	if id == eventsourcing.DeterministicID(tenant, "customer-43") {
+		t.Fatal("expected different ids from different names")
+	}
+
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePersonWithID(id, "kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the natural key finds the same stream
+	fetched := Person{}
+	err = repo.Get(eventsourcing.DeterministicID(tenant, "customer-42"), &fetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Name != "kalle" {
+		t.Fatalf("expected kalle got %s", fetched.Name)
+	}
+}
+
+func TestSubscribeFromSnapshot(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(memsnap.New(), *ser))
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.SaveSnapshot(person); err != nil {
+		t.Fatal(err)
+	}
+	// events after the snapshot are caught up on
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []eventsourcing.Version
+	view := Person{}
+	view.SetID(person.ID())
+	s, err := repo.SubscribeFromSnapshot(context.Background(), &view, func(e eventsourcing.Event) {
+		versions = append(versions, e.Version)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if view.Age != 1 {
+		t.Fatalf("expected the view to be loaded from the snapshot, age %d", view.Age)
+	}
+
+	// live events
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0] != 3 || versions[1] != 4 {
+		t.Fatalf("expected version 3 and 4 got %v", versions)
+	}
+}
+
+// failingCatchUpStore is an event store where Get blocks until release is closed and then fails
+type failingCatchUpStore struct {
+	eventsourcing.EventStore
+	started chan struct{}
+	release chan struct{}
+}
+
+var errCatchUp = errors.New("catch up failed")
+
+func (f failingCatchUpStore) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	close(f.started)
+	<-f.release
+	return nil, errCatchUp
+}
+
+func TestSubscribeWithReplayFailedCatchUp(t *testing.T) {
+	store := failingCatchUpStore{EventStore: memory.Create(), started: make(chan struct{}), release: make(chan struct{})}
+	repo := eventsourcing.NewRepository(store, nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscribed := make(chan error, 1)
+	go func() {
+		_, err := repo.SubscribeWithReplay(context.Background(), person, func(e eventsourcing.Event) {})
+		subscribed <- err
+	}()
+	<-store.started
+	// a live event waits for the catch up while it holds the event stream lock
+	saved := make(chan error, 1)
+	go func() {
+		saved <- repo.Save(person)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(store.release)
+
+	select {
+	case err = <-subscribed:
+		if !errors.Is(err, errCatchUp) {
+			t.Fatalf("expected errCatchUp got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the failed catch up deadlocked with the live event")
+	}
+	if err = <-saved; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribeFromSnapshotNoSnapshot(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []eventsourcing.Version
+	view := Person{}
+	view.SetID(person.ID())
+	s, err := repo.SubscribeFromSnapshot(context.Background(), &view, func(e eventsourcing.Event) {
+		versions = append(versions, e.Version)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if len(versions) != 1 || versions[0] != 1 {
+		t.Fatalf("expected version 1 got %v", versions)
+	}
+}
+
+func TestSubscribeWithReplay(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []eventsourcing.Version
+	view := Person{}
+	view.SetID(person.ID())
+	s, err := repo.SubscribeWithReplay(context.Background(), &view, func(e eventsourcing.Event) {
+		versions = append(versions, e.Version)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 replayed events got %v", versions)
+	}
+
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[2] != 3 {
+		t.Fatalf("expected the live event with version 3 got %v", versions)
+	}
+	if view.Age != 0 {
+		t.Fatalf("expected the view not to be built got age %d", view.Age)
+	}
+}
+
+// countingSnapshotStore counts the calls to Get, GetMany and Save
+type countingSnapshotStore struct {
+	*memsnap.Handler
+	gets, getManys, saves int
+}
+
+func (c *countingSnapshotStore) Save(s eventsourcing.Snapshot) error {
+	c.saves++
+	return c.Handler.Save(s)
+}
+
+func (c *countingSnapshotStore) Get(ctx context.Context, id uuid.UUID, typ string) (eventsourcing.Snapshot, error) {
+	c.gets++
+	return c.Handler.Get(ctx, id, typ)
+}
+
+func (c *countingSnapshotStore) GetMany(ctx context.Context, ids []uuid.UUID, typ string) (map[uuid.UUID]eventsourcing.Snapshot, error) {
+	c.getManys++
+	return c.Handler.GetMany(ctx, ids, typ)
+}
+
+func TestSnapshotAfter(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	snapshots := &countingSnapshotStore{Handler: memsnap.New()}
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(snapshots, *ser))
+	repo.SetSnapshotAfter(50 * time.Millisecond)
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots.saves != 1 {
+		t.Fatalf("expected one snapshot within the window got %d", snapshots.saves)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots.saves != 2 {
+		t.Fatalf("expected a new snapshot after the window got %d", snapshots.saves)
+	}
+	snap, err := snapshots.Handler.Get(context.Background(), person.ID(), "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 3 {
+		t.Fatalf("expected snapshot version 3 got %d", snap.Version)
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	snapshots := memsnap.New(memsnap.WithRetention(5))
+	es := memory.Create()
+	repo := eventsourcing.NewRepository(es, eventsourcing.SnapshotNew(snapshots, *ser))
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.SaveSnapshot(person); err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.SaveSnapshot(person); err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		version, expectedVersion eventsourcing.Version
+		age                      int
+	}{
+		{1, 1, 0},
+		{2, 2, 1},
+		{3, 3, 2},
+		{10, 4, 3},
+	} {
+		p := Person{}
+		err = repo.GetVersion(context.Background(), person.ID(), &p, test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Version() != test.expectedVersion || p.Age != test.age {
+			t.Fatalf("expected version %d and age %d at version %d got version %d and age %d", test.expectedVersion, test.age, test.version, p.Version(), p.Age)
+		}
+	}
+
+	// version 0 is before the first event and does not mean the latest version
+	p := Person{}
+	err = repo.GetVersion(context.Background(), person.ID(), &p, 0)
+	if !errors.Is(err, eventsourcing.ErrInvalidVersion) {
+		t.Fatalf("expected ErrInvalidVersion got %v", err)
+	}
+
+	// without a snapshot store the aggregate is built from the events
+	p = Person{}
+	err = eventsourcing.NewRepository(es, nil).GetVersion(context.Background(), person.ID(), &p, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version() != 2 || p.Age != 1 {
+		t.Fatalf("expected version 2 and age 1 got version %d and age %d", p.Version(), p.Age)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	snapshots := &countingSnapshotStore{Handler: memsnap.New()}
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(snapshots, *ser))
+
+	// snapshotted and topped up with an event after the snapshot
+	snapshotted, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotted.GrowOlder()
+	if err = repo.Save(snapshotted); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.SaveSnapshot(snapshotted); err != nil {
+		t.Fatal(err)
+	}
+	snapshotted.GrowOlder()
+	if err = repo.Save(snapshotted); err != nil {
+		t.Fatal(err)
+	}
+	// no snapshot
+	plain, err := CreatePerson("anka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(plain); err != nil {
+		t.Fatal(err)
+	}
+	absent := eventsourcing.NewUuid()
+
+	aggregates, err := repo.GetMany(context.Background(), []uuid.UUID{snapshotted.ID(), plain.ID(), absent}, func() eventsourcing.Aggregate { return &Person{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggregates) != 2 {
+		t.Fatalf("expected 2 aggregates got %d", len(aggregates))
+	}
+	p := aggregates[snapshotted.ID()].(*Person)
+	if p.Age != 2 || p.Version() != 3 {
+		t.Fatalf("expected age 2 and version 3 got %d and %d", p.Age, p.Version())
+	}
+	if aggregates[plain.ID()].(*Person).Name != "anka" {
+		t.Fatal("expected the aggregate without snapshot to be built from events")
+	}
+	if snapshots.getManys != 1 || snapshots.gets != 0 {
+		t.Fatalf("expected one GetMany call got %d GetMany and %d Get", snapshots.getManys, snapshots.gets)
+	}
+}
+
+func TestMaxStreamLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		events int
+		err    error
+	}{
+		{"under", 2, nil},
+		{"at", 3, nil},
+		{"over", 4, eventsourcing.ErrStreamTooLong},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := eventsourcing.NewRepository(memory.Create(), nil)
+			repo.SetMaxStreamLength(3)
+			person, err := CreatePerson("kalle")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i < test.events; i++ {
+				person.GrowOlder()
+			}
+			err = repo.Save(person)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestMaxStreamLengthAfterSnapshot(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(memsnap.New(), *ser))
+	repo.SetMaxStreamLength(3)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.SaveSnapshot(person); err != nil {
+		t.Fatal(err)
+	}
+
+	// the aggregate loaded from the snapshot can have 3 more events
+	twin := Person{}
+	if err = repo.Get(person.ID(), &twin); err != nil {
+		t.Fatal(err)
+	}
+	twin.GrowOlder()
+	twin.GrowOlder()
+	twin.GrowOlder()
+	if err = repo.Save(&twin); err != nil {
+		t.Fatal(err)
+	}
+	twin.GrowOlder()
+	if err = repo.Save(&twin); !errors.Is(err, eventsourcing.ErrStreamTooLong) {
+		t.Fatalf("expected ErrStreamTooLong got %v", err)
+	}
+}
+
+// registeredTypesStore is a memory event store that knows the aggregate types registered in the serializer
+type registeredTypesStore struct {
+	*memory.Memory
+	serializer *eventsourcing.Serializer
+}
+
+func (r registeredTypesStore) AggregateRegistered(aggregateType string) bool {
+	return r.serializer.AggregateRegistered(aggregateType)
+}
+
+func TestGetUnregisteredAggregate(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	err := ser.Register(&Person{}, ser.Events(&Born{}, &AgedOneYear{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := eventsourcing.NewRepository(registeredTypesStore{Memory: memory.Create(), serializer: ser}, nil)
+
+	err = repo.Get(eventsourcing.NewUuid(), &snapshot{})
+	if !errors.Is(err, eventsourcing.ErrUnregisteredAggregate) {
+		t.Fatalf("expected ErrUnregisteredAggregate got %v", err)
+	}
+	err = repo.Get(eventsourcing.NewUuid(), &Person{})
+	if !errors.Is(err, eventsourcing.ErrAggregateNotFound) {
+		t.Fatalf("expected ErrAggregateNotFound got %v", err)
+	}
+}
+
+func TestConflictResolver(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	stale := Person{}
+	if err = repo.Get(person.ID(), &stale); err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	// the default resolver fails the save
+	stale.GrowOlder()
+	err = repo.Save(&stale)
+	if !errors.Is(err, eventsourcing.ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency got %v", err)
+	}
+
+	var stored []eventsourcing.Event
+	repo.SetConflictResolver(func(incoming, s []eventsourcing.Event) ([]eventsourcing.Event, error) {
+		stored = s
+		return eventsourcing.RebaseEvents(incoming, s[len(s)-1].Version), nil
+	})
+	err = repo.Save(&stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Version != 2 {
+		t.Fatalf("expected the stored tail to hold version 2 got %v", stored)
+	}
+	if stale.Version() != 3 {
+		t.Fatalf("expected the rebased aggregate on version 3 got %d", stale.Version())
+	}
+	counts, err := repo.EventCounts(context.Background(), "Person", person.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[person.ID()] != 3 {
+		t.Fatalf("expected 3 stored events got %d", counts[person.ID()])
+	}
+}
+
+func TestAppend(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []eventsourcing.Event
+	s := repo.Subscribers().All(func(e eventsourcing.Event) {
+		published = append(published, e)
+	})
+	defer s.Close()
+
+	err = repo.Append(context.Background(), person.ID(), "Person", &AgedOneYear{}, &AgedOneYear{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 2 {
+		t.Fatalf("expected 2 published events got %d", len(published))
+	}
+	if published[1].Version != 3 {
+		t.Fatalf("expected version 3 got %d", published[1].Version)
+	}
+
+	twin := Person{}
+	err = repo.Get(person.ID(), &twin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if twin.Version() != 3 {
+		t.Fatalf("expected version 3 got %d", twin.Version())
+	}
+	if twin.Age != person.Age+2 {
+		t.Fatalf("expected age %d got %d", person.Age+2, twin.Age)
+	}
+}
+
+// minimalEventStore is an event store with only Save and Get
+type minimalEventStore struct {
+	eventsourcing.EventStore
+}
+
+func TestAppendWithoutLatestVersionReader(t *testing.T) {
+	repo := eventsourcing.NewRepository(minimalEventStore{memory.Create()}, nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Append(context.Background(), person.ID(), "Person", &AgedOneYear{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	twin := Person{}
+	err = repo.Get(person.ID(), &twin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if twin.Version() != 3 {
+		t.Fatalf("expected version 3 got %d", twin.Version())
+	}
+}
+
+func TestAppendEmptyID(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	err := repo.Append(context.Background(), uuid.Nil, "Person", &AgedOneYear{})
+	if !errors.Is(err, eventsourcing.ErrEmptyID) {
+		t.Fatalf("expected ErrEmptyID got %v", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(memsnap.New(), *ser))
+	repo.SetCompaction(true)
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 9; i++ {
+		person.GrowOlder()
+	}
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Compact(context.Background(), person.ID(), &Person{}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, err := repo.EventCounts(context.Background(), "Person", person.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[person.ID()] != 3 {
+		t.Fatalf("expected 3 kept events got %d", counts[person.ID()])
+	}
+
+	twin := Person{}
+	err = repo.Get(person.ID(), &twin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if twin.Version() != person.Version() || twin.Age != person.Age || twin.Name != person.Name {
+		t.Fatalf("expected %d %d %s got %d %d %s", person.Version(), person.Age, person.Name, twin.Version(), twin.Age, twin.Name)
+	}
+
+	// events saved after the compaction are applied on the snapshot
+	twin.GrowOlder()
+	err = repo.Save(&twin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := Person{}
+	err = repo.Get(person.ID(), &again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Age != person.Age+1 {
+		t.Fatalf("expected age %d got %d", person.Age+1, again.Age)
+	}
+}
+
+func TestCompactGuards(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	repo := eventsourcing.NewRepository(memory.Create(), eventsourcing.SnapshotNew(memsnap.New(), *ser))
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Compact(context.Background(), person.ID(), &Person{}, 1)
+	if !errors.Is(err, eventsourcing.ErrCompactionDisabled) {
+		t.Fatalf("expected ErrCompactionDisabled got %v", err)
+	}
+	repo.SetCompaction(true)
+	err = repo.Compact(context.Background(), person.ID(), &Person{}, 2)
+	if !errors.Is(err, eventsourcing.ErrCompactBeyondSnapshot) {
+		t.Fatalf("expected ErrCompactBeyondSnapshot got %v", err)
+	}
+}
+
+// cancelingStore cancels the context when the iterator has returned after events
+type cancelingStore struct {
+	eventsourcing.EventStore
+	cancel context.CancelFunc
+	after  int
+}
+
+func (c *cancelingStore) Get(ctx context.Context, id uuid.UUID, aggregateType string, afterVersion eventsourcing.Version) (eventsourcing.EventIterator, error) {
+	iterator, err := c.EventStore.Get(ctx, id, aggregateType, afterVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &cancelingIterator{EventIterator: iterator, store: c}, nil
+}
+
+type cancelingIterator struct {
+	eventsourcing.EventIterator
+	store *cancelingStore
+	count int
+}
+
+func (c *cancelingIterator) Next() (eventsourcing.Event, error) {
+	c.count++
+	if c.count == c.store.after {
+		c.store.cancel()
+	}
+	return c.EventIterator.Next()
+}
+
+func TestGetBestEffort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := &cancelingStore{EventStore: memory.Create(), cancel: cancel, after: 3}
+	repo := eventsourcing.NewRepository(store, nil)
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 9; i++ {
+		person.GrowOlder()
+	}
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	twin := Person{}
+	version, err := repo.GetBestEffort(ctx, person.ID(), &twin)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+	if version != 3 {
+		t.Fatalf("expected version 3 got %d", version)
+	}
+	if twin.Name != "kalle" || twin.Age != 2 {
+		t.Fatalf("expected the aggregate built up to version 3 got %s %d", twin.Name, twin.Age)
+	}
+}
+
+// failingSink fails to forward all events
+type failingSink struct{}
+
+func (failingSink) Publish(ctx context.Context, event eventsourcing.Event) error {
+	return errors.New("bus down")
+}
+
+func TestAddSink(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	sink := &eventsourcing.MemorySink{}
+	repo.AddSink(failingSink{})
+	repo.AddSink(sink)
+	var lock sync.Mutex
+	var failed []eventsourcing.Event
+	repo.SetSubscriberErrorHandler(func(event eventsourcing.Event, err error) {
+		if !errors.Is(err, eventsourcing.ErrSinkPublish) {
+			t.Errorf("expected ErrSinkPublish got %v", err)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		failed = append(failed, event)
+	})
+	var forwardedBefore []int
+	repo.Subscribers().All(func(e eventsourcing.Event) {
+		forwardedBefore = append(forwardedBefore, len(sink.Events()))
+	})
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	saved := person.Events()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+
+	// the sinks forward the events from their own goroutines
+	var forwarded []eventsourcing.Event
+	var failedCount int
+	for i := 0; i < 100; i++ {
+		forwarded = sink.Events()
+		lock.Lock()
+		failedCount = len(failed)
+		lock.Unlock()
+		if len(forwarded) == len(saved) && failedCount == len(saved) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(forwarded) != len(saved) {
+		t.Fatalf("expected %d forwarded events got %d", len(saved), len(forwarded))
+	}
+	for i := range saved {
+		if forwarded[i].EventID != saved[i].EventID || forwarded[i].Reason() != saved[i].Reason() {
+			t.Fatalf("expected forwarded event %d to match the saved event", i)
+		}
+	}
+	if failedCount != len(saved) {
+		t.Fatalf("expected the failing sink to report %d events got %d", len(saved), failedCount)
+	}
+	// the subscriber gets the event before it's queued to the sinks
+	if len(forwardedBefore) != 2 || forwardedBefore[0] != 0 {
+		t.Fatalf("expected the subscriber to be called before the sinks got %v", forwardedBefore)
+	}
+}
+
+// blockingSink blocks the forward of every event until release is closed
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (b blockingSink) Publish(ctx context.Context, event eventsourcing.Event) error {
+	<-b.release
+	return nil
+}
+
+func TestAddSinkBlocked(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory.Create(), nil)
+	sink := blockingSink{release: make(chan struct{})}
+	defer close(sink.release)
+	sub := repo.AddSink(sink)
+	defer sub.Close()
+
+	// a blocked sink does not hold up the save
+	done := make(chan error)
+	go func() {
+		person, err := CreatePerson("kalle")
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- repo.Save(person)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the save to not wait on the blocked sink")
+	}
+}
@@ -2,26 +2,70 @@ package eventsourcing
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"sort"
 )
 
 type eventFunc = func() interface{}
 type MarshalSnapshotFunc func(v interface{}) ([]byte, error)
 type UnmarshalSnapshotFunc func(data []byte, v interface{}) error
 
+// UnknownEventPolicy decides what happens when an event store reads an event with a type/reason
+// that is not registered. If it returns nil the event is skipped, otherwise the error is returned from the read.
+type UnknownEventPolicy func(typ, reason string) error
+
+var (
+	// UnknownEventSkip skips unregistered events without notice (default)
+	UnknownEventSkip UnknownEventPolicy = func(typ, reason string) error { return nil }
+
+	// UnknownEventError fails the read with ErrUnknownEventType. It's the recommended policy as a
+	// missing Register call is caught immediately instead of building aggregates with wrong state.
+	UnknownEventError UnknownEventPolicy = func(typ, reason string) error {
+		return fmt.Errorf("%w: %s_%s", ErrUnknownEventType, typ, reason)
+	}
+)
+
+// UnknownEventCallback skips unregistered events after calling f, it could be used to log the skipped events
+func UnknownEventCallback(f func(typ, reason string)) UnknownEventPolicy {
+	return func(typ, reason string) error {
+		f(typ, reason)
+		return nil
+	}
+}
+
+// format is a marshal/unmarshal pair registered for an aggregate type
+type format struct {
+	marshal   MarshalSnapshotFunc
+	unmarshal UnmarshalSnapshotFunc
+}
+
 // Serializer for json serializes
 type Serializer struct {
-	eventRegister map[string]eventFunc
-	marshal       MarshalSnapshotFunc
-	unmarshal     UnmarshalSnapshotFunc
+	eventRegister  map[string]eventFunc
+	marshal        MarshalSnapshotFunc
+	unmarshal      UnmarshalSnapshotFunc
+	onUnknownEvent UnknownEventPolicy
+	// formats holds the codec of the event data per aggregate type, codecs the functions per codec
+	formats map[string]string
+	codecs  map[string]format
+	// aggregates holds the registered event reasons per aggregate type
+	aggregates map[string]map[string]struct{}
+	// enums holds the allowed values per registered enum type
+	enums map[reflect.Type]map[interface{}]struct{}
 }
 
 // NewSerializer returns a json Handle
 func NewSerializer(marshalF MarshalSnapshotFunc, unmarshalF UnmarshalSnapshotFunc) *Serializer {
 	return &Serializer{
-		eventRegister: make(map[string]eventFunc),
-		marshal:       marshalF,
-		unmarshal:     unmarshalF,
+		eventRegister:  make(map[string]eventFunc),
+		marshal:        marshalF,
+		unmarshal:      unmarshalF,
+		onUnknownEvent: UnknownEventSkip,
+		formats:        make(map[string]string),
+		codecs:         make(map[string]format),
+		aggregates:     make(map[string]map[string]struct{}),
+		enums:          make(map[reflect.Type]map[interface{}]struct{}),
 	}
 }
 
@@ -34,10 +78,25 @@ var (
 
 	// ErrEventNameMissing return if Event name is missing
 	ErrEventNameMissing = errors.New("missing event name")
+
+	// ErrUnknownEventType return if an event type/reason is not registered and the UnknownEventError policy is used
+	ErrUnknownEventType = errors.New("unknown event type")
+
+	// ErrUnknownFormat return if event data is stored in a format that is not registered
+	ErrUnknownFormat = errors.New("unknown format")
+
+	// ErrInvalidEnumValue return if event data holds a value of a registered enum type that is not allowed
+	ErrInvalidEnumValue = errors.New("invalid enum value")
 )
 
+// event returns a function creating a new value of the event type on each call, concurrent reads then unmarshal
+// into their own value
 func event(event interface{}) eventFunc {
-	return func() interface{} { return event }
+	t := reflect.TypeOf(event)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return func() interface{} { return event }
+	}
+	return func() interface{} { return reflect.New(t.Elem()).Interface() }
 }
 
 // Events is a helper function to make the event type registration simpler
@@ -52,7 +111,7 @@ func (h *Serializer) Events(events ...interface{}) []eventFunc {
 // Register will hold a map of aggregate_event to be able to set the currect type when
 // the data is unmarhaled.
 func (h *Serializer) Register(aggregate Aggregate, events []eventFunc) error {
-	typ := reflect.TypeOf(aggregate).Elem().Name()
+	typ := aggregateName(aggregate)
 	if typ == "" {
 		return ErrAggregateNameMissing
 	}
@@ -60,6 +119,10 @@ func (h *Serializer) Register(aggregate Aggregate, events []eventFunc) error {
 		return ErrNoEventsToRegister
 	}
 
+	reasons, ok := h.aggregates[typ]
+	if !ok {
+		reasons = make(map[string]struct{})
+	}
 	for _, f := range events {
 		event := f()
 		reason := reflect.TypeOf(event).Elem().Name()
@@ -67,10 +130,43 @@ func (h *Serializer) Register(aggregate Aggregate, events []eventFunc) error {
 			return ErrEventNameMissing
 		}
 		h.eventRegister[typ+"_"+reason] = f
+		reasons[reason] = struct{}{}
 	}
+	h.aggregates[typ] = reasons
 	return nil
 }
 
+// RegisteredTypes returns the sorted aggregate types with registered events
+func (h *Serializer) RegisteredTypes() []string {
+	types := make([]string, 0, len(h.aggregates))
+	for typ := range h.aggregates {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// RegisteredEvents returns the sorted event reasons registered for the aggregate type, nil if the aggregate type
+// is not registered
+func (h *Serializer) RegisteredEvents(aggregateType string) []string {
+	reasons, ok := h.aggregates[aggregateType]
+	if !ok {
+		return nil
+	}
+	result := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		result = append(result, reason)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// AggregateRegistered tells if events of the aggregate type are registered
+func (h *Serializer) AggregateRegistered(typ string) bool {
+	_, ok := h.aggregates[typ]
+	return ok
+}
+
 // RegisterTypes events aggregate
 func (h *Serializer) RegisterTypes(aggregate Aggregate, events ...eventFunc) error {
 	return h.Register(aggregate, events)
@@ -82,6 +178,106 @@ func (h *Serializer) Type(typ, reason string) (eventFunc, bool) {
 	return d, ok
 }
 
+// OnUnknownEvent sets the policy used when an event store reads an event that is not registered
+func (h *Serializer) OnUnknownEvent(policy UnknownEventPolicy) {
+	h.onUnknownEvent = policy
+}
+
+// UnknownEvent is called by event stores when the type/reason is not registered. A nil error means
+// that the event should be skipped.
+func (h *Serializer) UnknownEvent(typ, reason string) error {
+	if h.onUnknownEvent == nil {
+		return nil
+	}
+	return h.onUnknownEvent(typ, reason)
+}
+
+// RegisterFormat sets the marshal and unmarshal functions used for the event data of the aggregate type. The codec
+// identifies the format, e.g. "protobuf", and is the tag stored with the data. It must not be empty, the empty tag
+// is the default format. Events stored with another format, the default or an earlier registered codec, are still
+// read as long as the event store keeps the format tag, which makes it possible for two formats to coexist in a
+// stream during a migration.
+func (h *Serializer) RegisterFormat(typ, codec string, marshal MarshalSnapshotFunc, unmarshal UnmarshalSnapshotFunc) {
+	h.formats[typ] = codec
+	h.codecs[codec] = format{marshal: marshal, unmarshal: unmarshal}
+}
+
+// MarshalFormat marshals the event data with the format registered for the aggregate type and returns the format
+// tag to store with the data. The empty tag is the default format.
+func (h *Serializer) MarshalFormat(typ string, v interface{}) ([]byte, string, error) {
+	codec, ok := h.formats[typ]
+	if !ok {
+		b, err := h.marshal(v)
+		return b, "", err
+	}
+	b, err := h.codecs[codec].marshal(v)
+	return b, codec, err
+}
+
+// UnmarshalFormat unmarshals event data with the format of the tag returned from MarshalFormat
+func (h *Serializer) UnmarshalFormat(tag string, data []byte, v interface{}) error {
+	if tag == "" {
+		err := h.unmarshal(data, v)
+		if err != nil {
+			return err
+		}
+		return h.validateEnums(reflect.ValueOf(v))
+	}
+	f, ok := h.codecs[tag]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, tag)
+	}
+	err := f.unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+	return h.validateEnums(reflect.ValueOf(v))
+}
+
+// RegisterEnum registers the allowed values of an enum type, the type is taken from the values. Event data read
+// with UnmarshalFormat is checked and a field of the type holding another value returns ErrInvalidEnumValue.
+func (h *Serializer) RegisterEnum(allowed ...interface{}) {
+	for _, value := range allowed {
+		t := reflect.TypeOf(value)
+		if h.enums[t] == nil {
+			h.enums[t] = make(map[interface{}]struct{})
+		}
+		h.enums[t][value] = struct{}{}
+	}
+}
+
+// validateEnums checks the fields of registered enum types in v and in its nested structs
+func (h *Serializer) validateEnums(v reflect.Value) error {
+	if len(h.enums) == 0 {
+		return nil
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanInterface() {
+			continue
+		}
+		if allowed, ok := h.enums[field.Type()]; ok {
+			if _, ok := allowed[field.Interface()]; !ok {
+				return fmt.Errorf("%w: %s.%s %v", ErrInvalidEnumValue, v.Type().Name(), v.Type().Field(i).Name, field.Interface())
+			}
+			continue
+		}
+		if err := h.validateEnums(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Marshal pass the request to the under laying Marshal method
 func (h *Serializer) Marshal(v interface{}) ([]byte, error) {
 	return h.marshal(v)
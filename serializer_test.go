@@ -2,12 +2,14 @@ package eventsourcing_test
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"reflect"
 	"sync"
 	"testing"
 
 	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/suite"
 )
 
 func initSerializers(t *testing.T) []*eventsourcing.Serializer {
@@ -128,3 +130,206 @@ func TestConcurrentUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestTypeReturnsNewValue(t *testing.T) {
+	s := initSerializers(t)[0]
+	f, ok := s.Type("SomeAggregate", "SomeData")
+	if !ok {
+		t.Fatal("could not find event type registered for SomeAggregate/SomeData")
+	}
+	a, b := f().(*SomeData), f().(*SomeData)
+	if a == b {
+		t.Fatal("expected a new value per call to not share it between concurrent reads")
+	}
+}
+
+func TestUnknownEventPolicy(t *testing.T) {
+	s := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	if err := s.UnknownEvent("SomeAggregate", "Unknown"); err != nil {
+		t.Fatalf("default policy should skip unknown events got %v", err)
+	}
+
+	s.OnUnknownEvent(eventsourcing.UnknownEventError)
+	err := s.UnknownEvent("SomeAggregate", "Unknown")
+	if !errors.Is(err, eventsourcing.ErrUnknownEventType) {
+		t.Fatalf("expected ErrUnknownEventType got %v", err)
+	}
+
+	var skipped string
+	s.OnUnknownEvent(eventsourcing.UnknownEventCallback(func(typ, reason string) {
+		skipped = typ + "_" + reason
+	}))
+	if err := s.UnknownEvent("SomeAggregate", "Unknown"); err != nil {
+		t.Fatalf("callback policy should skip unknown events got %v", err)
+	}
+	if skipped != "SomeAggregate_Unknown" {
+		t.Fatalf("expected callback with SomeAggregate_Unknown got %q", skipped)
+	}
+}
+
+func TestRegisteredEvents(t *testing.T) {
+	s := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	err := s.Register(&suite.FrequentFlierAccount{}, s.Events(&suite.FrequentFlierAccountCreated{}, &suite.FlightTaken{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Register(&suite.FrequentFlierAccount{}, s.Events(&suite.StatusMatched{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Register(&SomeAggregate{}, s.Events(&SomeData{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := s.RegisteredTypes()
+	if !reflect.DeepEqual(types, []string{"FrequentFlierAccount", "SomeAggregate"}) {
+		t.Fatalf("unexpected registered types %v", types)
+	}
+	events := s.RegisteredEvents("FrequentFlierAccount")
+	if !reflect.DeepEqual(events, []string{"FlightTaken", "FrequentFlierAccountCreated", "StatusMatched"}) {
+		t.Fatalf("unexpected registered events %v", events)
+	}
+	if events := s.RegisteredEvents("Missing"); events != nil {
+		t.Fatalf("expected no events for unregistered type got %v", events)
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	s := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	s.RegisterFormat("SomeAggregate", "xml", xml.Marshal, xml.Unmarshal)
+
+	b, tag, err := s.MarshalFormat("SomeAggregate", &SomeData{A: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "xml" || b[0] != '<' {
+		t.Fatalf("expected xml tagged xml got %s %s", tag, b)
+	}
+	data := SomeData{}
+	err = s.UnmarshalFormat(tag, b, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.A != 1 {
+		t.Fatalf("expected 1 got %d", data.A)
+	}
+
+	// other aggregate types uses the default format
+	b, tag, err = s.MarshalFormat("Other", &SomeData{A: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "" || b[0] != '{' {
+		t.Fatalf("expected untagged json got %q %s", tag, b)
+	}
+
+	err = s.UnmarshalFormat("Missing", b, &data)
+	if !errors.Is(err, eventsourcing.ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat got %v", err)
+	}
+
+	// data stored with an earlier codec of the aggregate type is still readable
+	xmlData, _, err := s.MarshalFormat("SomeAggregate", &SomeData{A: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterFormat("SomeAggregate", "json", json.Marshal, json.Unmarshal)
+	_, tag, err = s.MarshalFormat("SomeAggregate", &SomeData{A: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "json" {
+		t.Fatalf("expected the json tag got %q", tag)
+	}
+	err = s.UnmarshalFormat("xml", xmlData, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.A != 3 {
+		t.Fatalf("expected 3 got %d", data.A)
+	}
+}
+
+func TestRegisterEnum(t *testing.T) {
+	s := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	err := s.Register(&suite.FrequentFlierAccount{}, s.Events(&suite.StatusMatched{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterEnum(suite.StatusRed, suite.StatusSilver, suite.StatusGold)
+
+	f, ok := s.Type("FrequentFlierAccount", "StatusMatched")
+	if !ok {
+		t.Fatal("StatusMatched not registered")
+	}
+	b, tag, err := s.MarshalFormat("FrequentFlierAccount", &suite.StatusMatched{NewStatus: suite.StatusGold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := f()
+	if err = s.UnmarshalFormat(tag, b, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.(*suite.StatusMatched).NewStatus != suite.StatusGold {
+		t.Fatalf("expected StatusGold got %v", data)
+	}
+
+	b, tag, err = s.MarshalFormat("FrequentFlierAccount", &suite.StatusMatched{NewStatus: 99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = f()
+	err = s.UnmarshalFormat(tag, b, &data)
+	if !errors.Is(err, eventsourcing.ErrInvalidEnumValue) {
+		t.Fatalf("expected ErrInvalidEnumValue got %v", err)
+	}
+}
+
+func TestNewJSONSerializer(t *testing.T) {
+	data := SomeData{A: 1, B: "<b>"}
+	expected, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := eventsourcing.NewJSONSerializer().Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(expected) {
+		t.Fatalf("expected the default to match json.Marshal, %s != %s", b, expected)
+	}
+
+	s := eventsourcing.NewJSONSerializer(eventsourcing.JSONEscapeHTML(false), eventsourcing.JSONUseNumber(), eventsourcing.JSONDisallowUnknownFields())
+	b, err = s.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"A":1,"B":"<b>"}` {
+		t.Fatalf("expected unescaped html got %s", b)
+	}
+	m := map[string]interface{}{}
+	err = s.Unmarshal(b, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m["A"].(json.Number); !ok {
+		t.Fatalf("expected json.Number got %T", m["A"])
+	}
+	err = s.Unmarshal([]byte(`{"A":1,"C":2}`), &SomeData{})
+	if err == nil {
+		t.Fatal("expected error on unknown field")
+	}
+
+	// trailing data is rejected as by json.Unmarshal
+	for _, data := range []string{`{"A":1} {"A":2}`, `{"A":1}x`} {
+		err = eventsourcing.NewJSONSerializer().Unmarshal([]byte(data), &SomeData{})
+		if err == nil {
+			t.Fatalf("expected error on trailing data in %s", data)
+		}
+	}
+	err = eventsourcing.NewJSONSerializer().Unmarshal([]byte("{\"A\":1}\n"), &SomeData{})
+	if err != nil {
+		t.Fatalf("expected trailing whitespace to be allowed got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package eventsourcing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// ErrEventTampered returns when the signature of a stored event does not match its content
+var ErrEventTampered = errors.New("event signature mismatch")
+
+// Signer signs the stored form of events and verifies the signature when they are read. Implementations can keep
+// the keys in a HSM or KMS.
+type Signer interface {
+	Sign(payload []byte) ([]byte, error)
+	// Verify returns ErrEventTampered if the signature is not valid for the payload
+	Verify(payload, signature []byte) error
+}
+
+// HMACSigner signs with HMAC-SHA256
+type HMACSigner struct {
+	key []byte
+}
+
+// NewHMACSigner returns a signer that uses the key for HMAC-SHA256
+func NewHMACSigner(key []byte) *HMACSigner {
+	return &HMACSigner{key: key}
+}
+
+// Sign returns the HMAC of the payload
+func (s *HMACSigner) Sign(payload []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+// Verify compares the HMAC of the payload with the signature in constant time
+func (s *HMACSigner) Verify(payload, signature []byte) error {
+	expected, err := s.Sign(payload)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, signature) {
+		return ErrEventTampered
+	}
+	return nil
+}
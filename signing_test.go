@@ -0,0 +1,27 @@
+package eventsourcing_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hallgren/eventsourcing"
+)
+
+func TestHMACSigner(t *testing.T) {
+	signer := eventsourcing.NewHMACSigner([]byte("secret"))
+	signature, err := signer.Sign([]byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = signer.Verify([]byte("payload"), signature); err != nil {
+		t.Fatalf("expected valid signature got %v", err)
+	}
+	err = signer.Verify([]byte("changed"), signature)
+	if !errors.Is(err, eventsourcing.ErrEventTampered) {
+		t.Fatalf("expected ErrEventTampered got %v", err)
+	}
+	err = eventsourcing.NewHMACSigner([]byte("other")).Verify([]byte("payload"), signature)
+	if !errors.Is(err, eventsourcing.ErrEventTampered) {
+		t.Fatalf("expected ErrEventTampered with another key got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package eventsourcing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrSinkPublish wraps the error returned from a sink when an event could not be forwarded
+var ErrSinkPublish = errors.New("sink publish")
+
+// ErrSinkBufferFull is passed to the error handler when an event is dropped because the buffer of a sink is full
+var ErrSinkBufferFull = errors.New("sink buffer full")
+
+// sinkBufferSize is the number of events that can wait to be forwarded to a sink
+const sinkBufferSize = 1024
+
+// PublishSink forwards the published events to a system outside the process, e.g. a message bus
+type PublishSink interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// sinkEvent is an event waiting in the buffer of a sink
+type sinkEvent struct {
+	ctx   context.Context
+	event Event
+}
+
+// AddSink forwards every published event to the sink. The events are queued after the in-process subscribers got
+// them and forwarded in publish order from a goroutine, a slow sink does not hold up saves or subscribers. When the
+// buffer of sinkBufferSize events is full the event is dropped and passed to the error handler as
+// ErrSinkBufferFull. A failed forward is logged and passed to the error handler as ErrSinkPublish and does not stop
+// the delivery to the other sinks. Close the returned subscription to remove the sink, the queued events are still
+// forwarded.
+func (e *EventStream) AddSink(sink PublishSink) *subscription {
+	queue := make(chan sinkEvent, sinkBufferSize)
+	s := subscription{}
+	s.eventF = func(ctx context.Context, event Event) {
+		select {
+		case queue <- sinkEvent{ctx: detachedContext{ctx}, event: event}:
+		default:
+			// called with the lock held, the logger and error handler can be used directly
+			err := fmt.Errorf("%w: %s %s", ErrSinkBufferFull, event.AggregateID, event.Reason())
+			e.logger.Error("sink publish", "aggregate_type", event.AggregateType, "aggregate_id", event.AggregateID, "reason", event.Reason(), "error", err)
+			if e.onError != nil {
+				e.onError(event, err)
+			}
+		}
+	}
+	s.close = func() {
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		if s.eventF == nil {
+			return
+		}
+		s.eventF = nil
+		e.sinks = clean(e.sinks)
+		close(queue)
+	}
+	go func() {
+		for q := range queue {
+			err := sink.Publish(q.ctx, q.event)
+			if err == nil {
+				continue
+			}
+			err = fmt.Errorf("%w: %v", ErrSinkPublish, err)
+			e.lock.Lock()
+			logger, onError := e.logger, e.onError
+			e.lock.Unlock()
+			logger.Error("sink publish", "aggregate_type", q.event.AggregateType, "aggregate_id", q.event.AggregateID, "reason", q.event.Reason(), "error", err)
+			if onError != nil {
+				onError(q.event, err)
+			}
+		}
+	}()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.sinks = append(e.sinks, &s)
+	return &s
+}
+
+// detachedContext keeps the values of the publish context but not its cancellation, the event is forwarded after
+// the save returned
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// AddSink forwards the events saved in the repository to the sink, see EventStream.AddSink
+func (r *Repository) AddSink(sink PublishSink) *subscription {
+	return r.eventStream.AddSink(sink)
+}
+
+// MemorySink keeps the forwarded events in memory, it's meant for tests
+type MemorySink struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+// Publish appends the event to the sink
+func (m *MemorySink) Publish(ctx context.Context, event Event) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+// Events returns the forwarded events in the order they were published
+func (m *MemorySink) Events() []Event {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]Event(nil), m.events...)
+}
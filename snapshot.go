@@ -14,12 +14,17 @@ var ErrEmptyID = errors.New("aggregate id is empty")
 // ErrUnsavedEvents aggregate events must be saved before creating snapshot
 var ErrUnsavedEvents = errors.New("aggregate holds unsaved events")
 
+// ErrNotAnAggregate returns from the snapshot handler when the value does not implement Aggregate
+var ErrNotAnAggregate = errors.New("not an aggregate")
+
 // Snapshot holds current state of an aggregate
 type Snapshot struct {
-	ID      uuid.UUID
-	Type    string
-	State   []byte
-	Version Version
+	ID            uuid.UUID
+	Type          string
+	State         []byte
+	Version       Version
+	GlobalVersion uuid.UUID
+	SchemaVersion int
 }
 
 // SnapshotAggregate is an Aggregate plus extra methods to help serialize into a snapshot
@@ -29,10 +34,24 @@ type SnapshotAggregate interface {
 	Unmarshal(m UnmarshalSnapshotFunc, b []byte) error
 }
 
+// SnapshotSchemaVersioner is implemented by aggregates that version the shape of their snapshot state.
+// Aggregates not implementing it have schema version 0.
+type SnapshotSchemaVersioner interface {
+	SnapshotSchemaVersion() int
+}
+
+// SnapshotMigrationFunc transforms snapshot state from one schema version to the next
+type SnapshotMigrationFunc func(state []byte) ([]byte, error)
+
 // SnapshotHandler gets and saves snapshots
 type SnapshotHandler struct {
 	snapshotStore SnapshotStore
 	serializer    Serializer
+	// migrations holds the migration functions per aggregate type and from schema version
+	migrations map[string]map[int]SnapshotMigrationFunc
+	// unmarshalFallback makes a snapshot that fails to unmarshal count as not found
+	unmarshalFallback bool
+	logger            Logger
 }
 
 // SnapshotNew constructs a SnapshotHandler
@@ -40,7 +59,31 @@ func SnapshotNew(ss SnapshotStore, ser Serializer) *SnapshotHandler {
 	return &SnapshotHandler{
 		snapshotStore: ss,
 		serializer:    ser,
+		migrations:    make(map[string]map[int]SnapshotMigrationFunc),
+		logger:        NoopLogger{},
+	}
+}
+
+// SetUnmarshalFallback makes a snapshot that fails to unmarshal, e.g. after the shape of the aggregate changed,
+// count as not found. The failure is logged and the repository builds the aggregate from its events instead of
+// returning the error, which makes a deploy with a changed aggregate non-breaking.
+func (s *SnapshotHandler) SetUnmarshalFallback(enabled bool) {
+	s.unmarshalFallback = enabled
+}
+
+// SetLogger sets the logger used by the snapshot handler
+func (s *SnapshotHandler) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+// RegisterSnapshotMigration registers a function that transforms the snapshot state of the aggregate type typ
+// from schema version from to from+1. Migrations are chained on Get until the state reaches the aggregate's
+// current schema version.
+func (s *SnapshotHandler) RegisterSnapshotMigration(typ string, from int, fn func([]byte) ([]byte, error)) {
+	if _, ok := s.migrations[typ]; !ok {
+		s.migrations[typ] = make(map[int]SnapshotMigrationFunc)
 	}
+	s.migrations[typ][from] = fn
 }
 
 // Save transform an aggregate to a snapshot
@@ -53,7 +96,7 @@ func (s *SnapshotHandler) Save(i interface{}) error {
 	if ok {
 		return s.saveAggregate(a)
 	}
-	return errors.New("not an aggregate")
+	return ErrNotAnAggregate
 }
 
 func (s *SnapshotHandler) saveSnapshotAggregate(sa SnapshotAggregate) error {
@@ -62,16 +105,18 @@ func (s *SnapshotHandler) saveSnapshotAggregate(sa SnapshotAggregate) error {
 	if err != nil {
 		return err
 	}
-	typ := reflect.TypeOf(sa).Elem().Name()
+	typ := aggregateName(sa)
 	b, err := sa.Marshal(s.serializer.Marshal)
 	if err != nil {
 		return err
 	}
 	snap := Snapshot{
-		ID:      root.ID(),
-		Type:    typ,
-		Version: root.Version(),
-		State:   b,
+		ID:            root.ID(),
+		Type:          typ,
+		Version:       root.Version(),
+		GlobalVersion: root.GlobalVersion(),
+		SchemaVersion: schemaVersion(sa),
+		State:         b,
 	}
 	return s.snapshotStore.Save(snap)
 }
@@ -82,48 +127,172 @@ func (s *SnapshotHandler) saveAggregate(sa Aggregate) error {
 	if err != nil {
 		return err
 	}
-	typ := reflect.TypeOf(sa).Elem().Name()
-	b, err := s.serializer.Marshal(sa)
+	typ := aggregateName(sa)
+	b, err := marshalState(s.serializer.Marshal, sa)
 	if err != nil {
 		return err
 	}
 	snap := Snapshot{
-		ID:      root.ID(),
-		Type:    typ,
-		Version: root.Version(),
-		State:   b,
+		ID:            root.ID(),
+		Type:          typ,
+		Version:       root.Version(),
+		GlobalVersion: root.GlobalVersion(),
+		SchemaVersion: schemaVersion(sa),
+		State:         b,
 	}
 	return s.snapshotStore.Save(snap)
 }
 
 // Get fetch a snapshot and reconstruct an aggregate
 func (s *SnapshotHandler) Get(ctx context.Context, id uuid.UUID, i interface{}) error {
-	typ := reflect.TypeOf(i).Elem().Name()
+	if _, ok := i.(Aggregate); !ok {
+		return ErrNotAnAggregate
+	}
+	typ := aggregateName(i)
 	snap, err := s.snapshotStore.Get(ctx, id, typ)
 	if err != nil {
 		return err
 	}
+	return s.restore(snap, i)
+}
+
+// getAt fetch the newest snapshot at or below the version and reconstruct the aggregate. Stores without history
+// only have the latest snapshot, it's used if it's not beyond the version.
+func (s *SnapshotHandler) getAt(ctx context.Context, id uuid.UUID, i interface{}, version Version) error {
+	if _, ok := i.(Aggregate); !ok {
+		return ErrNotAnAggregate
+	}
+	typ := aggregateName(i)
+	var snap Snapshot
+	var err error
+	if store, ok := s.snapshotStore.(SnapshotHistoryStore); ok {
+		snap, err = store.GetAt(ctx, id, typ, version)
+	} else {
+		snap, err = s.snapshotStore.Get(ctx, id, typ)
+		if err == nil && snap.Version > version {
+			err = ErrSnapshotNotFound
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return s.restore(snap, i)
+}
+
+// getMany fetch the snapshots of the aggregates in one call if the store implements BatchSnapshotStore, the
+// returned map only holds the found snapshots
+func (s *SnapshotHandler) getMany(ctx context.Context, ids []uuid.UUID, typ string) (map[uuid.UUID]Snapshot, error) {
+	if store, ok := s.snapshotStore.(BatchSnapshotStore); ok {
+		return store.GetMany(ctx, ids, typ)
+	}
+	snapshots := make(map[uuid.UUID]Snapshot)
+	for _, id := range ids {
+		snap, err := s.snapshotStore.Get(ctx, id, typ)
+		if errors.Is(err, ErrSnapshotNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		snapshots[id] = snap
+	}
+	return snapshots, nil
+}
+
+// restore reconstructs the aggregate from the snapshot, with the unmarshal fallback a failed unmarshal resets the
+// aggregate and returns ErrSnapshotNotFound
+func (s *SnapshotHandler) restore(snap Snapshot, i interface{}) error {
+	err := s.unmarshal(snap, i)
+	var unmarshalErr *snapshotUnmarshalError
+	if !errors.As(err, &unmarshalErr) {
+		return err
+	}
+	if !s.unmarshalFallback {
+		return unmarshalErr.err
+	}
+	s.logger.Warn("snapshot unmarshal failed, building from events", "aggregate_type", snap.Type, "aggregate_id", snap.ID, "version", snap.Version, "error", unmarshalErr.err)
+	// the failed unmarshal could have set part of the state
+	if v := reflect.ValueOf(i); v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+	return ErrSnapshotNotFound
+}
+
+// snapshotUnmarshalError marks an error from unmarshalling the snapshot state
+type snapshotUnmarshalError struct {
+	err error
+}
+
+func (e *snapshotUnmarshalError) Error() string {
+	return e.err.Error()
+}
+
+// unmarshal sets the aggregate state from the snapshot
+func (s *SnapshotHandler) unmarshal(snap Snapshot, i interface{}) error {
+	var err error
+	snap.State, err = s.migrate(snap, schemaVersion(i))
+	if err != nil {
+		return err
+	}
 	switch a := i.(type) {
 	case SnapshotAggregate:
 		err := a.Unmarshal(s.serializer.Unmarshal, snap.State)
 		if err != nil {
-			return err
+			return &snapshotUnmarshalError{err: err}
 		}
 		root := a.Root()
-		root.setInternals(snap.ID, snap.Version)
+		root.setInternals(aggregatePath(a), snap.ID, snap.Version, snap.GlobalVersion)
 	case Aggregate:
-		err = s.serializer.Unmarshal(snap.State, a)
+		err = unmarshalState(s.serializer.Unmarshal, snap.State, a)
 		if err != nil {
-			return err
+			return &snapshotUnmarshalError{err: err}
 		}
 		root := a.Root()
-		root.setInternals(snap.ID, snap.Version)
+		root.setInternals(aggregatePath(a), snap.ID, snap.Version, snap.GlobalVersion)
 	default:
-		return errors.New("not an aggregate")
+		return ErrNotAnAggregate
 	}
 	return nil
 }
 
+// Delete removes the snapshot of the aggregate, ErrSnapshotDeleteNotSupported is returned if the snapshot store
+// does not implement SnapshotDeleter
+func (s *SnapshotHandler) Delete(ctx context.Context, id uuid.UUID, i interface{}) error {
+	store, ok := s.snapshotStore.(SnapshotDeleter)
+	if !ok {
+		return ErrSnapshotDeleteNotSupported
+	}
+	return store.Delete(ctx, id, aggregateName(i))
+}
+
+// migrate transforms the snapshot state to the current schema version. If the snapshot can't be
+// migrated ErrSnapshotNotFound is returned to make the aggregate rebuild from its events.
+func (s *SnapshotHandler) migrate(snap Snapshot, current int) ([]byte, error) {
+	if snap.SchemaVersion > current {
+		return nil, ErrSnapshotNotFound
+	}
+	state := snap.State
+	for v := snap.SchemaVersion; v < current; v++ {
+		f, ok := s.migrations[snap.Type][v]
+		if !ok {
+			return nil, ErrSnapshotNotFound
+		}
+		var err error
+		state, err = f(state)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// schemaVersion returns the snapshot schema version of the aggregate
+func schemaVersion(i interface{}) int {
+	if v, ok := i.(SnapshotSchemaVersioner); ok {
+		return v.SnapshotSchemaVersion()
+	}
+	return 0
+}
+
 // validate make sure the aggregate is valid to be saved
 func validate(root AggregateRoot) error {
 	if root.ID() == emptyAggregateID {
@@ -1,10 +1,16 @@
 package eventsourcing_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"testing"
 
+	"github.com/gofrs/uuid"
+
 	memory2 "github.com/hallgren/eventsourcing/eventstore/memory"
 
 	"github.com/hallgren/eventsourcing"
@@ -161,3 +167,308 @@ func TestSaveEmptySnapshotID(t *testing.T) {
 		t.Fatalf("could save blank snapshot id %v", err)
 	}
 }
+
+func TestSnapshotNotAnAggregate(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	s := eventsourcing.SnapshotNew(memsnap.New(), *ser)
+	err := s.Save(&struct{}{})
+	if !errors.Is(err, eventsourcing.ErrNotAnAggregate) {
+		t.Fatalf("expected ErrNotAnAggregate from Save got %v", err)
+	}
+	err = s.Get(context.Background(), eventsourcing.NewUuid(), &struct{}{})
+	if !errors.Is(err, eventsourcing.ErrNotAnAggregate) {
+		t.Fatalf("expected ErrNotAnAggregate from Get got %v", err)
+	}
+}
+
+func TestNoSnapshotStore(t *testing.T) {
+	repo := eventsourcing.NewRepository(memory2.Create(), nil)
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.SaveSnapshot(person)
+	if !errors.Is(err, eventsourcing.ErrNoSnapshotStore) {
+		t.Fatalf("expected ErrNoSnapshotStore from SaveSnapshot got %v", err)
+	}
+	err = repo.DeleteSnapshot(context.Background(), person.ID(), person)
+	if !errors.Is(err, eventsourcing.ErrNoSnapshotStore) {
+		t.Fatalf("expected ErrNoSnapshotStore from DeleteSnapshot got %v", err)
+	}
+}
+
+// migrated is an aggregate where the snapshot state has changed shape from {Name} to {FullName}
+type migrated struct {
+	eventsourcing.AggregateRoot
+	FullName string
+}
+
+func (m *migrated) Transition(e eventsourcing.Event) {
+	switch e := e.Data.(type) {
+	case *Born:
+		m.FullName = e.Name
+	}
+}
+
+func (m *migrated) SnapshotSchemaVersion() int {
+	return 1
+}
+
+func saveOldMigratedSnapshot(t *testing.T, store eventsourcing.SnapshotStore, id uuid.UUID) {
+	state, err := json.Marshal(struct{ Name string }{Name: "kalle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Save(eventsourcing.Snapshot{ID: id, Type: "migrated", Version: 1, SchemaVersion: 0, State: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotSchemaVersionSaved(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	store := memsnap.New()
+	s := eventsourcing.SnapshotNew(store, *ser)
+	repo := eventsourcing.NewRepository(memory2.Create(), s)
+
+	m := migrated{}
+	m.TrackChange(&m, &Born{Name: "kalle"})
+	repo.Save(&m)
+	err := s.Save(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := store.Get(context.Background(), m.ID(), "migrated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.SchemaVersion != 1 {
+		t.Fatalf("expected schema version 1 got %d", snap.SchemaVersion)
+	}
+}
+
+func TestSnapshotMigration(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	store := memsnap.New()
+	s := eventsourcing.SnapshotNew(store, *ser)
+	s.RegisterSnapshotMigration("migrated", 0, func(state []byte) ([]byte, error) {
+		old := struct{ Name string }{}
+		err := json.Unmarshal(state, &old)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(struct{ FullName string }{FullName: old.Name})
+	})
+
+	id := eventsourcing.NewUuid()
+	saveOldMigratedSnapshot(t, store, id)
+
+	m := migrated{}
+	err := s.Get(context.Background(), id, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.FullName != "kalle" {
+		t.Fatalf("expected migrated FullName kalle got %q", m.FullName)
+	}
+}
+
+func TestSnapshotMissingMigration(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	store := memsnap.New()
+	s := eventsourcing.SnapshotNew(store, *ser)
+
+	id := eventsourcing.NewUuid()
+	saveOldMigratedSnapshot(t, store, id)
+
+	m := migrated{}
+	err := s.Get(context.Background(), id, &m)
+	if !errors.Is(err, eventsourcing.ErrSnapshotNotFound) {
+		t.Fatalf("expected ErrSnapshotNotFound got %v", err)
+	}
+}
+
+func TestSnapshotMissingMigrationRebuildFromEvents(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	store := memsnap.New()
+	repo := eventsourcing.NewRepository(memory2.Create(), eventsourcing.SnapshotNew(store, *ser))
+
+	m := migrated{}
+	m.TrackChange(&m, &Born{Name: "kalle"})
+	err := repo.Save(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveOldMigratedSnapshot(t, store, m.ID())
+
+	m2 := migrated{}
+	err = repo.Get(m.ID(), &m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.FullName != "kalle" {
+		t.Fatalf("expected FullName kalle from events got %q", m2.FullName)
+	}
+}
+
+func TestSnapshotUnmarshalFallback(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	store := memsnap.New()
+	s := eventsourcing.SnapshotNew(store, *ser)
+	repo := eventsourcing.NewRepository(memory2.Create(), s)
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	person.GrowOlder()
+	if err = repo.Save(person); err != nil {
+		t.Fatal(err)
+	}
+	// a snapshot that does not unmarshal, e.g. after the aggregate changed shape
+	err = store.Save(eventsourcing.Snapshot{ID: person.ID(), Type: "Person", Version: person.Version(), State: []byte("{corrupt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Person{}
+	if err = repo.Get(person.ID(), &p); err == nil {
+		t.Fatal("expected the unmarshal error without fallback")
+	}
+
+	s.SetUnmarshalFallback(true)
+	p = Person{}
+	if err = repo.Get(person.ID(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "kalle" || p.Age != 2 || p.Version() != 3 {
+		t.Fatalf("expected kalle aged 2 at version 3 from events got %q %d %d", p.Name, p.Age, p.Version())
+	}
+}
+
+func TestSnapshotGlobalVersion(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	s := eventsourcing.SnapshotNew(memsnap.New(), *ser)
+	repo := eventsourcing.NewRepository(memory2.Create(), s)
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	last := person.Events()[1].EventID
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if person.GlobalVersion() != last {
+		t.Fatalf("expected global version %s after save got %s", last, person.GlobalVersion())
+	}
+	err = repo.SaveSnapshot(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// restore the aggregate from the snapshot only
+	restored := Person{}
+	err = s.Get(context.Background(), person.ID(), &restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.GlobalVersion() != last {
+		t.Fatalf("expected global version %s from snapshot got %s", last, restored.GlobalVersion())
+	}
+	if restored.Version() != 2 {
+		t.Fatalf("expected version 2 got %d", restored.Version())
+	}
+}
+
+func gobMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func gobUnmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// cachedPerson holds a field that is excluded from the snapshot
+type cachedPerson struct {
+	eventsourcing.AggregateRoot
+	Name  string
+	Age   int
+	Cache string `snapshot:"-"`
+}
+
+func (c *cachedPerson) Transition(e eventsourcing.Event) {
+	if born, ok := e.Data.(*Born); ok {
+		c.Name = born.Name
+		c.Age = 1
+	}
+}
+
+func TestSnapshotGenericMarshal(t *testing.T) {
+	for _, ser := range []*eventsourcing.Serializer{
+		eventsourcing.NewSerializer(gobMarshal, gobUnmarshal),
+		eventsourcing.NewSerializer(json.Marshal, json.Unmarshal),
+		eventsourcing.NewSerializer(xml.Marshal, xml.Unmarshal),
+	} {
+		s := eventsourcing.SnapshotNew(memory.New(), *ser)
+		repo := eventsourcing.NewRepository(memory2.Create(), s)
+
+		person, err := CreatePerson("kalle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		person.Age = 42
+		repo.Save(person)
+		err = s.Save(person)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := Person{}
+		err = s.Get(context.Background(), person.ID(), &p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Name != "kalle" || p.Age != 42 {
+			t.Fatalf("expected kalle 42 got %s %d", p.Name, p.Age)
+		}
+		if p.Version() != person.Version() {
+			t.Fatalf("wrong version %d %d", p.Version(), person.Version())
+		}
+	}
+}
+
+func TestSnapshotExcludedField(t *testing.T) {
+	ser := eventsourcing.NewSerializer(json.Marshal, json.Unmarshal)
+	s := eventsourcing.SnapshotNew(memory.New(), *ser)
+	repo := eventsourcing.NewRepository(memory2.Create(), s)
+
+	c := cachedPerson{}
+	c.TrackChange(&c, &Born{Name: "kalle"})
+	c.Cache = "cached"
+	repo.Save(&c)
+	err := s.Save(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2 := cachedPerson{}
+	err = s.Get(context.Background(), c.ID(), &c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.Name != "kalle" {
+		t.Fatalf("expected kalle got %s", c2.Name)
+	}
+	if c2.Cache != "" {
+		t.Fatalf("expected the excluded field to be empty got %s", c2.Cache)
+	}
+}
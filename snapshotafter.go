@@ -0,0 +1,55 @@
+package eventsourcing
+
+import (
+	"time"
+)
+
+// snapshotTimesLimit is the number of aggregates with a tracked snapshot time before the expired ones are dropped
+const snapshotTimesLimit = 10000
+
+// SetSnapshotAfter makes Save snapshot the aggregate if more than d has elapsed since the repository last saved a
+// snapshot of it. The times are kept in memory, the first save of an aggregate after the repository is created
+// snapshots it. A failed snapshot is logged and does not fail the save. Zero turns the policy off.
+func (r *Repository) SetSnapshotAfter(d time.Duration) {
+	r.snapshotLock.Lock()
+	defer r.snapshotLock.Unlock()
+	r.snapshotAfter = d
+	r.snapshotTimes = make(map[string]time.Time)
+}
+
+// snapshotIfDue saves a snapshot of the aggregate if the snapshot after duration has elapsed since its last snapshot
+func (r *Repository) snapshotIfDue(aggregate Aggregate) {
+	root := aggregate.Root()
+	key := snapshotKey(root.ID(), aggregateName(aggregate))
+	now := time.Now()
+
+	r.snapshotLock.Lock()
+	if r.snapshotAfter <= 0 || now.Sub(r.snapshotTimes[key]) < r.snapshotAfter {
+		r.snapshotLock.Unlock()
+		return
+	}
+	// claim the snapshot before it's saved to not let concurrent saves of the aggregate snapshot it as well
+	previous, tracked := r.snapshotTimes[key]
+	r.snapshotTimes[key] = now
+	if len(r.snapshotTimes) > snapshotTimesLimit {
+		// expired times are due anyway, dropping them does not change when the aggregates are snapshotted
+		for k, t := range r.snapshotTimes {
+			if now.Sub(t) >= r.snapshotAfter {
+				delete(r.snapshotTimes, k)
+			}
+		}
+	}
+	r.snapshotLock.Unlock()
+
+	err := r.SaveSnapshot(aggregate)
+	if err != nil {
+		r.logger.Error("could not save snapshot", "aggregate_type", aggregateName(aggregate), "aggregate_id", root.ID(), "error", err)
+		r.snapshotLock.Lock()
+		if tracked {
+			r.snapshotTimes[key] = previous
+		} else {
+			delete(r.snapshotTimes, key)
+		}
+		r.snapshotLock.Unlock()
+	}
+}
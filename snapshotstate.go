@@ -0,0 +1,115 @@
+package eventsourcing
+
+import (
+	"encoding/xml"
+	"reflect"
+	"sync"
+)
+
+// snapshotTag is the struct tag used to exclude an exported field from the snapshot, `snapshot:"-"`
+const snapshotTag = "snapshot"
+
+var aggregateRootType = reflect.TypeOf(AggregateRoot{})
+
+// stateTypes caches the generated state struct per aggregate type
+var stateTypes sync.Map
+
+// stateType describes the state struct generated from an aggregate type
+type stateType struct {
+	typ reflect.Type
+	// fields holds the index of the state fields in the aggregate struct
+	fields []int
+	// ok is false when the aggregate can't be mapped and is marshaled as is
+	ok bool
+}
+
+// snapshotStateType returns the state struct of the aggregate type t. The state struct holds the exported fields of
+// the aggregate, without the embedded AggregateRoot and the fields tagged `snapshot:"-"`. Serializers that fail on
+// structs without exported fields (e.g. gob) can then marshal the aggregate state.
+func snapshotStateType(t reflect.Type) stateType {
+	if st, ok := stateTypes.Load(t); ok {
+		return st.(stateType)
+	}
+	st := buildStateType(t)
+	stateTypes.Store(t, st)
+	return st
+}
+
+func buildStateType(t reflect.Type) stateType {
+	if t.Kind() != reflect.Struct {
+		return stateType{}
+	}
+	var fields []reflect.StructField
+	var index []int
+	hasXMLName := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type == aggregateRootType {
+			continue
+		}
+		if f.Anonymous {
+			// embedded structs are flattened by the serializers, keep the aggregate as is
+			return stateType{}
+		}
+		if f.PkgPath != "" || f.Tag.Get(snapshotTag) == "-" {
+			continue
+		}
+		if f.Name == "XMLName" {
+			hasXMLName = true
+		}
+		fields = append(fields, reflect.StructField{Name: f.Name, Type: f.Type, Tag: f.Tag})
+		index = append(index, i)
+	}
+	if !hasXMLName {
+		// keep the element name of the aggregate type as the generated struct has no name
+		fields = append([]reflect.StructField{{
+			Name: "XMLName",
+			Type: reflect.TypeOf(xml.Name{}),
+			Tag:  reflect.StructTag(`xml:"` + t.Name() + `" json:"-"`),
+		}}, fields...)
+	}
+	return stateType{typ: reflect.StructOf(fields), fields: index, ok: true}
+}
+
+// marshalState marshals the exported state of the aggregate
+func marshalState(m MarshalSnapshotFunc, a Aggregate) ([]byte, error) {
+	v := reflect.ValueOf(a)
+	if v.Kind() != reflect.Ptr {
+		return m(a)
+	}
+	v = v.Elem()
+	st := snapshotStateType(v.Type())
+	if !st.ok {
+		return m(a)
+	}
+	state := reflect.New(st.typ).Elem()
+	offset := st.typ.NumField() - len(st.fields)
+	for i, f := range st.fields {
+		state.Field(i + offset).Set(v.Field(f))
+	}
+	return m(state.Addr().Interface())
+}
+
+// unmarshalState unmarshals the state marshaled by marshalState into the aggregate
+func unmarshalState(u UnmarshalSnapshotFunc, b []byte, a Aggregate) error {
+	v := reflect.ValueOf(a)
+	if v.Kind() != reflect.Ptr {
+		return u(b, a)
+	}
+	v = v.Elem()
+	st := snapshotStateType(v.Type())
+	if !st.ok {
+		return u(b, a)
+	}
+	state := reflect.New(st.typ)
+	err := u(b, state.Interface())
+	if err != nil {
+		return err
+	}
+	state = state.Elem()
+	offset := st.typ.NumField() - len(st.fields)
+	for i, f := range st.fields {
+		v.Field(f).Set(state.Field(i + offset))
+	}
+	return nil
+}
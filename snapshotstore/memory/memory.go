@@ -11,13 +11,32 @@ import (
 // Handler of snapshot store
 type Handler struct {
 	store map[string]eventsourcing.Snapshot
+	// history holds the retained snapshots per aggregate sorted by version
+	history map[string][]eventsourcing.Snapshot
+	// retention is the number of snapshots kept per aggregate, 0 keeps the latest only
+	retention int
+}
+
+// Option configures the memory snapshot store
+type Option func(h *Handler)
+
+// WithRetention keeps the last k snapshots of each aggregate for GetAt instead of the latest only
+func WithRetention(k int) Option {
+	return func(h *Handler) {
+		h.retention = k
+	}
 }
 
 // New handler for the snapshot service
-func New() *Handler {
-	return &Handler{
-		store: make(map[string]eventsourcing.Snapshot),
+func New(options ...Option) *Handler {
+	h := &Handler{
+		store:   make(map[string]eventsourcing.Snapshot),
+		history: make(map[string][]eventsourcing.Snapshot),
+	}
+	for _, option := range options {
+		option(h)
 	}
+	return h
 }
 
 // Get returns the deserialize snapshot
@@ -29,8 +48,67 @@ func (h *Handler) Get(ctx context.Context, id uuid.UUID, typ string) (eventsourc
 	return v, nil
 }
 
-// Save persists the snapshot
+// GetAt returns the newest retained snapshot with a version at or below version
+func (h *Handler) GetAt(ctx context.Context, id uuid.UUID, typ string, version eventsourcing.Version) (eventsourcing.Snapshot, error) {
+	key := fmt.Sprintf("%s_%s", id, typ)
+	history := h.history[key]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Version <= version {
+			return history[i], nil
+		}
+	}
+	// without retention only the latest snapshot is kept
+	if v, ok := h.store[key]; ok && v.Version <= version {
+		return v, nil
+	}
+	return eventsourcing.Snapshot{}, eventsourcing.ErrSnapshotNotFound
+}
+
+// Delete removes the snapshot, it's not an error if the snapshot does not exist
+func (h *Handler) Delete(ctx context.Context, id uuid.UUID, typ string) error {
+	key := fmt.Sprintf("%s_%s", id, typ)
+	delete(h.store, key)
+	delete(h.history, key)
+	return nil
+}
+
+// Save persists the snapshot, with retention the oldest snapshots beyond the last k are pruned
 func (h *Handler) Save(s eventsourcing.Snapshot) error {
-	h.store[fmt.Sprintf("%s_%s", s.ID, s.Type)] = s
+	key := fmt.Sprintf("%s_%s", s.ID, s.Type)
+	h.store[key] = s
+	if h.retention > 0 {
+		// replace a snapshot of the same version and keep the history sorted by version
+		var history []eventsourcing.Snapshot
+		for _, old := range h.history[key] {
+			if old.Version != s.Version {
+				history = append(history, old)
+			}
+		}
+		i := len(history)
+		for i > 0 && history[i-1].Version > s.Version {
+			i--
+		}
+		history = append(history[:i], append([]eventsourcing.Snapshot{s}, history[i:]...)...)
+		if len(history) > h.retention {
+			history = history[len(history)-h.retention:]
+		}
+		h.history[key] = history
+	}
 	return nil
 }
+
+// GetMany returns the found snapshots of the aggregates
+func (h *Handler) GetMany(ctx context.Context, ids []uuid.UUID, typ string) (map[uuid.UUID]eventsourcing.Snapshot, error) {
+	snapshots := make(map[uuid.UUID]eventsourcing.Snapshot)
+	for _, id := range ids {
+		if v, ok := h.store[fmt.Sprintf("%s_%s", id, typ)]; ok {
+			snapshots[id] = v
+		}
+	}
+	return snapshots, nil
+}
+
+// History returns the retained snapshots of the aggregate sorted by version
+func (h *Handler) History(id uuid.UUID, typ string) []eventsourcing.Snapshot {
+	return append([]eventsourcing.Snapshot(nil), h.history[fmt.Sprintf("%s_%s", id, typ)]...)
+}
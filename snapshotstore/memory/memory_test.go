@@ -1,6 +1,8 @@
 package memory_test
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/hallgren/eventsourcing"
@@ -21,3 +23,45 @@ func (p *provider) Teardown() {}
 func TestMemorySnapshot(t *testing.T) {
 	suite.Test(t, new(provider))
 }
+
+func TestRetention(t *testing.T) {
+	store := memory.New(memory.WithRetention(3))
+	id := eventsourcing.NewUuid()
+	// saved out of order to check that the history is kept sorted
+	for _, v := range []eventsourcing.Version{10, 30, 20, 50, 40} {
+		err := store.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: v})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	history := store.History(id, "Person")
+	if len(history) != 3 || history[0].Version != 30 || history[2].Version != 50 {
+		t.Fatalf("expected the snapshots 30, 40 and 50 got %v", history)
+	}
+	snap, err := store.GetAt(context.Background(), id, "Person", 45)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 40 {
+		t.Fatalf("expected snapshot version 40 got %d", snap.Version)
+	}
+	_, err = store.GetAt(context.Background(), id, "Person", 25)
+	if !errors.Is(err, eventsourcing.ErrSnapshotNotFound) {
+		t.Fatalf("expected ErrSnapshotNotFound got %v", err)
+	}
+
+	// without retention only the latest snapshot is used
+	latest := memory.New()
+	for _, v := range []eventsourcing.Version{10, 20} {
+		err = latest.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: v})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = latest.GetAt(context.Background(), id, "Person", 15); !errors.Is(err, eventsourcing.ErrSnapshotNotFound) {
+		t.Fatalf("expected ErrSnapshotNotFound got %v", err)
+	}
+	if snap, err = latest.GetAt(context.Background(), id, "Person", 25); err != nil || snap.Version != 20 {
+		t.Fatalf("expected the latest snapshot got %v %v", snap.Version, err)
+	}
+}
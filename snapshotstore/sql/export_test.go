@@ -0,0 +1,14 @@
+package sql
+
+// rollbackStep is a second migration used to test rollback
+var rollbackStep = migration{version: 2, up: []string{`CREATE TABLE rollback_test (id INTEGER);`}, down: []string{`DROP TABLE rollback_test;`}}
+
+// MigrateTestWithStep runs the test migrations and a second step
+func (s *SQL) MigrateTestWithStep() error {
+	return s.migrate([]migration{testMigrations[0], rollbackStep})
+}
+
+// RollbackTest rolls back the test migrations and the second step
+func (s *SQL) RollbackTest(toVersion int) error {
+	return s.rollback([]migration{testMigrations[0], rollbackStep}, toVersion)
+}
@@ -1,34 +1,202 @@
 package sql
 
-import "context"
+import (
+	"context"
+	"database/sql"
+	"time"
+)
 
-const createTable = `CREATE TABLE snapshots (aggregate_id UUID NOT NULL, type VARCHAR, version INTEGER, global_version INTEGER, state BLOB);`
+// createTable is the snapshots table as it was created before the migrations, later columns are added by migrations
+const createTable = `CREATE TABLE IF NOT EXISTS snapshots (aggregate_id UUID NOT NULL, type VARCHAR, version INTEGER, global_version INTEGER, state BLOB);`
 
-// Migrate the database
-func (s *SQL) Migrate() error {
-	sqlStmt := []string{
+// createTestTable is the snapshots table with the columns of all migrations as the test sql driver does not
+// support ALTER TABLE
+const createTestTable = `CREATE TABLE snapshots (aggregate_id UUID NOT NULL, type VARCHAR, version INTEGER, global_version UUID, schema_version INTEGER, state BLOB);`
+const createHistoryTable = `CREATE TABLE IF NOT EXISTS snapshot_history (aggregate_id UUID NOT NULL, type VARCHAR, version INTEGER, global_version UUID, schema_version INTEGER, state BLOB);`
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS snapshot_migrations (version INTEGER PRIMARY KEY, applied_at VARCHAR);`
+
+// migration is a numbered schema change, a migration that is recorded in snapshot_migrations is not run again.
+// down reverses up.
+type migration struct {
+	version int
+	up      []string
+	down    []string
+}
+
+// migrations are the schema changes in the order they are applied, new changes are added as new versions
+var migrations = []migration{
+	{version: 1, up: []string{
 		createTable,
-		`CREATE UNIQUE INDEX id_type ON snapshots (aggregate_id, type);`,
-	}
-	return s.migrate(sqlStmt)
+		`CREATE UNIQUE INDEX IF NOT EXISTS id_type ON snapshots (aggregate_id, type);`,
+	}, down: []string{
+		`DROP TABLE IF EXISTS snapshots;`,
+	}},
+	{version: 2, up: []string{
+		`ALTER TABLE snapshots ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0;`,
+	}, down: []string{
+		`ALTER TABLE snapshots DROP COLUMN schema_version;`,
+	}},
+	// snapshot_history holds the retained snapshots of the stores opened WithRetention
+	{version: 3, up: []string{
+		createHistoryTable,
+		`CREATE UNIQUE INDEX IF NOT EXISTS history_id_type_version ON snapshot_history (aggregate_id, type, version);`,
+	}, down: []string{
+		`DROP TABLE IF EXISTS snapshot_history;`,
+	}},
+	// global_version holds the event id of the last event in the snapshot, the integer positions of the snapshots
+	// saved before are dropped and read as uuid.Nil
+	{version: 4, up: []string{
+		`ALTER TABLE snapshots DROP COLUMN global_version;`,
+		`ALTER TABLE snapshots ADD COLUMN global_version UUID;`,
+	}, down: []string{
+		`ALTER TABLE snapshots DROP COLUMN global_version;`,
+		`ALTER TABLE snapshots ADD COLUMN global_version INTEGER;`,
+	}},
+}
+
+// testMigrations are the migrations without the statements that the test sql driver does not support, the table
+// is created with all columns and dropped without IF EXISTS that the driver can't parse
+var testMigrations = []migration{
+	{version: 1, up: []string{createTestTable}, down: []string{`DROP TABLE snapshots;`}},
+	{version: 2},
+	{version: 3, up: []string{createHistoryTable}, down: []string{`DROP TABLE snapshot_history;`}},
+	{version: 4},
+}
+
+// Migrate the database, the migrations that already has run are skipped which makes it safe to call on each start
+func (s *SQL) Migrate() error {
+	return s.migrate(migrations)
 }
 
 // MigrateTest remove the index that the test sql driver does not support
 func (s *SQL) MigrateTest() error {
-	return s.migrate([]string{createTable})
+	return s.migrate(testMigrations)
+}
+
+// Rollback reverses the applied migrations with a version higher than toVersion, in the opposite order they were
+// applied. Rolling back to version 0 drops the snapshots table.
+func (s *SQL) Rollback(toVersion int) error {
+	return s.rollback(migrations, toVersion)
+}
+
+// SchemaVersion returns the highest applied migration version, 0 if no migration has run. It only reads the
+// migrations table and does not create it.
+func (s *SQL) SchemaVersion() (int, error) {
+	applied, err := appliedMigrations(s.db)
+	if err != nil {
+		// the migrations table is missing until the first Migrate, the error is returned if the database can't be
+		// reached
+		if err := s.db.Ping(); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
 }
 
-func (s *SQL) migrate(stm []string) error {
+func (s *SQL) migrate(steps []migration) error {
 	tx, err := s.db.BeginTx(context.Background(), nil)
 	if err != nil {
-		return nil
+		return err
 	}
 	defer tx.Rollback()
-	for _, b := range stm {
-		_, err := tx.Exec(b)
+	err = s.ensureMigrationsTable(tx)
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(tx)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if applied[step.version] {
+			continue
+		}
+		for _, b := range step.up {
+			_, err := tx.Exec(b)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO snapshot_migrations (version, applied_at) VALUES ($1, $2)`, step.version, time.Now().UTC().Format(time.RFC3339Nano))
 		if err != nil {
 			return err
 		}
 	}
 	return tx.Commit()
 }
+
+func (s *SQL) rollback(steps []migration, toVersion int) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = s.ensureMigrationsTable(tx)
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(tx)
+	if err != nil {
+		return err
+	}
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.version <= toVersion || !applied[step.version] {
+			continue
+		}
+		for _, b := range step.down {
+			_, err := tx.Exec(b)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`DELETE FROM snapshot_migrations WHERE version = $1`, step.version)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ensureMigrationsTable creates snapshot_migrations if it's missing. The existence is checked outside the
+// transaction, a failed statement aborts a Postgres transaction, and not left to IF NOT EXISTS as the test sql driver
+// ignores it.
+func (s *SQL) ensureMigrationsTable(tx *sql.Tx) error {
+	rows, err := s.db.Query(`SELECT version FROM snapshot_migrations`)
+	if err == nil {
+		rows.Close()
+		return nil
+	}
+	_, err = tx.Exec(createMigrationsTable)
+	return err
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// appliedMigrations returns the versions recorded in snapshot_migrations
+func appliedMigrations(q querier) (map[int]bool, error) {
+	rows, err := q.Query(`SELECT version FROM snapshot_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,32 @@
+package sql
+
+import (
+	"strings"
+	"testing"
+)
+
+// baselineTable is the snapshots table created by Migrate before the migrations were numbered
+const baselineTable = `CREATE TABLE snapshots (aggregate_id UUID NOT NULL, type VARCHAR, version INTEGER, global_version INTEGER, state BLOB);`
+
+// The test sql driver does not support ALTER TABLE, the migrations are checked to upgrade a baseline database
+// instead of run on one.
+func TestMigrationsUpgradeBaseline(t *testing.T) {
+	if strings.Replace(migrations[0].up[0], " IF NOT EXISTS", "", 1) != baselineTable {
+		t.Fatalf("expected the first migration to create the baseline table got %s", migrations[0].up[0])
+	}
+	for _, step := range migrations[1:] {
+		for _, stm := range step.up {
+			if strings.HasPrefix(stm, "CREATE TABLE snapshots") || strings.HasPrefix(stm, "CREATE TABLE IF NOT EXISTS snapshots ") {
+				t.Fatalf("migration %d recreates the snapshots table", step.version)
+			}
+			if strings.Contains(stm, "ADD COLUMN") && strings.Contains(stm, "NOT NULL") && !strings.Contains(stm, "DEFAULT") {
+				t.Fatalf("migration %d adds a NOT NULL column without default: %s", step.version, stm)
+			}
+		}
+	}
+	for i, step := range testMigrations {
+		if step.version != migrations[i].version {
+			t.Fatalf("expected test migration %d to have version %d got %d", i, migrations[i].version, step.version)
+		}
+	}
+}
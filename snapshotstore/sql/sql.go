@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/hallgren/eventsourcing"
@@ -12,13 +14,30 @@ import (
 // SQL is the struct holding the underlying database and serializer
 type SQL struct {
 	db *sql.DB
+	// retention is the number of snapshots kept per aggregate in snapshot_history, 0 keeps the latest only
+	retention int
+}
+
+// Option configures the SQL snapshot store
+type Option func(s *SQL)
+
+// WithRetention keeps the last k snapshots of each aggregate in the snapshot_history table for GetAt, the older
+// ones are pruned on save. The snapshots table still holds the latest snapshot only.
+func WithRetention(k int) Option {
+	return func(s *SQL) {
+		s.retention = k
+	}
 }
 
 // New returns a SQL struct
-func New(db *sql.DB) *SQL {
-	return &SQL{
+func New(db *sql.DB, options ...Option) *SQL {
+	s := &SQL{
 		db: db,
 	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 
 // Close the connection
@@ -26,6 +45,26 @@ func (s *SQL) Close() {
 	s.db.Close()
 }
 
+// Stats returns the connection pool statistics of the underlying database
+func (s *SQL) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// SetMaxOpenConns sets the maximum number of open connections to the database
+func (s *SQL) SetMaxOpenConns(n int) {
+	s.db.SetMaxOpenConns(n)
+}
+
+// SetMaxIdleConns sets the maximum number of connections in the idle connection pool
+func (s *SQL) SetMaxIdleConns(n int) {
+	s.db.SetMaxIdleConns(n)
+}
+
+// SetConnMaxLifetime sets the maximum amount of time a connection may be reused
+func (s *SQL) SetConnMaxLifetime(d time.Duration) {
+	s.db.SetConnMaxLifetime(d)
+}
+
 // Get retrieves the persisted snapshot
 func (s *SQL) Get(ctx context.Context, id uuid.UUID, typ string) (eventsourcing.Snapshot, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -36,10 +75,12 @@ func (s *SQL) Get(ctx context.Context, id uuid.UUID, typ string) (eventsourcing.
 	}
 	defer tx.Rollback()
 
-	statement := `SELECT state, version, global_version FROM snapshots WHERE aggregate_id=$1 AND type=$2 LIMIT 1`
+	statement := `SELECT state, version, global_version, schema_version FROM snapshots WHERE aggregate_id=$1 AND type=$2 LIMIT 1`
 	var state []byte
 	var version uint64
-	err = tx.QueryRowContext(ctx, statement, id, typ).Scan(&state, &version)
+	var globalVersion uuid.NullUUID
+	var schemaVersion int
+	err = tx.QueryRowContext(ctx, statement, id, typ).Scan(&state, &version, &globalVersion, &schemaVersion)
 	if err != nil && err != sql.ErrNoRows {
 		return eventsourcing.Snapshot{}, err
 	} else if err == sql.ErrNoRows {
@@ -48,14 +89,62 @@ func (s *SQL) Get(ctx context.Context, id uuid.UUID, typ string) (eventsourcing.
 		return eventsourcing.Snapshot{}, ctx.Err()
 	}
 	snap := eventsourcing.Snapshot{
-		ID:      id,
-		Type:    typ,
-		State:   state,
-		Version: eventsourcing.Version(version),
+		ID:            id,
+		Type:          typ,
+		State:         state,
+		Version:       eventsourcing.Version(version),
+		GlobalVersion: globalVersion.UUID,
+		SchemaVersion: schemaVersion,
 	}
 	return snap, nil
 }
 
+// GetMany retrieves the persisted snapshots of the aggregates in one query, the returned map only holds the
+// found snapshots
+func (s *SQL) GetMany(ctx context.Context, ids []uuid.UUID, typ string) (map[uuid.UUID]eventsourcing.Snapshot, error) {
+	snapshots := make(map[uuid.UUID]eventsourcing.Snapshot)
+	if len(ids) == 0 {
+		return snapshots, nil
+	}
+	var b strings.Builder
+	b.WriteString(`SELECT aggregate_id, state, version, global_version, schema_version FROM snapshots WHERE type=$1 AND aggregate_id IN (`)
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, typ)
+	for i, id := range ids {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "$%d", i+2)
+		args = append(args, id)
+	}
+	b.WriteString(")")
+	rows, err := s.db.QueryContext(ctx, b.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var globalVersion uuid.NullUUID
+		var state []byte
+		var version uint64
+		var schemaVersion int
+		err = rows.Scan(&id, &state, &version, &globalVersion, &schemaVersion)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[id] = eventsourcing.Snapshot{
+			ID:            id,
+			Type:          typ,
+			State:         state,
+			Version:       eventsourcing.Version(version),
+			GlobalVersion: globalVersion.UUID,
+			SchemaVersion: schemaVersion,
+		}
+	}
+	return snapshots, rows.Err()
+}
+
 // Save persists the snapshot
 func (s *SQL) Save(snap eventsourcing.Snapshot) error {
 	tx, err := s.db.BeginTx(context.Background(), nil)
@@ -64,7 +153,7 @@ func (s *SQL) Save(snap eventsourcing.Snapshot) error {
 	}
 	defer tx.Rollback()
 
-	statement := `SELECT id FROM snapshots WHERE id=$1 AND type=$2 LIMIT 1`
+	statement := `SELECT aggregate_id FROM snapshots WHERE aggregate_id=$1 AND type=$2 LIMIT 1`
 	var id string
 	err = tx.QueryRow(statement, snap.ID, snap.Type).Scan(&id)
 	if err != nil && err != sql.ErrNoRows {
@@ -72,18 +161,103 @@ func (s *SQL) Save(snap eventsourcing.Snapshot) error {
 	}
 	if err == sql.ErrNoRows {
 		// insert
-		statement = `INSERT INTO snapshots (state, id, type, version) VALUES ($1, $2, $3, $4)`
-		_, err = tx.Exec(statement, string(snap.State), snap.ID, snap.Type, snap.Version)
+		statement = `INSERT INTO snapshots (state, aggregate_id, type, version, global_version, schema_version) VALUES ($1, $2, $3, $4, $5, $6)`
+		_, err = tx.Exec(statement, string(snap.State), snap.ID, snap.Type, snap.Version, snap.GlobalVersion, snap.SchemaVersion)
 		if err != nil {
 			return err
 		}
 	} else {
-		// update
-		statement = `UPDATE snapshots SET state=$1, version=$2 WHERE id=$3 AND type=$4`
-		_, err = tx.Exec(statement, string(snap.State), snap.Version, snap.ID, snap.Type)
+		// update, a snapshot that is not newer than the stored one is skipped to not overwrite a newer snapshot
+		// saved concurrently
+		statement = `UPDATE snapshots SET state=$1, version=$2, global_version=$3, schema_version=$4 WHERE aggregate_id=$5 AND type=$6 AND version < $7`
+		res, err := tx.Exec(statement, string(snap.State), snap.Version, snap.GlobalVersion, snap.SchemaVersion, snap.ID, snap.Type, snap.Version)
+		if err != nil {
+			return err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return nil
+		}
+	}
+	if s.retention > 0 {
+		err = s.saveHistory(tx, snap)
 		if err != nil {
 			return err
 		}
 	}
 	return tx.Commit()
 }
+
+// saveHistory adds the snapshot to snapshot_history and prunes the snapshots older than the last retention ones
+func (s *SQL) saveHistory(tx *sql.Tx, snap eventsourcing.Snapshot) error {
+	_, err := tx.Exec(`DELETE FROM snapshot_history WHERE aggregate_id=$1 AND type=$2 AND version=$3`, snap.ID, snap.Type, snap.Version)
+	if err != nil {
+		return err
+	}
+	statement := `INSERT INTO snapshot_history (state, aggregate_id, type, version, global_version, schema_version) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.Exec(statement, string(snap.State), snap.ID, snap.Type, snap.Version, snap.GlobalVersion, snap.SchemaVersion)
+	if err != nil {
+		return err
+	}
+	// the version of the oldest snapshot to keep
+	var oldest uint64
+	statement = `SELECT version FROM snapshot_history WHERE aggregate_id=$1 AND type=$2 ORDER BY version DESC LIMIT 1 OFFSET $3`
+	err = tx.QueryRow(statement, snap.ID, snap.Type, s.retention-1).Scan(&oldest)
+	if err == sql.ErrNoRows {
+		// fewer snapshots than the retention
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM snapshot_history WHERE aggregate_id=$1 AND type=$2 AND version < $3`, snap.ID, snap.Type, oldest)
+	return err
+}
+
+// GetAt retrieves the newest snapshot with a version at or below version. With retention the snapshot_history
+// table is searched first, the snapshots table is the fallback for snapshots saved before retention was enabled.
+func (s *SQL) GetAt(ctx context.Context, id uuid.UUID, typ string, version eventsourcing.Version) (eventsourcing.Snapshot, error) {
+	if s.retention > 0 {
+		snap, err := s.getAt(ctx, "snapshot_history", id, typ, version)
+		if err != eventsourcing.ErrSnapshotNotFound {
+			return snap, err
+		}
+	}
+	return s.getAt(ctx, "snapshots", id, typ, version)
+}
+
+func (s *SQL) getAt(ctx context.Context, table string, id uuid.UUID, typ string, version eventsourcing.Version) (eventsourcing.Snapshot, error) {
+	statement := `SELECT state, version, global_version, schema_version FROM ` + table + ` WHERE aggregate_id=$1 AND type=$2 AND version<=$3 ORDER BY version DESC LIMIT 1`
+	var state []byte
+	var snapVersion uint64
+	var globalVersion uuid.NullUUID
+	var schemaVersion int
+	err := s.db.QueryRowContext(ctx, statement, id, typ, uint64(version)).Scan(&state, &snapVersion, &globalVersion, &schemaVersion)
+	if err == sql.ErrNoRows {
+		return eventsourcing.Snapshot{}, eventsourcing.ErrSnapshotNotFound
+	} else if err != nil {
+		return eventsourcing.Snapshot{}, err
+	}
+	return eventsourcing.Snapshot{
+		ID:            id,
+		Type:          typ,
+		State:         state,
+		Version:       eventsourcing.Version(snapVersion),
+		GlobalVersion: globalVersion.UUID,
+		SchemaVersion: schemaVersion,
+	}, nil
+}
+
+// Delete removes the snapshot, and the retained ones with retention, it's not an error if the snapshot does not
+// exist
+func (s *SQL) Delete(ctx context.Context, id uuid.UUID, typ string) error {
+	statement := `DELETE FROM snapshots WHERE aggregate_id=$1 AND type=$2`
+	_, err := s.db.ExecContext(ctx, statement, id, typ)
+	if err != nil || s.retention == 0 {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `DELETE FROM snapshot_history WHERE aggregate_id=$1 AND type=$2`, id, typ)
+	return err
+}
@@ -1,12 +1,15 @@
 package sql_test
 
 import (
+	"context"
 	sqldriver "database/sql"
+	"errors"
 	"fmt"
 	"math/rand"
 	"testing"
 	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/hallgren/eventsourcing"
 	"github.com/hallgren/eventsourcing/snapshotstore/sql"
 	"github.com/hallgren/eventsourcing/snapshotstore/suite"
@@ -42,3 +45,253 @@ func (p *provider) Teardown() { p.db.Close() }
 func TestSQLSnapshotStore(t *testing.T) {
 	suite.Test(t, new(provider))
 }
+
+func TestStats(t *testing.T) {
+	p := provider{}
+	store, err := p.Setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Teardown()
+	es := store.(*sql.SQL)
+
+	es.SetMaxOpenConns(5)
+	es.SetMaxIdleConns(2)
+	es.SetConnMaxLifetime(time.Minute)
+	if es.Stats().MaxOpenConnections != 5 {
+		t.Fatalf("expected max open connections 5 got %d", es.Stats().MaxOpenConnections)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	p := provider{}
+	store, err := p.Setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Teardown()
+	ss := store.(*sql.SQL)
+
+	present := []uuid.UUID{eventsourcing.NewUuid(), eventsourcing.NewUuid()}
+	absent := eventsourcing.NewUuid()
+	for i, id := range present {
+		err = ss.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: eventsourcing.Version(i + 1), State: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a snapshot of another type with the same id is not returned
+	err = ss.Save(eventsourcing.Snapshot{ID: absent, Type: "Car", Version: 1, State: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := ss.GetMany(context.Background(), []uuid.UUID{present[0], absent, present[1]}, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 snapshots got %d", len(snapshots))
+	}
+	if snapshots[present[1]].Version != 2 {
+		t.Fatalf("expected version 2 got %d", snapshots[present[1]].Version)
+	}
+	if _, ok := snapshots[absent]; ok {
+		t.Fatal("expected the absent snapshot to not be returned")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+	db, err := sqldriver.Open("ramsql", fmt.Sprint(seededRand.Intn(99999999)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := sql.New(db)
+	defer ss.Close()
+	// SchemaVersion only reads, the migrations table is not created before Migrate
+	version, err := ss.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("expected schema version 0 before migrate got %d", version)
+	}
+	if _, err = db.Exec(`SELECT version FROM snapshot_migrations`); err == nil {
+		t.Fatal("expected SchemaVersion to not create the migrations table")
+	}
+	err = ss.MigrateTestWithStep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ss.RollbackTest(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err = ss.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("expected schema version 1 got %d", version)
+	}
+	if _, err = db.Exec(`SELECT id FROM rollback_test`); err == nil {
+		t.Fatal("expected the rollback_test table to be dropped")
+	}
+}
+
+func TestSaveOlderVersion(t *testing.T) {
+	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+	db, err := sqldriver.Open("ramsql", fmt.Sprint(seededRand.Intn(99999999)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := sql.New(db)
+	defer ss.Close()
+	err = ss.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := eventsourcing.NewUuid()
+	err = ss.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: 5, State: []byte(`{"v":5}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a stale snapshot is skipped without error
+	err = ss.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: 3, State: []byte(`{"v":3}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := ss.Get(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 5 || string(snap.State) != `{"v":5}` {
+		t.Fatalf("expected snapshot version 5 got %d %s", snap.Version, snap.State)
+	}
+	// a snapshot of the same version is skipped
+	err = ss.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: 5, State: []byte(`{"v":"other"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err = ss.Get(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(snap.State) != `{"v":5}` {
+		t.Fatalf("expected the stored snapshot to be kept got %s", snap.State)
+	}
+}
+
+func TestGetNullGlobalVersion(t *testing.T) {
+	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+	db, err := sqldriver.Open("ramsql", fmt.Sprint(seededRand.Intn(99999999)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := sql.New(db)
+	defer ss.Close()
+	err = ss.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// snapshots saved before the global version was an event id have it NULL
+	id := eventsourcing.NewUuid()
+	_, err = db.Exec(`INSERT INTO snapshots (aggregate_id, type, version, schema_version, state) VALUES ($1, $2, $3, $4, $5)`, id, "Person", 2, 0, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := ss.Get(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 2 || snap.GlobalVersion != uuid.Nil {
+		t.Fatalf("expected version 2 without global version got %d %s", snap.Version, snap.GlobalVersion)
+	}
+	snapshots, err := ss.GetMany(context.Background(), []uuid.UUID{id}, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshots[id].GlobalVersion != uuid.Nil {
+		t.Fatalf("expected no global version got %s", snapshots[id].GlobalVersion)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+	db, err := sqldriver.Open("ramsql", fmt.Sprint(seededRand.Intn(99999999)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := sql.New(db, sql.WithRetention(2))
+	defer ss.Close()
+	err = ss.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := eventsourcing.NewUuid()
+	for v := 1; v <= 4; v++ {
+		err = ss.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: eventsourcing.Version(v * 10), State: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM snapshot_history`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 retained snapshots got %d", count)
+	}
+	snap, err := ss.GetAt(context.Background(), id, "Person", 35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 30 {
+		t.Fatalf("expected snapshot version 30 got %d", snap.Version)
+	}
+	_, err = ss.GetAt(context.Background(), id, "Person", 25)
+	if !errors.Is(err, eventsourcing.ErrSnapshotNotFound) {
+		t.Fatalf("expected the pruned snapshot to not be found got %v", err)
+	}
+	snap, err = ss.Get(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 40 {
+		t.Fatalf("expected the latest snapshot version 40 got %d", snap.Version)
+	}
+}
+
+func TestRetentionFallback(t *testing.T) {
+	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+	db, err := sqldriver.Open("ramsql", fmt.Sprint(seededRand.Intn(99999999)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := sql.New(db)
+	defer ss.Close()
+	err = ss.MigrateTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := eventsourcing.NewUuid()
+	err = ss.Save(eventsourcing.Snapshot{ID: id, Type: "Person", Version: 10, State: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the snapshot saved before retention was enabled is only in the snapshots table
+	retained := sql.New(db, sql.WithRetention(2))
+	snap, err := retained.GetAt(context.Background(), id, "Person", 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 10 {
+		t.Fatalf("expected snapshot version 10 got %d", snap.Version)
+	}
+}
@@ -2,6 +2,7 @@ package suite
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/hallgren/eventsourcing"
@@ -22,6 +23,7 @@ func Test(t *testing.T, provider storeProvider) {
 		run   func(t *testing.T, es eventsourcing.SnapshotStore)
 	}{
 		{"Basics", TestSnapshot},
+		{"Delete", TestDelete},
 	}
 	store, err := provider.Setup()
 	if err != nil {
@@ -39,10 +41,12 @@ func Test(t *testing.T, provider storeProvider) {
 func TestSnapshot(t *testing.T, snapshot eventsourcing.SnapshotStore) {
 	id := eventsourcing.NewUuid()
 	snap := eventsourcing.Snapshot{
-		Version: 10,
-		ID:      id,
-		Type:    "Person",
-		State:   []byte{},
+		Version:       10,
+		GlobalVersion: eventsourcing.NewUuid(),
+		SchemaVersion: 2,
+		ID:            id,
+		Type:          "Person",
+		State:         []byte{},
 	}
 
 	err := snapshot.Save(snap)
@@ -63,7 +67,47 @@ func TestSnapshot(t *testing.T, snapshot eventsourcing.SnapshotStore) {
 	if snap.Version != snap2.Version {
 		t.Fatalf("wrong Version in snapshot %q expected: %q", snap.Version, snap2.Version)
 	}
+	if snap.GlobalVersion != snap2.GlobalVersion {
+		t.Fatalf("wrong GlobalVersion in snapshot %s expected: %s", snap2.GlobalVersion, snap.GlobalVersion)
+	}
+	if snap.SchemaVersion != snap2.SchemaVersion {
+		t.Fatalf("wrong SchemaVersion in snapshot %d expected: %d", snap2.SchemaVersion, snap.SchemaVersion)
+	}
 	if string(snap.State) != string(snap2.State) {
 		t.Fatalf("wrong State in snapshot %q expected: %q", snap.State, snap2.State)
 	}
 }
+
+func TestDelete(t *testing.T, store eventsourcing.SnapshotStore) {
+	snapshot, ok := store.(interface {
+		eventsourcing.SnapshotStore
+		eventsourcing.SnapshotDeleter
+	})
+	if !ok {
+		t.Skip("the snapshot store does not implement SnapshotDeleter")
+	}
+	id := eventsourcing.NewUuid()
+	snap := eventsourcing.Snapshot{
+		Version: 10,
+		ID:      id,
+		Type:    "Person",
+		State:   []byte{},
+	}
+	err := snapshot.Save(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = snapshot.Delete(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatalf("could not delete snapshot %v", err)
+	}
+	_, err = snapshot.Get(context.Background(), id, "Person")
+	if !errors.Is(err, eventsourcing.ErrSnapshotNotFound) {
+		t.Fatalf("expected ErrSnapshotNotFound after delete got %v", err)
+	}
+	// deleting a none existing snapshot is not an error
+	err = snapshot.Delete(context.Background(), id, "Person")
+	if err != nil {
+		t.Fatalf("delete of none existing snapshot should not fail %v", err)
+	}
+}
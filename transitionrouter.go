@@ -0,0 +1,46 @@
+package eventsourcing
+
+import "reflect"
+
+// TransitionRouter dispatches events to handlers registered per event data type, it replaces the type switch in
+// Transition of aggregates with many events. On wraps each handler in a closure that type asserts the event data,
+// Apply calls the closures in registration order until one accepts the data so no reflection is done per event.
+type TransitionRouter struct {
+	handlers []func(data interface{}) bool
+	// index holds the position of the handler per type, it's only used by On to replace a handler
+	index map[reflect.Type]int
+}
+
+// NewTransitionRouter returns a router without handlers
+func NewTransitionRouter() *TransitionRouter {
+	return &TransitionRouter{index: make(map[reflect.Type]int)}
+}
+
+// On registers fn as the handler of events with data of type *E, a second handler for the type replaces the first
+func On[E interface{}](router *TransitionRouter, fn func(data *E)) {
+	handler := func(data interface{}) bool {
+		d, ok := data.(*E)
+		if !ok {
+			return false
+		}
+		fn(d)
+		return true
+	}
+	t := reflect.TypeOf((*E)(nil))
+	if i, ok := router.index[t]; ok {
+		router.handlers[i] = handler
+		return
+	}
+	router.index[t] = len(router.handlers)
+	router.handlers = append(router.handlers, handler)
+}
+
+// Apply calls the handler registered for the event data type, false is returned if there is none
+func (r *TransitionRouter) Apply(event Event) bool {
+	for _, handler := range r.handlers {
+		if handler(event.Data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package eventsourcing_test
+
+import (
+	"testing"
+
+	"github.com/hallgren/eventsourcing"
+)
+
+// RoutedPerson is the Person aggregate with the events dispatched by a transition router
+type RoutedPerson struct {
+	eventsourcing.AggregateRoot
+	Name   string
+	Age    int
+	router *eventsourcing.TransitionRouter
+}
+
+// Transition the person state with the handlers registered on the router
+func (person *RoutedPerson) Transition(event eventsourcing.Event) {
+	if person.router == nil {
+		person.router = eventsourcing.NewTransitionRouter()
+		eventsourcing.On(person.router, func(e *Born) {
+			person.Age = 0
+			person.Name = e.Name
+		})
+		eventsourcing.On(person.router, func(e *AgedOneYear) {
+			person.Age++
+		})
+	}
+	person.router.Apply(event)
+}
+
+func TestTransitionRouter(t *testing.T) {
+	person := &RoutedPerson{}
+	person.TrackChange(person, &Born{Name: "kalle"})
+	person.TrackChange(person, &AgedOneYear{})
+	person.TrackChange(person, &AgedOneYear{})
+	if person.Name != "kalle" || person.Age != 2 {
+		t.Fatalf("expected kalle aged 2 got %q %d", person.Name, person.Age)
+	}
+
+	router := eventsourcing.NewTransitionRouter()
+	if router.Apply(eventsourcing.Event{Data: &Born{}}) {
+		t.Fatal("expected no handler for Born")
+	}
+
+	var name string
+	eventsourcing.On(router, func(e *Born) { name = "first" })
+	eventsourcing.On(router, func(e *Born) { name = e.Name })
+	if !router.Apply(eventsourcing.Event{Data: &Born{Name: "second"}}) || name != "second" {
+		t.Fatalf("expected the second handler to replace the first got %q", name)
+	}
+	if router.Apply(eventsourcing.Event{Data: &AgedOneYear{}}) {
+		t.Fatal("expected no handler for AgedOneYear")
+	}
+}
+
+func BenchmarkTransitionRouter(b *testing.B) {
+	person := &RoutedPerson{}
+	event := eventsourcing.Event{Data: &AgedOneYear{}}
+	person.Transition(event)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		person.Transition(event)
+	}
+}
@@ -0,0 +1,44 @@
+package eventsourcing
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid"
+)
+
+// TypedRepository is a repository for one aggregate type. The aggregate root methods have pointer receivers
+// which makes only pointers to aggregates satisfy the Aggregate constraint, the pointer check in
+// Repository.Get is done by the compiler instead.
+type TypedRepository[T Aggregate] struct {
+	repo    *Repository
+	factory func() T
+}
+
+// NewTypedRepository returns a typed repository that uses the factory to create the aggregates it builds
+func NewTypedRepository[T Aggregate](repo *Repository, factory func() T) *TypedRepository[T] {
+	return &TypedRepository[T]{
+		repo:    repo,
+		factory: factory,
+	}
+}
+
+// Get builds a new aggregate from the factory from its snapshot and events
+func (r *TypedRepository[T]) Get(ctx context.Context, id uuid.UUID) (T, error) {
+	aggregate := r.factory()
+	err := r.repo.GetWithContext(ctx, id, aggregate)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return aggregate, nil
+}
+
+// Save the aggregates events
+func (r *TypedRepository[T]) Save(aggregate T) error {
+	return r.repo.Save(aggregate)
+}
+
+// Repository returns the underlying repository
+func (r *TypedRepository[T]) Repository() *Repository {
+	return r.repo
+}
@@ -0,0 +1,47 @@
+package eventsourcing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hallgren/eventsourcing"
+	"github.com/hallgren/eventsourcing/eventstore/memory"
+)
+
+func TestTypedRepository(t *testing.T) {
+	repo := eventsourcing.NewTypedRepository(eventsourcing.NewRepository(memory.Create(), nil), func() *Person { return &Person{} })
+
+	person, err := CreatePerson("kalle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.GrowOlder()
+	err = repo.Save(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := repo.Get(context.Background(), person.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Name != "kalle" || fetched.Age != 1 {
+		t.Fatalf("expected kalle aged 1 got %s aged %d", fetched.Name, fetched.Age)
+	}
+	if fetched.Version() != person.Version() {
+		t.Fatalf("expected version %d got %d", person.Version(), fetched.Version())
+	}
+}
+
+func TestTypedRepositoryNotFound(t *testing.T) {
+	repo := eventsourcing.NewTypedRepository(eventsourcing.NewRepository(memory.Create(), nil), func() *Person { return &Person{} })
+
+	person, err := repo.Get(context.Background(), eventsourcing.NewUuid())
+	if !errors.Is(err, eventsourcing.ErrAggregateNotFound) {
+		t.Fatalf("expected ErrAggregateNotFound got %v", err)
+	}
+	if person != nil {
+		t.Fatal("expected a nil aggregate")
+	}
+}